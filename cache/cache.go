@@ -1,34 +1,242 @@
 package cache
 
-import "sync"
+import (
+	"container/list"
+	"sync"
+	"time"
+)
 
-// Store uses for temporary storing store from commands until it persistence after '/done'-command
-type Store struct {
-	rwMu  sync.RWMutex
-	store map[string]interface{}
+// EvictionReason tells why an entry has left the store
+type EvictionReason int
+
+const (
+	// Expired entries have outlived their TTL
+	Expired EvictionReason = iota + 1
+	// Evicted entries were the least recently used ones when the store exceeded MaxEntries
+	Evicted
+)
+
+const defaultCleanupInterval = time.Minute
+
+func (r EvictionReason) String() string {
+	switch r {
+	case Expired:
+		return "expired"
+	case Evicted:
+		return "evicted"
+	default:
+		return "unknown"
+	}
+}
+
+// EvictFunc is called for every expired or evicted entry outside of the store lock, Delete doesn't call it
+type EvictFunc[K comparable, V any] func(key K, value V, reason EvictionReason)
+
+// Options limits the store, zero values mean no limits
+type Options[K comparable, V any] struct {
+	// TTL is the lifetime of the entries saved with Store, it's renewed on every save
+	TTL time.Duration
+	// MaxEntries is the number of entries after which the least recently used ones are evicted
+	MaxEntries int
+	// CleanupInterval is how often the janitor removes the expired entries, a minute by default
+	CleanupInterval time.Duration
+	OnEvict         EvictFunc[K, V]
+}
+
+// Store uses for temporary storing store from commands until it persistence after '/done'-command.
+// Expired entries are never returned, they are removed on access and by the janitor
+type Store[K comparable, V any] struct {
+	mu         sync.Mutex
+	store      map[K]*list.Element
+	lru        *list.List
+	ttl        time.Duration
+	maxEntries int
+	onEvict    []EvictFunc[K, V]
+	now        func() time.Time
+	stopCh     chan struct{}
+	stopOnce   sync.Once
+}
+
+type entry[K comparable, V any] struct {
+	key   K
+	value V
+	// expiresAt is zero for the entries without TTL
+	expiresAt time.Time
+}
+
+type eviction[K comparable, V any] struct {
+	entry  *entry[K, V]
+	reason EvictionReason
+}
+
+// NewStore creates a store without limits and without the janitor
+func NewStore[K comparable, V any]() *Store[K, V] {
+	return &Store[K, V]{
+		store: make(map[K]*list.Element),
+		lru:   list.New(),
+		now:   time.Now,
+	}
 }
 
-func NewStore() *Store {
-	return &Store{
-		store: make(map[string]interface{}),
+// NewStoreWithOptions creates a limited store and starts its janitor, Close stops it
+func NewStoreWithOptions[K comparable, V any](opts Options[K, V]) *Store[K, V] {
+	p := NewStore[K, V]()
+	p.ttl = opts.TTL
+	p.maxEntries = opts.MaxEntries
+	if opts.OnEvict != nil {
+		p.onEvict = append(p.onEvict, opts.OnEvict)
+	}
+	p.stopCh = make(chan struct{})
+
+	interval := opts.CleanupInterval
+	if interval <= 0 {
+		interval = defaultCleanupInterval
 	}
+	go p.janitor(interval)
+
+	return p
 }
 
-func (p *Store) Load(key string) interface{} {
-	p.rwMu.RLock()
-	defer p.rwMu.RUnlock()
+// OnEvict adds an eviction callback, the callbacks are called in the order they were added
+func (p *Store[K, V]) OnEvict(fn EvictFunc[K, V]) {
+	p.mu.Lock()
+	p.onEvict = append(p.onEvict, fn)
+	p.mu.Unlock()
+}
+
+// Load returns false for a missing or expired entry
+func (p *Store[K, V]) Load(key K) (V, bool) {
+	var zero V
+
+	p.mu.Lock()
+	el, ok := p.store[key]
+	if !ok {
+		p.mu.Unlock()
+		return zero, false
+	}
 
-	return p.store[key]
+	e := el.Value.(*entry[K, V])
+	if e.expired(p.now()) {
+		p.remove(el)
+		onEvict := p.onEvict
+		p.mu.Unlock()
+
+		notify(onEvict, eviction[K, V]{entry: e, reason: Expired})
+		return zero, false
+	}
+
+	p.lru.MoveToFront(el)
+	p.mu.Unlock()
+
+	return e.value, true
 }
 
-func (p *Store) Store(key string, value interface{}) {
-	p.rwMu.Lock()
-	p.store[key] = value
-	p.rwMu.Unlock()
+// Store saves the value with the TTL of the store
+func (p *Store[K, V]) Store(key K, value V) {
+	p.StoreWithTTL(key, value, p.ttl)
 }
 
-func (p *Store) Delete(key string) {
-	p.rwMu.Lock()
-	delete(p.store, key)
-	p.rwMu.Unlock()
+// StoreWithTTL saves the value which expires after ttl, zero ttl keeps the value until it's deleted or evicted
+func (p *Store[K, V]) StoreWithTTL(key K, value V, ttl time.Duration) {
+	var expiresAt time.Time
+	if ttl > 0 {
+		expiresAt = p.now().Add(ttl)
+	}
+
+	p.mu.Lock()
+	if el, ok := p.store[key]; ok {
+		e := el.Value.(*entry[K, V])
+		e.value = value
+		e.expiresAt = expiresAt
+		p.lru.MoveToFront(el)
+		p.mu.Unlock()
+		return
+	}
+
+	p.store[key] = p.lru.PushFront(&entry[K, V]{key: key, value: value, expiresAt: expiresAt})
+
+	var evicted []eviction[K, V]
+	for p.maxEntries > 0 && p.lru.Len() > p.maxEntries {
+		el := p.lru.Back()
+		p.remove(el)
+		evicted = append(evicted, eviction[K, V]{entry: el.Value.(*entry[K, V]), reason: Evicted})
+	}
+	onEvict := p.onEvict
+	p.mu.Unlock()
+
+	notify(onEvict, evicted...)
+}
+
+func (p *Store[K, V]) Delete(key K) {
+	p.mu.Lock()
+	if el, ok := p.store[key]; ok {
+		p.remove(el)
+	}
+	p.mu.Unlock()
+}
+
+// Len returns the number of entries including the expired ones which haven't been removed yet
+func (p *Store[K, V]) Len() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	return p.lru.Len()
+}
+
+// Close stops the janitor, the store is usable after it
+func (p *Store[K, V]) Close() error {
+	if p.stopCh != nil {
+		p.stopOnce.Do(func() { close(p.stopCh) })
+	}
+
+	return nil
+}
+
+func (p *Store[K, V]) janitor(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-p.stopCh:
+			return
+		case <-ticker.C:
+			p.deleteExpired()
+		}
+	}
+}
+
+func (p *Store[K, V]) deleteExpired() {
+	p.mu.Lock()
+	now := p.now()
+	var expired []eviction[K, V]
+	for el := p.lru.Back(); el != nil; {
+		prev := el.Prev()
+		if e := el.Value.(*entry[K, V]); e.expired(now) {
+			p.remove(el)
+			expired = append(expired, eviction[K, V]{entry: e, reason: Expired})
+		}
+		el = prev
+	}
+	onEvict := p.onEvict
+	p.mu.Unlock()
+
+	notify(onEvict, expired...)
+}
+
+func (p *Store[K, V]) remove(el *list.Element) {
+	p.lru.Remove(el)
+	delete(p.store, el.Value.(*entry[K, V]).key)
+}
+
+func (e *entry[K, V]) expired(now time.Time) bool {
+	return !e.expiresAt.IsZero() && !e.expiresAt.After(now)
+}
+
+func notify[K comparable, V any](onEvict []EvictFunc[K, V], evictions ...eviction[K, V]) {
+	for _, ev := range evictions {
+		for _, fn := range onEvict {
+			fn(ev.entry.key, ev.entry.value, ev.reason)
+		}
+	}
 }
@@ -2,6 +2,7 @@ package cache
 
 import (
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 )
@@ -25,9 +26,11 @@ func TestStore_Store_Load(t *testing.T) {
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			p := NewStore()
+			p := NewStore[string, interface{}]()
 			p.Store(tt.args.key, tt.args.value)
-			assert.Equal(t, tt.args.value, p.Load(tt.args.key))
+			value, ok := p.Load(tt.args.key)
+			assert.True(t, ok)
+			assert.Equal(t, tt.args.value, value)
 		})
 	}
 }
@@ -56,11 +59,107 @@ func TestStore_Delete(t *testing.T) {
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			p := NewStore()
+			p := NewStore[string, interface{}]()
 			p.Store(tt.storedData.id, tt.storedData.data)
-			assert.Equal(t, tt.storedData.data, p.Load(tt.storedData.id))
+			value, _ := p.Load(tt.storedData.id)
+			assert.Equal(t, tt.storedData.data, value)
 			p.Delete(tt.args.id)
-			assert.Nil(t, p.Load(tt.storedData.id))
+			_, ok := p.Load(tt.storedData.id)
+			assert.False(t, ok)
 		})
 	}
 }
+
+func TestStore_TTL(t *testing.T) {
+	tests := []struct {
+		name    string
+		ttl     time.Duration
+		elapsed time.Duration
+		want    int
+		wantOK  bool
+		evicted []string
+	}{
+		{name: "alive", ttl: time.Hour, elapsed: time.Minute, want: 123, wantOK: true},
+		{name: "expired", ttl: time.Hour, elapsed: time.Hour, want: 0, evicted: []string{"key expired"}},
+		{name: "without ttl", ttl: 0, elapsed: 24 * time.Hour, want: 123, wantOK: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			now := time.Now()
+			var evicted []string
+			p := NewStore[string, int]()
+			p.OnEvict(func(key string, _ int, reason EvictionReason) {
+				evicted = append(evicted, key+" "+reason.String())
+			})
+			p.now = func() time.Time { return now }
+
+			p.StoreWithTTL("key", 123, tt.ttl)
+			now = now.Add(tt.elapsed)
+			value, ok := p.Load("key")
+			assert.Equal(t, tt.want, value)
+			assert.Equal(t, tt.wantOK, ok)
+			assert.Equal(t, tt.evicted, evicted)
+		})
+	}
+}
+
+func TestStore_MaxEntries(t *testing.T) {
+	var evicted []string
+	p := NewStoreWithOptions(Options[string, int]{
+		MaxEntries: 2,
+		OnEvict: func(key string, value int, reason EvictionReason) {
+			evicted = append(evicted, key+" "+reason.String())
+		},
+	})
+	defer p.Close()
+
+	p.Store("first", 1)
+	p.Store("second", 2)
+	// loading makes the first entry the recently used one
+	_, ok := p.Load("first")
+	assert.True(t, ok)
+	p.Store("third", 3)
+
+	assert.Equal(t, []string{"second evicted"}, evicted)
+	_, ok = p.Load("second")
+	assert.False(t, ok)
+	_, ok = p.Load("first")
+	assert.True(t, ok)
+	_, ok = p.Load("third")
+	assert.True(t, ok)
+	assert.Equal(t, 2, p.Len())
+}
+
+func TestStore_deleteExpired(t *testing.T) {
+	now := time.Now()
+	var evicted []string
+	p := NewStoreWithOptions(Options[string, int]{
+		TTL:             time.Hour,
+		CleanupInterval: time.Hour,
+		OnEvict: func(key string, value int, reason EvictionReason) {
+			evicted = append(evicted, key+" "+reason.String())
+		},
+	})
+	p.now = func() time.Time { return now }
+	defer p.Close()
+
+	p.Store("old", 1)
+	now = now.Add(30 * time.Minute)
+	p.Store("new", 2)
+	p.StoreWithTTL("forever", 3, 0)
+	now = now.Add(45 * time.Minute)
+
+	p.deleteExpired()
+	assert.Equal(t, []string{"old expired"}, evicted)
+	assert.Equal(t, 2, p.Len())
+	value, ok := p.Load("new")
+	assert.True(t, ok)
+	assert.Equal(t, 2, value)
+}
+
+func TestStore_Close(t *testing.T) {
+	p := NewStoreWithOptions(Options[string, int]{CleanupInterval: time.Millisecond})
+	assert.NoError(t, p.Close())
+	assert.NoError(t, p.Close())
+	assert.NoError(t, NewShared().Close())
+}
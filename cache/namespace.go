@@ -0,0 +1,74 @@
+package cache
+
+import (
+	"strings"
+	"time"
+)
+
+// namespaceSeparator divides the namespace and the key of an entry in the shared store
+const namespaceSeparator = ":"
+
+// Shared is the store the features share, each of them works with its own Namespace
+type Shared = Store[string, any]
+
+// NewShared creates a shared store without limits, see NewStore
+func NewShared() *Shared {
+	return NewStore[string, any]()
+}
+
+// Namespace is a typed view of the shared store, its keys are prefixed with the name of the namespace,
+// so the features could use the same keys (e.g. user IDs) without collisions
+type Namespace[V any] struct {
+	store  *Shared
+	prefix string
+}
+
+func NewNamespace[V any](store *Shared, name string) *Namespace[V] {
+	return &Namespace[V]{store: store, prefix: name + namespaceSeparator}
+}
+
+// Load returns false for a missing or expired entry and for a value of another type
+func (n *Namespace[V]) Load(key string) (V, bool) {
+	var zero V
+
+	value, ok := n.store.Load(n.prefix + key)
+	if !ok {
+		return zero, false
+	}
+
+	typed, ok := value.(V)
+	if !ok {
+		return zero, false
+	}
+
+	return typed, true
+}
+
+// Store saves the value with the TTL of the shared store
+func (n *Namespace[V]) Store(key string, value V) {
+	n.store.Store(n.prefix+key, value)
+}
+
+func (n *Namespace[V]) StoreWithTTL(key string, value V, ttl time.Duration) {
+	n.store.StoreWithTTL(n.prefix+key, value, ttl)
+}
+
+func (n *Namespace[V]) Delete(key string) {
+	n.store.Delete(n.prefix + key)
+}
+
+// OnEvict adds a callback which is called only for the entries of the namespace
+func (n *Namespace[V]) OnEvict(fn EvictFunc[string, V]) {
+	n.store.OnEvict(func(key string, value any, reason EvictionReason) {
+		if !strings.HasPrefix(key, n.prefix) {
+			return
+		}
+
+		typed, ok := value.(V)
+		if !ok {
+			return
+		}
+
+		fn(strings.TrimPrefix(key, n.prefix), typed, reason)
+	})
+}
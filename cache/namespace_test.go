@@ -0,0 +1,71 @@
+package cache
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNamespace(t *testing.T) {
+	type draft struct{ name string }
+	tests := []struct {
+		name   string
+		stored interface{}
+		want   *draft
+		wantOK bool
+	}{
+		{name: "value of the namespace", stored: &draft{name: "poll"}, want: &draft{name: "poll"}, wantOK: true},
+		{name: "value of another type", stored: "poll", want: nil, wantOK: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			shared := NewShared()
+			drafts := NewNamespace[*draft](shared, "drafts")
+			other := NewNamespace[int](shared, "other")
+
+			shared.Store("drafts:1234", tt.stored)
+			other.Store("1234", 42)
+
+			value, ok := drafts.Load("1234")
+			assert.Equal(t, tt.want, value)
+			assert.Equal(t, tt.wantOK, ok)
+
+			value, ok = drafts.Load("4321")
+			assert.Nil(t, value)
+			assert.False(t, ok)
+
+			count, ok := other.Load("1234")
+			assert.True(t, ok)
+			assert.Equal(t, 42, count)
+
+			drafts.Delete("1234")
+			_, ok = drafts.Load("1234")
+			assert.False(t, ok)
+			_, ok = other.Load("1234")
+			assert.True(t, ok)
+		})
+	}
+}
+
+func TestNamespace_OnEvict(t *testing.T) {
+	now := time.Now()
+	shared := NewShared()
+	shared.now = func() time.Time { return now }
+
+	drafts := NewNamespace[string](shared, "drafts")
+	other := NewNamespace[string](shared, "other")
+
+	var evicted []string
+	drafts.OnEvict(func(key string, value string, reason EvictionReason) {
+		evicted = append(evicted, key+" "+value+" "+reason.String())
+	})
+
+	drafts.StoreWithTTL("1", "draft", time.Minute)
+	other.StoreWithTTL("1", "other", time.Minute)
+	now = now.Add(time.Hour)
+	shared.deleteExpired()
+
+	assert.Equal(t, []string{"1 draft expired"}, evicted)
+	assert.Zero(t, shared.Len())
+}
@@ -1,16 +1,227 @@
 package domain
 
-import "fmt"
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	// pollIDLength is the number of random bytes in a poll ID, 9 bytes give 12 URL-safe symbols
+	pollIDLength = 9
+	// saltLength is the number of random bytes mixed into the voter hashes of an anonymous poll
+	saltLength = 16
+	// MaxInlineMessages bounds the messages the poll is kept up to date in, the oldest ones are forgotten
+	MaxInlineMessages = 50
+)
 
 type Poll struct {
+	// ID identifies the poll in inline results and callback data, it never changes
+	ID        string              `json:"id"`
 	Subject   string              `json:"subject"`
 	CreatedAt int64               `json:"created_at"`
 	Items     []string            `json:"items"`
 	CreatedBy string              `json:"created_by"`
 	Votes     map[string][]string `json:"votes"`
+	// MultipleChoice allows a voter to choose several items, a repeated vote for an item withdraws it
+	MultipleChoice bool `json:"multiple_choice"`
+	// Anonymous polls keep salted hashes of the voters instead of their names and show only the numbers
+	Anonymous bool `json:"anonymous"`
+	// Salt is generated for anonymous polls, so the same user has different hashes in different polls
+	Salt string `json:"salt,omitempty"`
+	// ClosesAt is the optional time in nanoseconds when the voting ends
+	ClosesAt int64 `json:"closes_at,omitempty"`
+	// ClosedAt is set when the poll is closed and its final results are posted
+	ClosedAt int64 `json:"closed_at,omitempty"`
+	// InlineMessageIDs are the last messages the poll has been posted into, see MaxInlineMessages
+	InlineMessageIDs []string `json:"inline_message_ids,omitempty"`
+	// Version is increased on every change of votes, it guards against concurrent updates
+	Version int64 `json:"version"`
+	// ItemsRevision is increased when the items are edited, the buttons posted before the edit carry the old one
+	ItemsRevision int64 `json:"items_revision,omitempty"`
+}
+
+// IsClosed reports whether the voting has ended: the poll was closed or its close time has passed
+func (p Poll) IsClosed(now time.Time) bool {
+	return p.ClosedAt != 0 || (p.ClosesAt != 0 && now.UnixNano() >= p.ClosesAt)
+}
+
+// Close ends the voting
+func (p *Poll) Close(now time.Time) {
+	p.ClosedAt = now.UnixNano()
+	p.Version++
+}
+
+// Reopen resumes the voting, the passed close time is dropped, otherwise the poll would be closed again
+func (p *Poll) Reopen(now time.Time) {
+	p.ClosedAt = 0
+	if p.ClosesAt != 0 && now.UnixNano() >= p.ClosesAt {
+		p.ClosesAt = 0
+	}
+	p.Version++
+}
+
+// HasInlineMessage reports whether the message is known to contain the poll
+func (p Poll) HasInlineMessage(inlineMessageID string) bool {
+	return containsString(p.InlineMessageIDs, inlineMessageID)
+}
+
+// AddInlineMessage remembers the message the poll has been posted into and forgets the oldest one above the limit,
+// it reports whether the message is new
+func (p *Poll) AddInlineMessage(inlineMessageID string) bool {
+	if p.HasInlineMessage(inlineMessageID) {
+		return false
+	}
+
+	ids := append(p.InlineMessageIDs, inlineMessageID)
+	if len(ids) > MaxInlineMessages {
+		ids = ids[len(ids)-MaxInlineMessages:]
+	}
+	p.InlineMessageIDs = append([]string(nil), ids...)
+	p.Version++
+
+	return true
+}
+
+// Vote moves the voter to the item, removing the previous vote of the same voter.
+// For multiple choice polls it toggles the vote for the item and keeps the other votes
+func (p *Poll) Vote(item, voter string) {
+	if p.Votes == nil {
+		p.Votes = make(map[string][]string)
+	}
+
+	if p.MultipleChoice {
+		if !p.removeVote(item, voter) {
+			p.Votes[item] = append(p.Votes[item], voter)
+		}
+		p.Version++
+
+		return
+	}
+
+	// delete previous vote fo the user
+	for item := range p.Votes {
+		p.removeVote(item, voter)
+	}
+
+	// add user to vote item
+	p.Votes[item] = append(p.Votes[item], voter)
+	p.Version++
+}
+
+// SetItems replaces the items of the poll. kept maps the previous items to their new names:
+// votes follow a renamed item and votes of an item which isn't kept are dropped, so its voters could vote again
+func (p *Poll) SetItems(items []string, kept map[string]string) {
+	votes := make(map[string][]string)
+	for item, users := range p.Votes {
+		newItem, ok := kept[item]
+		if !ok || !containsString(items, newItem) || len(users) == 0 {
+			continue
+		}
+
+		votes[newItem] = append([]string(nil), users...)
+	}
+
+	p.Items = append([]string(nil), items...)
+	p.Votes = votes
+	p.ItemsRevision++
+	p.Version++
+}
+
+// VoterItems returns the items chosen by the voter in the order of the poll items
+func (p Poll) VoterItems(voter string) []string {
+	var items []string
+	for _, item := range p.Items {
+		for _, user := range p.Votes[item] {
+			if user == voter {
+				items = append(items, item)
+				break
+			}
+		}
+	}
+
+	return items
+}
+
+// VotersCount returns the number of distinct voters, a voter of a multiple choice poll is counted once
+func (p Poll) VotersCount() int {
+	voters := make(map[string]struct{})
+	for _, users := range p.Votes {
+		for _, user := range users {
+			voters[user] = struct{}{}
+		}
+	}
+
+	return len(voters)
+}
+
+func (p *Poll) removeVote(item, voter string) bool {
+	users := p.Votes[item]
+	for i, user := range users {
+		if user == voter {
+			users = append(users[:i], users[i+1:]...)
+			if len(users) > 0 {
+				p.Votes[item] = users
+			} else {
+				delete(p.Votes, item)
+			}
+
+			return true
+		}
+	}
+
+	return false
+}
+
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+
+	return false
+}
+
+// NewPollID generates a short random URL-safe poll ID
+func NewPollID() (string, error) {
+	id, err := randomString(pollIDLength)
+	if err != nil {
+		return "", errors.Wrap(err, "generate poll id failed")
+	}
+
+	return id, nil
+}
+
+// NewSalt generates a random salt for the voter hashes of an anonymous poll
+func NewSalt() (string, error) {
+	salt, err := randomString(saltLength)
+	if err != nil {
+		return "", errors.Wrap(err, "generate salt failed")
+	}
+
+	return salt, nil
+}
+
+func randomString(n int) (string, error) {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// SearchKey normalizes a subject for the case-insensitive prefix search
+func SearchKey(subject string) string {
+	return strings.ToLower(strings.TrimSpace(subject))
 }
 
 func (p Poll) String() string {
-	return fmt.Sprintf("{ Subject: '%s', CreatedAt: %d, Items: %q, CreatedBy: '%s', Votes: %+v",
-		p.Subject, p.CreatedAt, p.Items, p.CreatedBy, p.Votes)
+	return fmt.Sprintf("{ ID: '%s', Subject: '%s', CreatedAt: %d, Items: %q, CreatedBy: '%s', Anonymous: %t, Votes: %+v",
+		p.ID, p.Subject, p.CreatedAt, p.Items, p.CreatedBy, p.Anonymous, p.Votes)
 }
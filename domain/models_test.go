@@ -0,0 +1,193 @@
+package domain
+
+import (
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPoll_Vote(t *testing.T) {
+	type args struct {
+		item, voter string
+	}
+	tests := []struct {
+		name        string
+		votes       map[string][]string
+		multiple    bool
+		args        args
+		want        map[string][]string
+		wantVersion int64
+	}{
+		{
+			name:        "first vote",
+			args:        args{item: "1", voter: "alice"},
+			want:        map[string][]string{"1": {"alice"}},
+			wantVersion: 1,
+		},
+		{
+			name:        "revote moves the voter",
+			votes:       map[string][]string{"1": {"alice", "bob"}},
+			args:        args{item: "2", voter: "alice"},
+			want:        map[string][]string{"1": {"bob"}, "2": {"alice"}},
+			wantVersion: 1,
+		},
+		{
+			name:        "empty item is removed",
+			votes:       map[string][]string{"1": {"alice"}, "2": {"bob"}},
+			args:        args{item: "2", voter: "alice"},
+			want:        map[string][]string{"2": {"bob", "alice"}},
+			wantVersion: 1,
+		},
+		{
+			name:        "multiple choice keeps other votes",
+			votes:       map[string][]string{"1": {"alice"}},
+			multiple:    true,
+			args:        args{item: "2", voter: "alice"},
+			want:        map[string][]string{"1": {"alice"}, "2": {"alice"}},
+			wantVersion: 1,
+		},
+		{
+			name:        "multiple choice toggles the vote off",
+			votes:       map[string][]string{"1": {"alice", "bob"}, "2": {"alice"}},
+			multiple:    true,
+			args:        args{item: "2", voter: "alice"},
+			want:        map[string][]string{"1": {"alice", "bob"}},
+			wantVersion: 1,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := &Poll{Votes: tt.votes, MultipleChoice: tt.multiple}
+			p.Vote(tt.args.item, tt.args.voter)
+			assert.Equal(t, tt.want, p.Votes)
+			assert.Equal(t, tt.wantVersion, p.Version)
+		})
+	}
+}
+
+func TestPoll_AddInlineMessage(t *testing.T) {
+	p := &Poll{InlineMessageIDs: []string{"1"}}
+	assert.False(t, p.AddInlineMessage("1"))
+	assert.Zero(t, p.Version)
+
+	assert.True(t, p.AddInlineMessage("2"))
+	assert.Equal(t, []string{"1", "2"}, p.InlineMessageIDs)
+	assert.Equal(t, int64(1), p.Version)
+
+	for i := 3; i <= MaxInlineMessages+2; i++ {
+		p.AddInlineMessage(strconv.Itoa(i))
+	}
+	assert.Len(t, p.InlineMessageIDs, MaxInlineMessages)
+	assert.Equal(t, "3", p.InlineMessageIDs[0])
+	assert.Equal(t, strconv.Itoa(MaxInlineMessages+2), p.InlineMessageIDs[MaxInlineMessages-1])
+}
+
+func TestPoll_VoterItems(t *testing.T) {
+	p := Poll{
+		Items: []string{"1", "2", "3"},
+		Votes: map[string][]string{"3": {"alice"}, "1": {"bob", "alice"}},
+	}
+
+	assert.Equal(t, []string{"1", "3"}, p.VoterItems("alice"))
+	assert.Equal(t, []string{"1"}, p.VoterItems("bob"))
+	assert.Empty(t, p.VoterItems("carol"))
+	assert.Equal(t, 2, p.VotersCount())
+}
+
+func TestPoll_IsClosed(t *testing.T) {
+	now := time.Unix(1000, 0)
+	tests := []struct {
+		name string
+		poll Poll
+		want bool
+	}{
+		{name: "no close time", poll: Poll{}, want: false},
+		{name: "close time in the future", poll: Poll{ClosesAt: now.Add(time.Minute).UnixNano()}, want: false},
+		{name: "close time has passed", poll: Poll{ClosesAt: now.Add(-time.Minute).UnixNano()}, want: true},
+		{name: "closed", poll: Poll{ClosedAt: now.Add(-time.Hour).UnixNano()}, want: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, tt.poll.IsClosed(now))
+		})
+	}
+}
+
+func TestPoll_Reopen(t *testing.T) {
+	now := time.Unix(1000, 0)
+	tests := []struct {
+		name         string
+		poll         Poll
+		wantClosesAt int64
+	}{
+		{name: "closed manually", poll: Poll{ClosedAt: 1}},
+		{name: "close time has passed", poll: Poll{ClosedAt: 1, ClosesAt: now.Add(-time.Minute).UnixNano()}},
+		{name: "close time is kept", poll: Poll{ClosedAt: 1, ClosesAt: now.Add(time.Minute).UnixNano()}, wantClosesAt: now.Add(time.Minute).UnixNano()},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.poll.Reopen(now)
+			assert.False(t, tt.poll.IsClosed(now))
+			assert.Equal(t, tt.wantClosesAt, tt.poll.ClosesAt)
+			assert.Equal(t, int64(1), tt.poll.Version)
+		})
+	}
+}
+
+func TestPoll_SetItems(t *testing.T) {
+	tests := []struct {
+		name      string
+		votes     map[string][]string
+		items     []string
+		kept      map[string]string
+		wantVotes map[string][]string
+	}{
+		{
+			name:      "added item",
+			votes:     map[string][]string{"1": {"alice"}},
+			items:     []string{"1", "2"},
+			kept:      map[string]string{"1": "1"},
+			wantVotes: map[string][]string{"1": {"alice"}},
+		},
+		{
+			name:      "removed item drops votes",
+			votes:     map[string][]string{"1": {"alice"}, "2": {"bob"}},
+			items:     []string{"2"},
+			kept:      map[string]string{"2": "2"},
+			wantVotes: map[string][]string{"2": {"bob"}},
+		},
+		{
+			name:      "renamed item keeps votes",
+			votes:     map[string][]string{"1": {"alice"}, "2": {"bob"}},
+			items:     []string{"one", "2"},
+			kept:      map[string]string{"1": "one", "2": "2"},
+			wantVotes: map[string][]string{"one": {"alice"}, "2": {"bob"}},
+		},
+		{
+			name:      "item renamed to the name of a removed one",
+			votes:     map[string][]string{"1": {"alice"}, "2": {"bob"}},
+			items:     []string{"2"},
+			kept:      map[string]string{"1": "2"},
+			wantVotes: map[string][]string{"2": {"alice"}},
+		},
+		{
+			name:      "swapped items",
+			votes:     map[string][]string{"1": {"alice"}, "2": {"bob"}},
+			items:     []string{"2", "1"},
+			kept:      map[string]string{"1": "2", "2": "1"},
+			wantVotes: map[string][]string{"2": {"alice"}, "1": {"bob"}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := &Poll{Items: []string{"1", "2"}, Votes: tt.votes}
+			p.SetItems(tt.items, tt.kept)
+			assert.Equal(t, tt.items, p.Items)
+			assert.Equal(t, tt.wantVotes, p.Votes)
+			assert.Equal(t, int64(1), p.ItemsRevision)
+			assert.Equal(t, int64(1), p.Version)
+		})
+	}
+}
@@ -1,9 +1,9 @@
 package main
 
 import (
+	"context"
 	"io"
 	"log"
-	"os"
 	"os/signal"
 	"syscall"
 
@@ -22,6 +22,22 @@ const (
 	cfgFile   = "config.json"
 )
 
+const (
+	dynamoStorageDriver = "dynamo"
+	boltStorageDriver   = "bolt"
+	memoryStorageDriver = "memory"
+
+	defaultBoltPath = "vote-bot.db"
+)
+
+const (
+	storageDraftsStorage = "storage"
+	memoryDraftsStorage  = "memory"
+
+	// defaultMaxDrafts bounds the drafts kept in memory, the least recently used ones are dropped
+	defaultMaxDrafts = 10000
+)
+
 func config() (*cfg.Config, error) {
 	return cfg.New(cfgPrefix, cfgFile, cfg.JSONConfigType)
 }
@@ -33,18 +49,6 @@ func main() {
 		return
 	}
 
-	region := cfg.GetString("region")
-	if region == "" {
-		log.Printf("region is not set")
-		return
-	}
-
-	tableName := cfg.GetString("dynamo.table")
-	if tableName == "" {
-		log.Printf("dynamo table is not set")
-		return
-	}
-
 	telegramToken := cfg.GetString("telegram.token")
 	if telegramToken == "" {
 		log.Printf("telegram token is not set")
@@ -70,52 +74,165 @@ func main() {
 		}
 	}
 
-	repo, err := repository.New(region, tableName)
+	repo, err := newStorage(cfg)
 	if err != nil {
 		log.Printf("failed to initiate repository: %s", err)
 		return
 	}
 
-	if _, err := repo.DescribeTable(); err != nil {
-		if awsErr, ok := errors.Cause(err).(awserr.Error); ok {
-			switch awsErr.Code() {
-			case dynamodb.ErrCodeResourceNotFoundException:
-				if err1 := repo.CreateTable(); err1 != nil {
-					log.Printf("create table error: %s", err1)
-				}
-				log.Println("table created")
-			}
-		} else {
-			log.Println(err)
-			return
-		}
+	if closer, ok := repo.(io.Closer); ok {
+		defer closer.Close()
+	}
+
+	draftsInMemory, err := draftsStorage(cfg)
+	if err != nil {
+		log.Printf("failed to configure drafts: %s", err)
+		return
+	}
+
+	maxDrafts := cfg.GetInt("telegram.drafts.max_entries")
+	if maxDrafts == 0 {
+		maxDrafts = defaultMaxDrafts
 	}
 
-	bot, err := telegram.New(cache.NewStore(), repo, telegramToken, botName, userIDs...)
+	shared := cache.NewStoreWithOptions(cache.Options[string, any]{MaxEntries: maxDrafts})
+	defer shared.Close()
+
+	bot, err := telegram.New(shared, repo, telegram.Config{
+		Token:           telegramToken,
+		BotName:         botName,
+		UserIDs:         userIDs,
+		CallbackSecret:  cfg.GetString("telegram.callback_secret"),
+		MaximumAnswers:  cfg.GetInt("telegram.max_answers"),
+		AnonymousSecret: cfg.GetString("telegram.anonymous_secret"),
+		DraftsInMemory:  draftsInMemory,
+		DraftsTTL:       cfg.GetDuration("telegram.drafts.ttl"),
+		Mode:            cfg.GetString("telegram.mode"),
+		PollingTimeout:  cfg.GetDuration("telegram.polling_timeout"),
+		Workers:         cfg.GetInt("telegram.workers"),
+		EditDelay:       cfg.GetDuration("telegram.edit_delay"),
+		ShutdownTimeout: cfg.GetDuration("telegram.shutdown_timeout"),
+		APIEndpoint:     cfg.GetString("telegram.api_endpoint"),
+		Webhook: telegram.WebhookConfig{
+			URL:            cfg.GetString("telegram.webhook.url"),
+			Listen:         cfg.GetString("telegram.webhook.listen"),
+			CertFile:       cfg.GetString("telegram.webhook.cert_file"),
+			KeyFile:        cfg.GetString("telegram.webhook.key_file"),
+			SelfSigned:     cfg.GetBool("telegram.webhook.self_signed"),
+			SecretToken:    cfg.GetString("telegram.webhook.secret_token"),
+			MaxConnections: cfg.GetInt("telegram.webhook.max_connections"),
+			KeepOnStop:     cfg.GetBool("telegram.webhook.keep_on_stop"),
+		},
+	})
 	if err != nil {
 		log.Printf("bot creation error: %s\n", err)
 		return
 	}
 
-	go shutdown(bot)
+	ctx, stop := shutdown()
+	defer stop()
 
-	if err := bot.Run(); err != nil {
-		log.Printf("bot start failed: %s\n", err)
+	err = bot.Run(ctx)
+	if errors.Cause(err) == telegram.ErrShutdownTimeout {
+		// the updates being handled could still use the storage, so the deferred closers must not run
+		log.Fatalf("bot run failed: %s\n", err)
+	}
+	if err != nil {
+		log.Printf("bot run failed: %s\n", err)
 	}
 }
 
-func shutdown(c io.Closer) {
-	signalCh := make(chan os.Signal, 1)
+func newStorage(conf *cfg.Config) (repository.Storage, error) {
+	switch driver := conf.GetString("storage.driver"); driver {
+	case "", dynamoStorageDriver:
+		return newDynamoStorage(conf)
+	case boltStorageDriver:
+		path := conf.GetString("storage.path")
+		if path == "" {
+			path = defaultBoltPath
+		}
 
-	signal.Notify(signalCh,
+		return repository.NewBolt(path)
+	case memoryStorageDriver:
+		log.Println("polls are kept in memory and will be lost on exit")
+		return repository.NewMemory(), nil
+	default:
+		return nil, errors.Errorf("unknown storage driver '%s'", driver)
+	}
+}
+
+// draftsStorage tells whether the unfinished polls are kept in memory instead of the storage
+func draftsStorage(conf *cfg.Config) (bool, error) {
+	switch storage := conf.GetString("telegram.drafts.storage"); storage {
+	case "", storageDraftsStorage:
+		return false, nil
+	case memoryDraftsStorage:
+		return true, nil
+	default:
+		return false, errors.Errorf("unknown drafts storage '%s'", storage)
+	}
+}
+
+func newDynamoStorage(conf *cfg.Config) (*repository.Repository, error) {
+	region := conf.GetString("region")
+	if region == "" {
+		return nil, errors.New("region is not set")
+	}
+
+	tableName := conf.GetString("dynamo.table")
+	if tableName == "" {
+		return nil, errors.New("dynamo table is not set")
+	}
+
+	repo, err := repository.New(repository.DynamoConfig{
+		Region:          region,
+		TableName:       tableName,
+		Endpoint:        conf.GetString("dynamo.endpoint"),
+		DisableSSL:      conf.GetBool("dynamo.disable_ssl"),
+		ReadCapacity:    conf.GetInt64("dynamo.read_capacity"),
+		WriteCapacity:   conf.GetInt64("dynamo.write_capacity"),
+		DraftsTableName: conf.GetString("dynamo.drafts_table"),
+		Credentials: repository.DynamoCredentials{
+			Provider:        conf.GetString("dynamo.credentials.provider"),
+			AccessKeyID:     conf.GetString("dynamo.credentials.access_key_id"),
+			SecretAccessKey: conf.GetString("dynamo.credentials.secret_access_key"),
+			SessionToken:    conf.GetString("dynamo.credentials.session_token"),
+			Profile:         conf.GetString("dynamo.credentials.profile"),
+			Filename:        conf.GetString("dynamo.credentials.file"),
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := repo.DescribeTable(); err != nil {
+		awsErr, ok := errors.Cause(err).(awserr.Error)
+		if !ok || awsErr.Code() != dynamodb.ErrCodeResourceNotFoundException {
+			return nil, err
+		}
+
+		if err := repo.CreateTable(); err != nil {
+			return nil, err
+		}
+		log.Println("table created")
+
+		return repo, nil
+	}
+
+	if err := repo.Migrate(); err != nil {
+		return nil, errors.Wrap(err, "table migration failed")
+	}
+
+	return repo, nil
+}
+
+// shutdown returns the context which is canceled by a termination signal,
+// the bot finishes the received updates and the pending edits within the shutdown timeout
+func shutdown() (context.Context, context.CancelFunc) {
+	return signal.NotifyContext(context.Background(),
 		syscall.SIGTERM,
 		syscall.SIGINT,
 		syscall.SIGQUIT,
 		syscall.SIGHUP,
 	)
-
-	<-signalCh
-	log.Println("Shutting down...")
-
-	c.Close()
 }
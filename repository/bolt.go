@@ -0,0 +1,320 @@
+package repository
+
+import (
+	"encoding/json"
+	"strings"
+	"time"
+
+	"github.com/incu6us/vote-bot/domain"
+	"github.com/incu6us/vote-bot/repository/internal/bolt"
+	"github.com/pkg/errors"
+)
+
+// BoltRepository stores polls in an embedded BoltDB file
+type BoltRepository struct {
+	db *bolt.DB
+}
+
+func NewBolt(path string) (*BoltRepository, error) {
+	db, err := bolt.New(path)
+	if err != nil {
+		return nil, errors.Wrap(err, "create repository failed")
+	}
+
+	r := &BoltRepository{db: db}
+	if err := r.migrate(); err != nil {
+		db.Close()
+		return nil, errors.Wrap(err, "migrate repository failed")
+	}
+
+	return r, nil
+}
+
+// migrate assigns IDs to the polls created before polls got them
+func (r *BoltRepository) migrate() error {
+	polls, err := r.GetPolls()
+	if err != nil {
+		return err
+	}
+
+	for _, poll := range polls {
+		if poll.ID != "" {
+			continue
+		}
+
+		id, err := domain.NewPollID()
+		if err != nil {
+			return err
+		}
+
+		err = r.db.SetID(poll.Subject, id, func(data []byte) ([]byte, error) {
+			poll, err := unmarshalPoll(data)
+			if err != nil {
+				return nil, err
+			}
+
+			poll.ID = id
+			return json.Marshal(poll)
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (r *BoltRepository) Close() error {
+	return r.db.Close()
+}
+
+func (r *BoltRepository) GetPolls() ([]*domain.Poll, error) {
+	result, err := r.db.GetPolls()
+	if err != nil {
+		return nil, errors.Wrap(err, "can't get polls from repository")
+	}
+
+	polls := make([]*domain.Poll, len(result))
+	for i, data := range result {
+		if polls[i], err = unmarshalPoll(data); err != nil {
+			return nil, err
+		}
+	}
+
+	return polls, nil
+}
+
+func (r *BoltRepository) GetPoll(pollName string) (*domain.Poll, error) {
+	data, err := r.db.GetPoll(strings.TrimSpace(pollName))
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to get a poll by name")
+	}
+
+	return unmarshalPoll(data)
+}
+
+func (r *BoltRepository) GetPollBeginsWith(pollName string) (*domain.Poll, error) {
+	data, err := r.db.GetPollBeginsWith(strings.TrimSpace(pollName))
+	if err == bolt.ErrBadPollName {
+		return nil, ErrBadPollName
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to get a poll by name")
+	}
+
+	return unmarshalPoll(data)
+}
+
+func (r *BoltRepository) GetPollByID(id string) (*domain.Poll, error) {
+	data, err := r.db.GetPollByID(id)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to get a poll by id")
+	}
+
+	return unmarshalPoll(data)
+}
+
+func (r *BoltRepository) GetPollByCreatedAt(createdAt int64) (*domain.Poll, error) {
+	data, err := r.db.GetPollByCreatedAt(createdAt)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to get a poll by created_at field")
+	}
+
+	return unmarshalPoll(data)
+}
+
+func (r *BoltRepository) CreatePoll(poll *domain.Poll) error {
+	if err := initPoll(poll); err != nil {
+		return errors.Wrap(err, "create poll failed")
+	}
+
+	data, err := json.Marshal(poll)
+	if err != nil {
+		return errors.Wrap(err, "filed to marshal an item")
+	}
+
+	if err := r.db.CreatePoll(poll.Subject, poll.ID, poll.CreatedAt, data); err != nil {
+		if err == bolt.ErrPollExists {
+			return ErrPollAlreadyExist
+		}
+
+		return errors.Wrap(err, "create poll failed")
+	}
+
+	return nil
+}
+
+func (r *BoltRepository) DeletePoll(pollName, owner string) error {
+	poll, err := r.getPollByOwner(strings.TrimSpace(pollName), owner)
+	if err != nil {
+		return err
+	}
+
+	return r.db.DeletePoll(poll.Subject, poll.ID, poll.CreatedAt)
+}
+
+func (r *BoltRepository) UpdatePollIsClosed(pollName, owner string, isClosed bool) (*domain.Poll, error) {
+	return r.updatePoll(strings.TrimSpace(pollName), func(poll *domain.Poll) error {
+		if poll.CreatedBy != owner {
+			return ErrPollIsNotFound
+		}
+
+		return setIsClosed(poll, isClosed, time.Now())
+	})
+}
+
+func (r *BoltRepository) UpdatePollItems(pollName, owner string, items []string, kept map[string]string) (*domain.Poll, error) {
+	return r.updatePoll(strings.TrimSpace(pollName), func(poll *domain.Poll) error {
+		if poll.CreatedBy != owner {
+			return ErrPollIsNotFound
+		}
+
+		poll.SetItems(items, kept)
+		return nil
+	})
+}
+
+func (r *BoltRepository) UpdateVote(pollID string, item, voter string) (*domain.Poll, error) {
+	poll, err := r.updatePollByID(pollID, func(poll *domain.Poll) error {
+		if poll.IsClosed(time.Now()) {
+			return ErrPollIsClosed
+		}
+
+		poll.Vote(item, voter)
+		return nil
+	})
+	if err != nil && err != ErrPollIsClosed {
+		return nil, errors.Wrap(err, "failed to update vote in database")
+	}
+
+	return poll, err
+}
+
+func (r *BoltRepository) GetPollsByOwner(owner string) ([]*domain.Poll, error) {
+	polls, err := r.GetPolls()
+	if err != nil {
+		return nil, err
+	}
+
+	return ownerPolls(polls, owner), nil
+}
+
+func (r *BoltRepository) GetExpiredPolls(now time.Time) ([]*domain.Poll, error) {
+	polls, err := r.GetPolls()
+	if err != nil {
+		return nil, err
+	}
+
+	return expiredPolls(polls, now), nil
+}
+
+func (r *BoltRepository) ClosePoll(pollID string) (*domain.Poll, error) {
+	return r.updatePollByID(pollID, func(poll *domain.Poll) error {
+		return setIsClosed(poll, true, time.Now())
+	})
+}
+
+func (r *BoltRepository) AddInlineMessage(pollID, inlineMessageID string) error {
+	_, err := r.updatePollByID(pollID, func(poll *domain.Poll) error {
+		poll.AddInlineMessage(inlineMessageID)
+
+		return nil
+	})
+
+	return err
+}
+
+func (r *BoltRepository) GetDraft(key string) ([]byte, error) {
+	data, err := r.db.GetDraft(key, time.Now())
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to get a draft")
+	}
+
+	if data == nil {
+		return nil, ErrDraftIsNotFound
+	}
+
+	return data, nil
+}
+
+func (r *BoltRepository) SaveDraft(key string, data []byte, expiresAt time.Time) error {
+	return r.db.SaveDraft(key, data, expiresAt)
+}
+
+func (r *BoltRepository) DeleteDraft(key string) error {
+	return r.db.DeleteDraft(key)
+}
+
+func (r *BoltRepository) DeleteExpiredDrafts(now time.Time) (map[string][]byte, error) {
+	return r.db.DeleteExpiredDrafts(now)
+}
+
+func (r *BoltRepository) updatePollByID(pollID string, fn func(poll *domain.Poll) error) (*domain.Poll, error) {
+	poll, err := r.GetPollByID(pollID)
+	if err != nil {
+		return nil, errors.Wrap(err, "get poll failed")
+	}
+
+	return r.updatePoll(poll.Subject, func(poll *domain.Poll) error {
+		if poll.ID != pollID {
+			return ErrPollIsNotFound
+		}
+
+		return fn(poll)
+	})
+}
+
+func (r *BoltRepository) getPollByOwner(pollName, owner string) (*domain.Poll, error) {
+	poll, err := r.GetPoll(pollName)
+	if err != nil {
+		return nil, err
+	}
+
+	if poll.CreatedBy != owner {
+		return nil, ErrPollIsNotFound
+	}
+
+	return poll, nil
+}
+
+// updatePoll applies fn to the stored poll and persists the result atomically
+func (r *BoltRepository) updatePoll(pollName string, fn func(poll *domain.Poll) error) (*domain.Poll, error) {
+	var poll *domain.Poll
+	err := r.db.UpdatePoll(pollName, func(data []byte) ([]byte, error) {
+		var err error
+		if poll, err = unmarshalPoll(data); err != nil {
+			return nil, err
+		}
+
+		if err := fn(poll); err != nil {
+			return nil, err
+		}
+
+		return json.Marshal(poll)
+	})
+	if err != nil {
+		switch cause := errors.Cause(err); cause {
+		case bolt.ErrPollIsNotFound, ErrPollIsNotFound:
+			return nil, ErrPollIsNotFound
+		case ErrPollIsClosed, ErrPollIsOpen:
+			return nil, cause
+		}
+
+		return nil, err
+	}
+
+	return poll, nil
+}
+
+func unmarshalPoll(data []byte) (*domain.Poll, error) {
+	if data == nil {
+		return nil, ErrPollIsNotFound
+	}
+
+	poll := new(domain.Poll)
+	if err := json.Unmarshal(data, poll); err != nil {
+		return nil, errors.Wrap(err, "failed to unmarshal item")
+	}
+
+	return poll, nil
+}
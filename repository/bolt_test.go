@@ -0,0 +1,146 @@
+package repository
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/incu6us/vote-bot/domain"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.etcd.io/bbolt"
+)
+
+func newTestBoltRepository(t *testing.T) (*BoltRepository, func()) {
+	dir, err := ioutil.TempDir("", "vote-bot")
+	require.NoError(t, err)
+
+	repo, err := NewBolt(filepath.Join(dir, "polls.db"))
+	require.NoError(t, err)
+
+	return repo, func() {
+		repo.Close()
+		os.RemoveAll(dir)
+	}
+}
+
+func TestBoltRepository(t *testing.T) {
+	testStorage(t, func(t *testing.T) (Storage, func()) {
+		return newTestBoltRepository(t)
+	})
+}
+
+func TestBoltRepository_CreatePoll(t *testing.T) {
+	repo, cleanup := newTestBoltRepository(t)
+	defer cleanup()
+
+	require.NoError(t, repo.CreatePoll(&domain.Poll{Subject: " test poll ", CreatedBy: "me", Items: []string{"1", "2"}}))
+	assert.Equal(t, ErrPollAlreadyExist, repo.CreatePoll(&domain.Poll{Subject: "test poll", CreatedBy: "me", Items: []string{"3"}}))
+
+	poll, err := repo.GetPoll("test poll")
+	require.NoError(t, err)
+	assert.Equal(t, "test poll", poll.Subject)
+	assert.Equal(t, []string{"1", "2"}, poll.Items)
+	assert.Equal(t, "me", poll.CreatedBy)
+
+	_, err = repo.GetPoll("unknown")
+	assert.Equal(t, ErrPollIsNotFound, err)
+}
+
+func TestBoltRepository_GetPollBeginsWith(t *testing.T) {
+	repo, cleanup := newTestBoltRepository(t)
+	defer cleanup()
+
+	require.NoError(t, repo.CreatePoll(&domain.Poll{Subject: "lunch today", CreatedBy: "me", Items: []string{"pizza"}}))
+	require.NoError(t, repo.CreatePoll(&domain.Poll{Subject: "dinner", CreatedBy: "me", Items: []string{"soup"}}))
+
+	tests := []struct {
+		name    string
+		prefix  string
+		want    string
+		wantErr error
+	}{
+		{name: "found", prefix: "lunch", want: "lunch today"},
+		{name: "not found", prefix: "breakfast", wantErr: ErrPollIsNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			poll, err := repo.GetPollBeginsWith(tt.prefix)
+			if tt.wantErr != nil {
+				assert.Equal(t, tt.wantErr, err)
+				return
+			}
+
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, poll.Subject)
+		})
+	}
+}
+
+func TestBoltRepository_UpdateVote(t *testing.T) {
+	repo, cleanup := newTestBoltRepository(t)
+	defer cleanup()
+
+	require.NoError(t, repo.CreatePoll(&domain.Poll{Subject: "test", CreatedBy: "me", Items: []string{"1", "2"}}))
+	poll, err := repo.GetPoll("test")
+	require.NoError(t, err)
+
+	_, err = repo.UpdateVote(poll.ID, "1", "alice")
+	require.NoError(t, err)
+	_, err = repo.UpdateVote(poll.ID, "1", "bob")
+	require.NoError(t, err)
+	poll, err = repo.UpdateVote(poll.ID, "2", "alice")
+	require.NoError(t, err)
+
+	assert.Equal(t, map[string][]string{"1": {"bob"}, "2": {"alice"}}, poll.Votes)
+
+	_, err = repo.UpdateVote("unknown", "1", "alice")
+	assert.Equal(t, ErrPollIsNotFound, errors.Cause(err))
+}
+
+func TestBoltRepository_DeletePoll(t *testing.T) {
+	repo, cleanup := newTestBoltRepository(t)
+	defer cleanup()
+
+	require.NoError(t, repo.CreatePoll(&domain.Poll{Subject: "test", CreatedBy: "me", Items: []string{"1"}}))
+
+	assert.Equal(t, ErrPollIsNotFound, repo.DeletePoll("test", "someone else"))
+	require.NoError(t, repo.DeletePoll("test", "me"))
+
+	polls, err := repo.GetPolls()
+	require.NoError(t, err)
+	assert.Empty(t, polls)
+}
+
+func TestNewBolt_migratesLegacyPolls(t *testing.T) {
+	dir, err := ioutil.TempDir("", "vote-bot")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "polls.db")
+	db, err := bbolt.Open(path, 0600, nil)
+	require.NoError(t, err)
+	require.NoError(t, db.Update(func(tx *bbolt.Tx) error {
+		polls, err := tx.CreateBucket([]byte("polls"))
+		if err != nil {
+			return err
+		}
+
+		return polls.Put([]byte("Legacy poll"), []byte(`{"subject":"Legacy poll","created_at":1,"items":["1"],"created_by":"me"}`))
+	}))
+	require.NoError(t, db.Close())
+
+	repo, err := NewBolt(path)
+	require.NoError(t, err)
+	defer repo.Close()
+
+	poll, err := repo.GetPollBeginsWith("legacy")
+	require.NoError(t, err)
+	require.NotEmpty(t, poll.ID)
+
+	byID, err := repo.GetPollByID(poll.ID)
+	require.NoError(t, err)
+	assert.Equal(t, "Legacy poll", byID.Subject)
+}
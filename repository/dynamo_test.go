@@ -0,0 +1,53 @@
+package repository
+
+import (
+	"fmt"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+const (
+	testDynamoEndpointEnv = "VB_TEST_DYNAMO_ENDPOINT"
+	testDynamoRegionEnv   = "VB_TEST_DYNAMO_REGION"
+)
+
+// TestRepository runs the storage suite against DynamoDB Local (or a compatible emulator), e.g.:
+//
+//	docker run -d -p 8000:8000 amazon/dynamodb-local
+//	VB_TEST_DYNAMO_ENDPOINT=http://localhost:8000 go test ./repository/...
+func TestRepository(t *testing.T) {
+	endpoint := os.Getenv(testDynamoEndpointEnv)
+	if endpoint == "" {
+		t.Skipf("%s is not set", testDynamoEndpointEnv)
+	}
+
+	region := os.Getenv(testDynamoRegionEnv)
+	if region == "" {
+		region = "eu-central-1"
+	}
+
+	testStorage(t, func(t *testing.T) (Storage, func()) {
+		repo, err := New(DynamoConfig{
+			Region:     region,
+			TableName:  fmt.Sprintf("polls-test-%d", time.Now().UnixNano()),
+			Endpoint:   endpoint,
+			DisableSSL: true,
+			Credentials: DynamoCredentials{
+				Provider:        "static",
+				AccessKeyID:     "local",
+				SecretAccessKey: "local",
+			},
+		})
+		require.NoError(t, err)
+		require.NoError(t, repo.CreateTable())
+
+		return repo, func() {
+			if err := repo.DeleteTable(); err != nil {
+				t.Logf("delete table: %s", err)
+			}
+		}
+	})
+}
@@ -0,0 +1,298 @@
+package bolt
+
+import (
+	"bytes"
+	"encoding/binary"
+	"time"
+
+	"github.com/incu6us/vote-bot/domain"
+	"github.com/pkg/errors"
+	bolt "go.etcd.io/bbolt"
+)
+
+var (
+	ErrBadPollName    = errors.New("bad poll name")
+	ErrBadPollID      = errors.New("bad poll id")
+	ErrPollExists     = errors.New("poll exists")
+	ErrPollIsNotFound = errors.New("poll is not found")
+)
+
+const (
+	openTimeout = 5 * time.Second
+)
+
+var (
+	pollsBucket     = []byte("polls")
+	createdAtBucket = []byte("polls_created_at")
+	idBucket        = []byte("polls_id")
+	searchBucket    = []byte("polls_search")
+	draftsBucket    = []byte("drafts")
+)
+
+// DB keeps polls as JSON documents keyed by a subject with secondary id, created_at and search indexes.
+// The unfinished polls are kept in a separate bucket
+type DB struct {
+	db *bolt.DB
+}
+
+func New(path string) (*DB, error) {
+	db, err := bolt.Open(path, 0600, &bolt.Options{Timeout: openTimeout})
+	if err != nil {
+		return nil, errors.Wrapf(err, "open database '%s' failed", path)
+	}
+
+	err = db.Update(func(tx *bolt.Tx) error {
+		for _, bucket := range [][]byte{pollsBucket, createdAtBucket, idBucket, draftsBucket} {
+			if _, err := tx.CreateBucketIfNotExists(bucket); err != nil {
+				return errors.Wrapf(err, "create bucket '%s' failed", bucket)
+			}
+		}
+
+		if tx.Bucket(searchBucket) != nil {
+			return nil
+		}
+
+		// the search index appeared later than the polls, build it for the existing database
+		search, err := tx.CreateBucket(searchBucket)
+		if err != nil {
+			return errors.Wrapf(err, "create bucket '%s' failed", searchBucket)
+		}
+
+		return tx.Bucket(pollsBucket).ForEach(func(subject, _ []byte) error {
+			return search.Put(searchKey(string(subject)), subject)
+		})
+	})
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	return &DB{db: db}, nil
+}
+
+func (db *DB) Close() error {
+	return db.db.Close()
+}
+
+func (db *DB) GetPolls() ([][]byte, error) {
+	var result [][]byte
+	err := db.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(pollsBucket).ForEach(func(_, v []byte) error {
+			result = append(result, copyBytes(v))
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "get polls error")
+	}
+
+	return result, nil
+}
+
+func (db *DB) GetPoll(subject string) ([]byte, error) {
+	if subject == "" {
+		return nil, ErrBadPollName
+	}
+
+	var result []byte
+	err := db.db.View(func(tx *bolt.Tx) error {
+		result = copyBytes(tx.Bucket(pollsBucket).Get([]byte(subject)))
+		return nil
+	})
+	if err != nil {
+		return nil, errors.Wrapf(err, "get poll with subject '%s' error", subject)
+	}
+
+	return result, nil
+}
+
+func (db *DB) GetPollBeginsWith(subject string) ([]byte, error) {
+	if subject == "" {
+		return nil, ErrBadPollName
+	}
+
+	var result []byte
+	err := db.db.View(func(tx *bolt.Tx) error {
+		prefix := []byte(domain.SearchKey(subject))
+		if k, v := tx.Bucket(searchBucket).Cursor().Seek(prefix); k != nil && bytes.HasPrefix(k, prefix) {
+			result = copyBytes(tx.Bucket(pollsBucket).Get(v))
+		}
+
+		return nil
+	})
+	if err != nil {
+		return nil, errors.Wrapf(err, "get poll with subject '%s' error", subject)
+	}
+
+	return result, nil
+}
+
+func (db *DB) GetPollByID(id string) ([]byte, error) {
+	if id == "" {
+		return nil, ErrBadPollID
+	}
+
+	var result []byte
+	err := db.db.View(func(tx *bolt.Tx) error {
+		subject := tx.Bucket(idBucket).Get([]byte(id))
+		if subject == nil {
+			return nil
+		}
+
+		result = copyBytes(tx.Bucket(pollsBucket).Get(subject))
+		return nil
+	})
+	if err != nil {
+		return nil, errors.Wrapf(err, "get poll with id '%s' error", id)
+	}
+
+	return result, nil
+}
+
+func (db *DB) GetPollByCreatedAt(createdAt int64) ([]byte, error) {
+	if createdAt == 0 {
+		return nil, ErrBadPollName
+	}
+
+	var result []byte
+	err := db.db.View(func(tx *bolt.Tx) error {
+		subject := tx.Bucket(createdAtBucket).Get(createdAtKey(createdAt))
+		if subject == nil {
+			return nil
+		}
+
+		result = copyBytes(tx.Bucket(pollsBucket).Get(subject))
+		return nil
+	})
+	if err != nil {
+		return nil, errors.Wrapf(err, "get poll with created_at field '%d' error", createdAt)
+	}
+
+	return result, nil
+}
+
+func (db *DB) CreatePoll(subject, id string, createdAt int64, data []byte) error {
+	if subject == "" {
+		return ErrBadPollName
+	}
+
+	err := db.db.Update(func(tx *bolt.Tx) error {
+		polls := tx.Bucket(pollsBucket)
+		if polls.Get([]byte(subject)) != nil || tx.Bucket(idBucket).Get([]byte(id)) != nil {
+			return ErrPollExists
+		}
+
+		if err := tx.Bucket(idBucket).Put([]byte(id), []byte(subject)); err != nil {
+			return err
+		}
+
+		if err := polls.Put([]byte(subject), data); err != nil {
+			return err
+		}
+
+		if err := tx.Bucket(searchBucket).Put(searchKey(subject), []byte(subject)); err != nil {
+			return err
+		}
+
+		return tx.Bucket(createdAtBucket).Put(createdAtKey(createdAt), []byte(subject))
+	})
+	if err == ErrPollExists {
+		return err
+	}
+
+	return errors.Wrap(err, "failed to create items")
+}
+
+func (db *DB) DeletePoll(subject, id string, createdAt int64) error {
+	err := db.db.Update(func(tx *bolt.Tx) error {
+		if err := tx.Bucket(pollsBucket).Delete([]byte(subject)); err != nil {
+			return err
+		}
+
+		if id != "" {
+			if err := tx.Bucket(idBucket).Delete([]byte(id)); err != nil {
+				return err
+			}
+		}
+
+		if err := tx.Bucket(searchBucket).Delete(searchKey(subject)); err != nil {
+			return err
+		}
+
+		return tx.Bucket(createdAtBucket).Delete(createdAtKey(createdAt))
+	})
+
+	return errors.Wrapf(err, "failed to delete subject: %s", subject)
+}
+
+// UpdatePoll replaces the stored document with the result of fn within a single transaction
+func (db *DB) UpdatePoll(subject string, fn func(data []byte) ([]byte, error)) error {
+	err := db.db.Update(func(tx *bolt.Tx) error {
+		return updatePoll(tx, subject, fn)
+	})
+	if err == ErrPollIsNotFound {
+		return err
+	}
+
+	return errors.Wrapf(err, "failed to update subject: %s", subject)
+}
+
+// SetID assigns the ID to a poll created without it, fn writes the ID into the poll.
+// The poll and the index are written in one transaction, so the poll with the ID is always reachable by it
+func (db *DB) SetID(subject, id string, fn func(data []byte) ([]byte, error)) error {
+	if id == "" {
+		return ErrBadPollID
+	}
+
+	err := db.db.Update(func(tx *bolt.Tx) error {
+		if err := updatePoll(tx, subject, fn); err != nil {
+			return err
+		}
+
+		return tx.Bucket(idBucket).Put([]byte(id), []byte(subject))
+	})
+	if err == ErrPollIsNotFound {
+		return err
+	}
+
+	return errors.Wrapf(err, "failed to set id of subject: %s", subject)
+}
+
+func updatePoll(tx *bolt.Tx, subject string, fn func(data []byte) ([]byte, error)) error {
+	polls := tx.Bucket(pollsBucket)
+	data := polls.Get([]byte(subject))
+	if data == nil {
+		return ErrPollIsNotFound
+	}
+
+	updated, err := fn(copyBytes(data))
+	if err != nil {
+		return err
+	}
+
+	return polls.Put([]byte(subject), updated)
+}
+
+func createdAtKey(createdAt int64) []byte {
+	key := make([]byte, 8)
+	binary.BigEndian.PutUint64(key, uint64(createdAt))
+
+	return key
+}
+
+// searchKey orders polls by the normalized subject, the subject itself keeps the keys unique
+func searchKey(subject string) []byte {
+	return []byte(domain.SearchKey(subject) + "\x00" + subject)
+}
+
+// copyBytes detaches a value from the transaction, bolt's memory is valid only until it ends
+func copyBytes(b []byte) []byte {
+	if b == nil {
+		return nil
+	}
+
+	c := make([]byte, len(b))
+	copy(c, b)
+
+	return c
+}
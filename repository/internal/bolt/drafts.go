@@ -0,0 +1,97 @@
+package bolt
+
+import (
+	"encoding/binary"
+	"time"
+
+	"github.com/pkg/errors"
+	bolt "go.etcd.io/bbolt"
+)
+
+// expiresAtLength is the size of the expiration time stored in front of the draft
+const expiresAtLength = 8
+
+// GetDraft returns nil for a missing or expired draft
+func (db *DB) GetDraft(key string, now time.Time) ([]byte, error) {
+	var result []byte
+	err := db.db.View(func(tx *bolt.Tx) error {
+		value := tx.Bucket(draftsBucket).Get([]byte(key))
+		if value == nil || draftExpired(value, now) {
+			return nil
+		}
+
+		result = copyBytes(value[expiresAtLength:])
+		return nil
+	})
+	if err != nil {
+		return nil, errors.Wrapf(err, "get draft '%s' error", key)
+	}
+
+	return result, nil
+}
+
+func (db *DB) SaveDraft(key string, data []byte, expiresAt time.Time) error {
+	value := make([]byte, expiresAtLength+len(data))
+	binary.BigEndian.PutUint64(value, uint64(expiresAt.UnixNano()))
+	copy(value[expiresAtLength:], data)
+
+	err := db.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(draftsBucket).Put([]byte(key), value)
+	})
+
+	return errors.Wrapf(err, "failed to save draft '%s'", key)
+}
+
+func (db *DB) DeleteDraft(key string) error {
+	err := db.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(draftsBucket).Delete([]byte(key))
+	})
+
+	return errors.Wrapf(err, "failed to delete draft '%s'", key)
+}
+
+// DeleteExpiredDrafts removes the drafts expired before now and returns their data by the keys
+func (db *DB) DeleteExpiredDrafts(now time.Time) (map[string][]byte, error) {
+	result := make(map[string][]byte)
+	err := db.db.Update(func(tx *bolt.Tx) error {
+		drafts := tx.Bucket(draftsBucket)
+		err := drafts.ForEach(func(k, v []byte) error {
+			if !draftExpired(v, now) {
+				return nil
+			}
+
+			var data []byte
+			if len(v) > expiresAtLength {
+				data = copyBytes(v[expiresAtLength:])
+			}
+			result[string(k)] = data
+
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+
+		// a bucket can't be changed while it's iterated
+		for key := range result {
+			if err := drafts.Delete([]byte(key)); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to delete expired drafts")
+	}
+
+	return result, nil
+}
+
+func draftExpired(value []byte, now time.Time) bool {
+	if len(value) < expiresAtLength {
+		return true
+	}
+
+	return int64(binary.BigEndian.Uint64(value)) <= now.UnixNano()
+}
@@ -0,0 +1,236 @@
+package dynamo
+
+import (
+	"strconv"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/pkg/errors"
+)
+
+const (
+	draftsTableSuffix = "-drafts"
+	// draftExpiresAtAttribute is the TTL attribute of the drafts table, DynamoDB expects it in seconds
+	draftExpiresAtAttribute = "expires_at"
+	// draftsExpiryIndex keeps the drafts in one partition ordered by the expiration time,
+	// so the expired ones are found without scanning the table
+	draftsExpiryIndex = "expires_at-index"
+
+	draftKind = "draft"
+)
+
+// CreateDraftsTable creates the table of the unfinished polls and lets DynamoDB remove the expired ones
+func (db DB) CreateDraftsTable() error {
+	_, err := db.client.CreateTable(&dynamodb.CreateTableInput{
+		AttributeDefinitions: append([]*dynamodb.AttributeDefinition{
+			{
+				AttributeName: aws.String("key"),
+				AttributeType: aws.String("S"),
+			},
+		}, draftsExpiryAttributes()...),
+		KeySchema: []*dynamodb.KeySchemaElement{
+			{
+				AttributeName: aws.String("key"),
+				KeyType:       aws.String("HASH"),
+			},
+		},
+		GlobalSecondaryIndexes: []*dynamodb.GlobalSecondaryIndex{
+			db.draftsExpiryIndex(),
+		},
+		ProvisionedThroughput: db.throughput(),
+		TableName:             aws.String(db.draftsTableName),
+	})
+	if err != nil {
+		return errors.Wrap(err, "create drafts table failed")
+	}
+
+	err = db.client.WaitUntilTableExists(&dynamodb.DescribeTableInput{TableName: aws.String(db.draftsTableName)})
+	if err != nil {
+		return errors.Wrap(err, "wait for drafts table failed")
+	}
+
+	_, err = db.client.UpdateTimeToLive(&dynamodb.UpdateTimeToLiveInput{
+		TableName: aws.String(db.draftsTableName),
+		TimeToLiveSpecification: &dynamodb.TimeToLiveSpecification{
+			AttributeName: aws.String(draftExpiresAtAttribute),
+			Enabled:       aws.Bool(true),
+		},
+	})
+
+	return errors.Wrap(err, "enable drafts expiration failed")
+}
+
+// migrateDraftsTable adds the expiry index to the drafts table created by the previous versions.
+// The drafts saved before have no index attributes, DynamoDB removes them without the notification
+func (db DB) migrateDraftsTable() error {
+	existing, err := db.indexNames(db.draftsTableName)
+	if err != nil {
+		return err
+	}
+
+	if existing[draftsExpiryIndex] {
+		return nil
+	}
+
+	return db.createIndex(db.draftsTableName, db.draftsExpiryIndex(), draftsExpiryAttributes())
+}
+
+func (db DB) draftsExpiryIndex() *dynamodb.GlobalSecondaryIndex {
+	return &dynamodb.GlobalSecondaryIndex{
+		IndexName: aws.String(draftsExpiryIndex),
+		KeySchema: []*dynamodb.KeySchemaElement{
+			{
+				AttributeName: aws.String("kind"),
+				KeyType:       aws.String("HASH"),
+			},
+			{
+				AttributeName: aws.String(draftExpiresAtAttribute),
+				KeyType:       aws.String("RANGE"),
+			},
+		},
+		Projection: &dynamodb.Projection{
+			ProjectionType: aws.String(dynamodb.ProjectionTypeKeysOnly),
+		},
+		ProvisionedThroughput: db.throughput(),
+	}
+}
+
+func draftsExpiryAttributes() []*dynamodb.AttributeDefinition {
+	return []*dynamodb.AttributeDefinition{
+		{AttributeName: aws.String("kind"), AttributeType: aws.String("S")},
+		{AttributeName: aws.String(draftExpiresAtAttribute), AttributeType: aws.String("N")},
+	}
+}
+
+func (db DB) DeleteDraftsTable() error {
+	_, err := db.client.DeleteTable(&dynamodb.DeleteTableInput{TableName: aws.String(db.draftsTableName)})
+
+	return errors.Wrap(err, "delete drafts table failed")
+}
+
+// hasDraftsTable reports whether the drafts table exists, it's missing in the setups made by the previous versions
+func (db DB) hasDraftsTable() (bool, error) {
+	_, err := db.client.DescribeTable(&dynamodb.DescribeTableInput{TableName: aws.String(db.draftsTableName)})
+	if awsErr, ok := err.(awserr.Error); ok && awsErr.Code() == dynamodb.ErrCodeResourceNotFoundException {
+		return false, nil
+	}
+	if err != nil {
+		return false, errors.Wrap(err, "failed to get drafts table description")
+	}
+
+	return true, nil
+}
+
+// GetDraft returns nil for a missing draft. DynamoDB removes the expired items with a delay,
+// so the items expired before now are skipped
+func (db DB) GetDraft(key string, now time.Time) ([]byte, error) {
+	result, err := db.client.GetItem(&dynamodb.GetItemInput{
+		TableName:      aws.String(db.draftsTableName),
+		ConsistentRead: aws.Bool(true),
+		Key: map[string]*dynamodb.AttributeValue{
+			"key": {S: aws.String(key)},
+		},
+	})
+	if err != nil {
+		return nil, errors.Wrapf(err, "get draft '%s' error", key)
+	}
+
+	expiresAt, ok := result.Item[draftExpiresAtAttribute]
+	if !ok {
+		return nil, nil
+	}
+
+	seconds, err := strconv.ParseInt(aws.StringValue(expiresAt.N), 10, 64)
+	if err != nil {
+		return nil, errors.Wrapf(err, "bad expiration time of draft '%s'", key)
+	}
+
+	if seconds <= now.Unix() {
+		return nil, nil
+	}
+
+	return result.Item["data"].B, nil
+}
+
+func (db DB) SaveDraft(key string, data []byte, expiresAt time.Time) error {
+	_, err := db.client.PutItem(&dynamodb.PutItemInput{
+		TableName: aws.String(db.draftsTableName),
+		Item: map[string]*dynamodb.AttributeValue{
+			"key":                   {S: aws.String(key)},
+			"kind":                  {S: aws.String(draftKind)},
+			"data":                  {B: data},
+			draftExpiresAtAttribute: {N: aws.String(strconv.FormatInt(expiresAt.Unix(), 10))},
+		},
+	})
+
+	return errors.Wrapf(err, "failed to save draft '%s'", key)
+}
+
+func (db DB) DeleteDraft(key string) error {
+	_, err := db.client.DeleteItem(&dynamodb.DeleteItemInput{
+		TableName: aws.String(db.draftsTableName),
+		Key: map[string]*dynamodb.AttributeValue{
+			"key": {S: aws.String(key)},
+		},
+	})
+
+	return errors.Wrapf(err, "failed to delete draft '%s'", key)
+}
+
+// DeleteExpiredDrafts removes the drafts expired before now without waiting for DynamoDB to do it and returns their data by the keys.
+// Only the expired drafts are read from the expiry index, a draft saved again after the query is kept
+func (db DB) DeleteExpiredDrafts(now time.Time) (map[string][]byte, error) {
+	var keys []string
+	err := db.client.QueryPages(&dynamodb.QueryInput{
+		TableName:              aws.String(db.draftsTableName),
+		IndexName:              aws.String(draftsExpiryIndex),
+		KeyConditionExpression: aws.String("#kind = :k AND #expiresAt <= :now"),
+		ExpressionAttributeNames: map[string]*string{
+			"#kind":      aws.String("kind"),
+			"#expiresAt": aws.String(draftExpiresAtAttribute),
+		},
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":k":   {S: aws.String(draftKind)},
+			":now": {N: aws.String(strconv.FormatInt(now.Unix(), 10))},
+		},
+	}, func(page *dynamodb.QueryOutput, _ bool) bool {
+		for _, item := range page.Items {
+			keys = append(keys, aws.StringValue(item["key"].S))
+		}
+
+		return true
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "query expired drafts error")
+	}
+
+	deleted := make(map[string][]byte)
+	for _, key := range keys {
+		result, err := db.client.DeleteItem(&dynamodb.DeleteItemInput{
+			TableName: aws.String(db.draftsTableName),
+			Key: map[string]*dynamodb.AttributeValue{
+				"key": {S: aws.String(key)},
+			},
+			ConditionExpression: aws.String("#expiresAt <= :now"),
+			ExpressionAttributeNames: map[string]*string{
+				"#expiresAt": aws.String(draftExpiresAtAttribute),
+			},
+			ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+				":now": {N: aws.String(strconv.FormatInt(now.Unix(), 10))},
+			},
+			ReturnValues: aws.String(dynamodb.ReturnValueAllOld),
+		})
+		if isConditionFailed(err) {
+			continue
+		}
+		if err != nil {
+			return deleted, errors.Wrapf(err, "failed to delete draft '%s'", key)
+		}
+
+		deleted[key] = result.Attributes["data"].B
+	}
+
+	return deleted, nil
+}
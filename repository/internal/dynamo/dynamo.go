@@ -2,65 +2,142 @@ package dynamo
 
 import (
 	"strconv"
+	"strings"
 
 	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
 	"github.com/aws/aws-sdk-go/aws/credentials"
 	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbattribute"
+	"github.com/incu6us/vote-bot/domain"
 	"github.com/pkg/errors"
 )
 
 var (
-	ErrBadPollName = errors.New("bad poll name")
+	ErrBadPollName            = errors.New("bad poll name")
+	ErrBadPollID              = errors.New("bad poll id")
+	ErrUnknownCredentialsType = errors.New("unknown credentials provider")
+	ErrVersionConflict        = errors.New("item was changed concurrently")
+	ErrItemIsNotFound         = errors.New("item is not found")
+	ErrItemExists             = errors.New("item exists")
 )
 
+// versionCondition guards the updates of a poll read with the version, it also keeps a deleted poll from being recreated
+// by the update. Polls created before versioning have no attribute and are treated as version 0
+const versionCondition = "attribute_exists(subject) AND (attribute_not_exists(#version) OR #version = :version)"
+
+const (
+	EnvCredentialsProvider    = "env"
+	StaticCredentialsProvider = "static"
+	SharedCredentialsProvider = "shared"
+	ChainCredentialsProvider  = "chain"
+)
+
+// Config describes how to reach the table. Endpoint and DisableSSL allow to use DynamoDB Local or another emulator
+type Config struct {
+	Region    string
+	TableName string
+	// DraftsTableName keeps the unfinished polls, "<TableName>-drafts" by default
+	DraftsTableName string
+	Endpoint        string
+	DisableSSL      bool
+	Credentials     Credentials
+	// ReadCapacity and WriteCapacity are provisioned for the table and each of its indexes
+	ReadCapacity  int64
+	WriteCapacity int64
+}
+
+// Credentials selects the credentials provider, by default the keys are taken from the environment
+type Credentials struct {
+	Provider        string
+	AccessKeyID     string
+	SecretAccessKey string
+	SessionToken    string
+	Profile         string
+	Filename        string
+}
+
 type DB struct {
-	tableName string
-	client    *dynamodb.DynamoDB
+	tableName       string
+	draftsTableName string
+	readCapacity    int64
+	writeCapacity   int64
+	client          *dynamodb.DynamoDB
 }
 
-func New(region, tableName string) (*DB, error) {
-	awsCfg := aws.NewConfig().WithRegion(region).WithCredentials(credentials.NewEnvCredentials())
+func New(cfg Config) (*DB, error) {
+	creds, err := newCredentials(cfg.Credentials)
+	if err != nil {
+		return nil, errors.Wrap(err, "create credentials failed")
+	}
+
+	awsCfg := aws.NewConfig().WithRegion(cfg.Region).WithCredentials(creds).WithDisableSSL(cfg.DisableSSL)
+	if cfg.Endpoint != "" {
+		awsCfg = awsCfg.WithEndpoint(cfg.Endpoint)
+	}
 
 	sess, err := session.NewSession(awsCfg)
 	if err != nil {
 		return nil, errors.Wrap(err, "create session failed")
 	}
 
-	return &DB{tableName: tableName, client: dynamodb.New(sess)}, nil
+	db := &DB{
+		tableName:       cfg.TableName,
+		draftsTableName: cfg.DraftsTableName,
+		readCapacity:    cfg.ReadCapacity,
+		writeCapacity:   cfg.WriteCapacity,
+		client:          dynamodb.New(sess),
+	}
+	if db.draftsTableName == "" {
+		db.draftsTableName = cfg.TableName + draftsTableSuffix
+	}
+	if db.readCapacity <= 0 {
+		db.readCapacity = defaultCapacity
+	}
+	if db.writeCapacity <= 0 {
+		db.writeCapacity = defaultCapacity
+	}
+
+	return db, nil
 }
 
-func (db DB) CreateTable() error {
-	_, err := db.client.CreateTable(&dynamodb.CreateTableInput{
-		AttributeDefinitions: []*dynamodb.AttributeDefinition{
-			{
-				AttributeName: aws.String("subject"),
-				AttributeType: aws.String("S"),
-			},
-			{
-				AttributeName: aws.String("created_at"),
-				AttributeType: aws.String("N"),
-			},
-		},
-		KeySchema: []*dynamodb.KeySchemaElement{
-			{
-				AttributeName: aws.String("subject"),
-				KeyType:       aws.String("HASH"),
-			},
-			{
-				AttributeName: aws.String("created_at"),
-				KeyType:       aws.String("RANGE"),
-			},
-		},
-		ProvisionedThroughput: &dynamodb.ProvisionedThroughput{
-			ReadCapacityUnits:  aws.Int64(1),
-			WriteCapacityUnits: aws.Int64(1),
-		},
-		TableName: aws.String(db.tableName),
-	})
+func newCredentials(cfg Credentials) (*credentials.Credentials, error) {
+	switch cfg.Provider {
+	case "", EnvCredentialsProvider:
+		return credentials.NewEnvCredentials(), nil
+	case StaticCredentialsProvider:
+		return credentials.NewStaticCredentials(cfg.AccessKeyID, cfg.SecretAccessKey, cfg.SessionToken), nil
+	case SharedCredentialsProvider:
+		return credentials.NewSharedCredentials(cfg.Filename, cfg.Profile), nil
+	case ChainCredentialsProvider:
+		providers := []credentials.Provider{
+			&credentials.EnvProvider{},
+			&credentials.SharedCredentialsProvider{Filename: cfg.Filename, Profile: cfg.Profile},
+		}
+		if cfg.AccessKeyID != "" {
+			providers = append(providers, &credentials.StaticProvider{Value: credentials.Value{
+				AccessKeyID:     cfg.AccessKeyID,
+				SecretAccessKey: cfg.SecretAccessKey,
+				SessionToken:    cfg.SessionToken,
+			}})
+		}
+
+		return credentials.NewChainCredentials(providers), nil
+	default:
+		return nil, errors.Wrapf(ErrUnknownCredentialsType, "provider '%s'", cfg.Provider)
+	}
+}
 
-	return errors.Wrap(err, "create table failed")
+func (db DB) DeleteTable() error {
+	_, err := db.client.DeleteTable(&dynamodb.DeleteTableInput{TableName: aws.String(db.tableName)})
+	if err != nil {
+		return errors.Wrap(err, "delete table failed")
+	}
+
+	return db.DeleteDraftsTable()
 }
+
 func (db DB) DescribeTable() (string, error) {
 	result, err := db.client.DescribeTable(&dynamodb.DescribeTableInput{TableName: aws.String(db.tableName)})
 	if err != nil {
@@ -70,14 +147,21 @@ func (db DB) DescribeTable() (string, error) {
 	return result.String(), nil
 }
 
-func (db DB) GetPolls() (*dynamodb.ScanOutput, error) {
-	result, err := db.client.Scan(&dynamodb.ScanInput{
-		TableName: aws.String(db.tableName),
-		ScanFilter: map[string]*dynamodb.Condition{
-			"subject": {
-				ComparisonOperator: aws.String("NOT_NULL"),
-			},
+func (db DB) GetPolls() ([]map[string]*dynamodb.AttributeValue, error) {
+	var result []map[string]*dynamodb.AttributeValue
+	err := db.client.QueryPages(&dynamodb.QueryInput{
+		TableName:              aws.String(db.tableName),
+		IndexName:              aws.String(searchIndex),
+		KeyConditionExpression: aws.String("#kind = :k"),
+		ExpressionAttributeNames: map[string]*string{
+			"#kind": aws.String("kind"),
 		},
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":k": {S: aws.String(pollKind)},
+		},
+	}, func(page *dynamodb.QueryOutput, _ bool) bool {
+		result = append(result, page.Items...)
+		return true
 	})
 	if err != nil {
 		return nil, errors.Wrap(err, "get polls error")
@@ -86,14 +170,63 @@ func (db DB) GetPolls() (*dynamodb.ScanOutput, error) {
 	return result, nil
 }
 
+// GetPollsByOwner returns the polls created by the owner, the newest first
+func (db DB) GetPollsByOwner(owner string) ([]map[string]*dynamodb.AttributeValue, error) {
+	var result []map[string]*dynamodb.AttributeValue
+	err := db.client.QueryPages(&dynamodb.QueryInput{
+		TableName:              aws.String(db.tableName),
+		IndexName:              aws.String(ownerIndex),
+		KeyConditionExpression: aws.String("created_by = :owner"),
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":owner": {S: aws.String(owner)},
+		},
+		ScanIndexForward: aws.Bool(false),
+	}, func(page *dynamodb.QueryOutput, _ bool) bool {
+		result = append(result, page.Items...)
+		return true
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "get polls by owner error")
+	}
+
+	return result, nil
+}
+
+// GetPollsClosingBefore returns the open polls with the close time before the given one,
+// the closing index has only the open polls with a close time, so the rest of the polls aren't read
+func (db DB) GetPollsClosingBefore(closesAt int64) ([]map[string]*dynamodb.AttributeValue, error) {
+	var result []map[string]*dynamodb.AttributeValue
+	err := db.client.QueryPages(&dynamodb.QueryInput{
+		TableName:              aws.String(db.tableName),
+		IndexName:              aws.String(closingIndex),
+		KeyConditionExpression: aws.String("#kind = :k AND closing_at <= :closesAt"),
+		ExpressionAttributeNames: map[string]*string{
+			"#kind": aws.String("kind"),
+		},
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":k":        {S: aws.String(pollKind)},
+			":closesAt": {N: aws.String(strconv.FormatInt(closesAt, 10))},
+		},
+	}, func(page *dynamodb.QueryOutput, _ bool) bool {
+		result = append(result, page.Items...)
+		return true
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "get polls closing before error")
+	}
+
+	return result, nil
+}
+
 func (db DB) GetPoll(subject string) (*dynamodb.QueryOutput, error) {
 	if subject == "" {
 		return nil, ErrBadPollName
 	}
 
 	result, err := db.client.Query(&dynamodb.QueryInput{
-		TableName: aws.String(db.tableName),
-		Limit:     aws.Int64(1),
+		TableName:      aws.String(db.tableName),
+		Limit:          aws.Int64(1),
+		ConsistentRead: aws.Bool(true),
 		KeyConditions: map[string]*dynamodb.Condition{
 			"subject": {
 				ComparisonOperator: aws.String("EQ"),
@@ -116,20 +249,23 @@ func (db DB) GetPoll(subject string) (*dynamodb.QueryOutput, error) {
 	return result, nil
 }
 
-func (db DB) GetPollBeginsWith(subject string) (*dynamodb.ScanOutput, error) {
-	if subject == "" {
+func (db DB) GetPollBeginsWith(subject string) (*dynamodb.QueryOutput, error) {
+	searchKey := domain.SearchKey(subject)
+	if searchKey == "" {
 		return nil, ErrBadPollName
 	}
 
-	result, err := db.client.Scan(&dynamodb.ScanInput{
-		TableName: aws.String(db.tableName),
-		ScanFilter: map[string]*dynamodb.Condition{
-			"subject": {
-				ComparisonOperator: aws.String("BEGINS_WITH"),
-				AttributeValueList: []*dynamodb.AttributeValue{
-					{S: aws.String(subject)},
-				},
-			},
+	result, err := db.client.Query(&dynamodb.QueryInput{
+		TableName:              aws.String(db.tableName),
+		IndexName:              aws.String(searchIndex),
+		Limit:                  aws.Int64(1),
+		KeyConditionExpression: aws.String("#kind = :k AND begins_with(search_key, :s)"),
+		ExpressionAttributeNames: map[string]*string{
+			"#kind": aws.String("kind"),
+		},
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":k": {S: aws.String(pollKind)},
+			":s": {S: aws.String(searchKey)},
 		},
 	})
 	if err != nil {
@@ -139,27 +275,62 @@ func (db DB) GetPollBeginsWith(subject string) (*dynamodb.ScanOutput, error) {
 	return result, nil
 }
 
-func (db DB) GetPollByCreatedAt(createdAt int64) (*dynamodb.ScanOutput, error) {
+func (db DB) GetPollByID(id string) (map[string]*dynamodb.AttributeValue, error) {
+	if id == "" {
+		return nil, ErrBadPollID
+	}
+
+	result, err := db.getPollByIndex(idIndex, "id", &dynamodb.AttributeValue{S: aws.String(id)})
+
+	return result, errors.Wrapf(err, "get poll with id '%s' error", id)
+}
+
+func (db DB) GetPollByCreatedAt(createdAt int64) (map[string]*dynamodb.AttributeValue, error) {
 	if createdAt == 0 {
 		return nil, ErrBadPollName
 	}
 
-	result, err := db.client.Scan(&dynamodb.ScanInput{
-		TableName: aws.String(db.tableName),
-		ScanFilter: map[string]*dynamodb.Condition{
-			"created_at": {
-				ComparisonOperator: aws.String("EQ"),
-				AttributeValueList: []*dynamodb.AttributeValue{
-					{N: aws.String(strconv.FormatInt(createdAt, 10))},
-				},
-			},
+	result, err := db.getPollByIndex(createdAtIndex, "created_at", &dynamodb.AttributeValue{N: aws.String(strconv.FormatInt(createdAt, 10))})
+
+	return result, errors.Wrapf(err, "get poll with created_at field '%d' error", createdAt)
+}
+
+// getPollByIndex resolves the key with the index and reads the poll itself consistently,
+// the index is updated asynchronously and can't be used to read the votes
+func (db DB) getPollByIndex(indexName, attribute string, value *dynamodb.AttributeValue) (map[string]*dynamodb.AttributeValue, error) {
+	keys, err := db.client.Query(&dynamodb.QueryInput{
+		TableName:              aws.String(db.tableName),
+		IndexName:              aws.String(indexName),
+		Limit:                  aws.Int64(1),
+		KeyConditionExpression: aws.String("#attr = :v"),
+		ExpressionAttributeNames: map[string]*string{
+			"#attr": aws.String(attribute),
+		},
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":v": value,
 		},
 	})
 	if err != nil {
-		return nil, errors.Wrapf(err, "get poll with created_at field '%d' error", createdAt)
+		return nil, err
 	}
 
-	return result, nil
+	if len(keys.Items) == 0 {
+		return nil, nil
+	}
+
+	result, err := db.client.GetItem(&dynamodb.GetItemInput{
+		TableName:      aws.String(db.tableName),
+		ConsistentRead: aws.Bool(true),
+		Key: map[string]*dynamodb.AttributeValue{
+			"subject":    keys.Items[0]["subject"],
+			"created_at": keys.Items[0]["created_at"],
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return result.Item, nil
 }
 
 func (db DB) GetPollByOwner(subject, owner string) (*dynamodb.QueryOutput, error) {
@@ -168,8 +339,9 @@ func (db DB) GetPollByOwner(subject, owner string) (*dynamodb.QueryOutput, error
 	}
 
 	result, err := db.client.Query(&dynamodb.QueryInput{
-		TableName: aws.String(db.tableName),
-		Limit:     aws.Int64(1),
+		TableName:      aws.String(db.tableName),
+		Limit:          aws.Int64(1),
+		ConsistentRead: aws.Bool(true),
 		KeyConditions: map[string]*dynamodb.Condition{
 			"subject": {
 				ComparisonOperator: aws.String("EQ"),
@@ -195,16 +367,53 @@ func (db DB) GetPollByOwner(subject, owner string) (*dynamodb.QueryOutput, error
 
 	return result, nil
 }
+
+// CreatePoll writes the poll unless an item with its key exists. A poll with the same subject could be created
+// at the same time under another created_at, so the subject is read back after the write: the poll which finds
+// another one removes itself. ErrItemExists is returned in both cases
 func (db DB) CreatePoll(item map[string]*dynamodb.AttributeValue) error {
 	_, err := db.client.PutItem(&dynamodb.PutItemInput{
-		TableName: aws.String(db.tableName),
-		Item:      item,
+		TableName:           aws.String(db.tableName),
+		Item:                withIndexKeys(item),
+		ConditionExpression: aws.String("attribute_not_exists(subject)"),
 	})
+	if isConditionFailed(err) {
+		return ErrItemExists
+	}
 	if err != nil {
 		return errors.Wrap(err, "failed to create items")
 	}
 
-	return nil
+	subject := item["subject"]
+	result, err := db.client.Query(&dynamodb.QueryInput{
+		TableName:              aws.String(db.tableName),
+		ConsistentRead:         aws.Bool(true),
+		KeyConditionExpression: aws.String("subject = :s"),
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":s": subject,
+		},
+		ProjectionExpression: aws.String("created_at"),
+		Limit:                aws.Int64(2),
+	})
+	if err != nil {
+		return errors.Wrapf(err, "failed to check the polls with subject: %s", aws.StringValue(subject.S))
+	}
+	if len(result.Items) < 2 {
+		return nil
+	}
+
+	_, err = db.client.DeleteItem(&dynamodb.DeleteItemInput{
+		TableName: aws.String(db.tableName),
+		Key: map[string]*dynamodb.AttributeValue{
+			"subject":    subject,
+			"created_at": item["created_at"],
+		},
+	})
+	if err != nil {
+		return errors.Wrapf(err, "failed to remove the duplicate of subject: %s", aws.StringValue(subject.S))
+	}
+
+	return ErrItemExists
 }
 
 func (db DB) DeletePoll(subject string, createdAt int64) error {
@@ -222,53 +431,192 @@ func (db DB) DeletePoll(subject string, createdAt int64) error {
 	return nil
 }
 
-func (db DB) UpdateIsPublish(subject string, createdAt int64, isPublished bool) error {
-	_, err := db.client.UpdateItem(&dynamodb.UpdateItemInput{
+// UpdateItems writes items with the votes moved to them and the items revision only if nobody has changed the poll
+// since the poll with the version was read
+func (db DB) UpdateItems(subject string, createdAt int64, items []string, votes map[string]*dynamodb.AttributeValue, itemsRevision, version int64) error {
+	// items are stored as a list, a string set would lose the order of the answers
+	itemList, err := dynamodbattribute.Marshal(items)
+	if err != nil {
+		return errors.Wrap(err, "failed to marshal items")
+	}
+
+	_, err = db.client.UpdateItem(&dynamodb.UpdateItemInput{
 		TableName: aws.String(db.tableName),
 		Key: map[string]*dynamodb.AttributeValue{
 			"subject":    {S: aws.String(subject)},
 			"created_at": {N: aws.String(strconv.FormatInt(createdAt, 10))},
 		},
-		UpdateExpression: aws.String("set is_published = :p"),
+		UpdateExpression:    aws.String("set #itemList = :i, votes = :v, items_revision = :r, #version = :next"),
+		ConditionExpression: aws.String(versionCondition),
+		ExpressionAttributeNames: map[string]*string{
+			"#itemList": aws.String("items"),
+			"#version":  aws.String("version"),
+		},
 		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
-			":p": {BOOL: aws.Bool(isPublished)},
+			":i":       itemList,
+			":v":       {M: votes},
+			":r":       {N: aws.String(strconv.FormatInt(itemsRevision, 10))},
+			":version": {N: aws.String(strconv.FormatInt(version, 10))},
+			":next":    {N: aws.String(strconv.FormatInt(version+1, 10))},
 		},
 	})
+	if isConditionFailed(err) {
+		return db.versionConflict(subject, createdAt)
+	}
 
 	return errors.Wrapf(err, "failed to update subject: %s", subject)
 }
 
-func (db DB) UpdateItems(subject string, createdAt int64, items []string) error {
+// UpdateVotes writes votes only if nobody has changed them since the poll with the version was read
+func (db DB) UpdateVotes(subject string, createdAt int64, votes map[string]*dynamodb.AttributeValue, version int64) error {
 	_, err := db.client.UpdateItem(&dynamodb.UpdateItemInput{
 		TableName: aws.String(db.tableName),
 		Key: map[string]*dynamodb.AttributeValue{
 			"subject":    {S: aws.String(subject)},
 			"created_at": {N: aws.String(strconv.FormatInt(createdAt, 10))},
 		},
-		UpdateExpression: aws.String("set #itemList = :i"),
+		UpdateExpression:    aws.String("set votes = :v, #version = :next"),
+		ConditionExpression: aws.String(versionCondition),
 		ExpressionAttributeNames: map[string]*string{
-			"#itemList": aws.String("items"),
+			"#version": aws.String("version"),
 		},
 		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
-			":i": {SS: aws.StringSlice(items)},
+			":v":       {M: votes},
+			":version": {N: aws.String(strconv.FormatInt(version, 10))},
+			":next":    {N: aws.String(strconv.FormatInt(version+1, 10))},
 		},
 	})
+	if isConditionFailed(err) {
+		return db.versionConflict(subject, createdAt)
+	}
 
-	return errors.Wrapf(err, "failed to update subject: %s", subject)
+	return errors.Wrapf(err, "failed to update votest: %s", subject)
 }
 
-func (db DB) UpdateVotes(subject string, createdAt int64, votes map[string]*dynamodb.AttributeValue) error {
+// UpdateClosedAt writes the close times only if nobody has changed the poll since the poll with the version was read,
+// a zero time removes the attribute. The poll stays in the closing index only while it's open
+func (db DB) UpdateClosedAt(subject string, createdAt, closedAt, closesAt, version int64) error {
+	closingAt := closesAt
+	if closedAt != 0 {
+		closingAt = 0
+	}
+
+	var (
+		set    = []string{"#version = :next"}
+		remove []string
+		values = map[string]*dynamodb.AttributeValue{
+			":version": {N: aws.String(strconv.FormatInt(version, 10))},
+			":next":    {N: aws.String(strconv.FormatInt(version+1, 10))},
+		}
+	)
+	for _, attr := range []struct {
+		name, placeholder string
+		value             int64
+	}{
+		{name: "closed_at", placeholder: ":closedAt", value: closedAt},
+		{name: "closes_at", placeholder: ":closesAt", value: closesAt},
+		{name: "closing_at", placeholder: ":closingAt", value: closingAt},
+	} {
+		if attr.value == 0 {
+			remove = append(remove, attr.name)
+			continue
+		}
+
+		set = append(set, attr.name+" = "+attr.placeholder)
+		values[attr.placeholder] = &dynamodb.AttributeValue{N: aws.String(strconv.FormatInt(attr.value, 10))}
+	}
+
+	expression := "set " + strings.Join(set, ", ")
+	if len(remove) > 0 {
+		expression += " remove " + strings.Join(remove, ", ")
+	}
+
 	_, err := db.client.UpdateItem(&dynamodb.UpdateItemInput{
 		TableName: aws.String(db.tableName),
 		Key: map[string]*dynamodb.AttributeValue{
 			"subject":    {S: aws.String(subject)},
 			"created_at": {N: aws.String(strconv.FormatInt(createdAt, 10))},
 		},
-		UpdateExpression: aws.String("set votes = :v"),
+		UpdateExpression:    aws.String(expression),
+		ConditionExpression: aws.String(versionCondition),
+		ExpressionAttributeNames: map[string]*string{
+			"#version": aws.String("version"),
+		},
+		ExpressionAttributeValues: values,
+	})
+	if isConditionFailed(err) {
+		return db.versionConflict(subject, createdAt)
+	}
+
+	return errors.Wrapf(err, "failed to update close time of subject: %s", subject)
+}
+
+// versionConflict tells why the version condition failed: ErrItemIsNotFound for a deleted poll, ErrVersionConflict otherwise
+func (db DB) versionConflict(subject string, createdAt int64) error {
+	exists, err := db.exists(subject, createdAt)
+	if err != nil {
+		return err
+	}
+
+	if !exists {
+		return ErrItemIsNotFound
+	}
+
+	return ErrVersionConflict
+}
+
+func (db DB) exists(subject string, createdAt int64) (bool, error) {
+	result, err := db.client.GetItem(&dynamodb.GetItemInput{
+		TableName:      aws.String(db.tableName),
+		ConsistentRead: aws.Bool(true),
+		Key: map[string]*dynamodb.AttributeValue{
+			"subject":    {S: aws.String(subject)},
+			"created_at": {N: aws.String(strconv.FormatInt(createdAt, 10))},
+		},
+		ProjectionExpression: aws.String("subject"),
+	})
+	if err != nil {
+		return false, errors.Wrapf(err, "failed to get subject: %s", subject)
+	}
+
+	return len(result.Item) > 0, nil
+}
+
+func isConditionFailed(err error) bool {
+	awsErr, ok := err.(awserr.Error)
+
+	return ok && awsErr.Code() == dynamodb.ErrCodeConditionalCheckFailedException
+}
+
+// UpdateInlineMessages writes the messages the poll has been posted into only if nobody has changed the poll
+// since the poll with the version was read
+func (db DB) UpdateInlineMessages(subject string, createdAt int64, inlineMessageIDs []string, version int64) error {
+	ids, err := dynamodbattribute.Marshal(inlineMessageIDs)
+	if err != nil {
+		return errors.Wrap(err, "failed to marshal inline message ids")
+	}
+
+	_, err = db.client.UpdateItem(&dynamodb.UpdateItemInput{
+		TableName: aws.String(db.tableName),
+		Key: map[string]*dynamodb.AttributeValue{
+			"subject":    {S: aws.String(subject)},
+			"created_at": {N: aws.String(strconv.FormatInt(createdAt, 10))},
+		},
+		UpdateExpression:    aws.String("set #messages = :ids, #version = :next"),
+		ConditionExpression: aws.String(versionCondition),
+		ExpressionAttributeNames: map[string]*string{
+			"#messages": aws.String("inline_message_ids"),
+			"#version":  aws.String("version"),
+		},
 		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
-			":v": {M: votes},
+			":ids":     ids,
+			":version": {N: aws.String(strconv.FormatInt(version, 10))},
+			":next":    {N: aws.String(strconv.FormatInt(version+1, 10))},
 		},
 	})
+	if isConditionFailed(err) {
+		return db.versionConflict(subject, createdAt)
+	}
 
-	return errors.Wrapf(err, "failed to update votest: %s", subject)
+	return errors.Wrapf(err, "failed to update inline messages of subject: %s", subject)
 }
@@ -0,0 +1,427 @@
+package dynamo
+
+import (
+	"log"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/incu6us/vote-bot/domain"
+	"github.com/pkg/errors"
+)
+
+const (
+	// idIndex resolves a poll ID into the primary key of a poll
+	idIndex = "id-index"
+	// createdAtIndex resolves created_at into the primary key of a poll, it's used for the legacy callback data
+	createdAtIndex = "created_at-index"
+	// searchIndex keeps all polls in one partition ordered by the normalized subject
+	searchIndex = "search-index"
+	// ownerIndex keeps the polls of an owner ordered by the creation time
+	ownerIndex = "created_by-index"
+	// closingIndex keeps only the open polls with a close time ordered by it: closing_at is removed when a poll is closed
+	closingIndex = "closing-index"
+
+	pollKind = "poll"
+
+	defaultCapacity   = 5
+	indexPollInterval = 5 * time.Second
+	// indexWaitTimeout limits the wait for a created index, it's built from all items of the table
+	indexWaitTimeout = time.Hour
+)
+
+func (db DB) CreateTable() error {
+	_, err := db.client.CreateTable(&dynamodb.CreateTableInput{
+		AttributeDefinitions: []*dynamodb.AttributeDefinition{
+			{
+				AttributeName: aws.String("subject"),
+				AttributeType: aws.String("S"),
+			},
+			{
+				AttributeName: aws.String("created_at"),
+				AttributeType: aws.String("N"),
+			},
+			{
+				AttributeName: aws.String("id"),
+				AttributeType: aws.String("S"),
+			},
+			{
+				AttributeName: aws.String("kind"),
+				AttributeType: aws.String("S"),
+			},
+			{
+				AttributeName: aws.String("search_key"),
+				AttributeType: aws.String("S"),
+			},
+			{
+				AttributeName: aws.String("closing_at"),
+				AttributeType: aws.String("N"),
+			},
+			{
+				AttributeName: aws.String("created_by"),
+				AttributeType: aws.String("S"),
+			},
+		},
+		KeySchema: []*dynamodb.KeySchemaElement{
+			{
+				AttributeName: aws.String("subject"),
+				KeyType:       aws.String("HASH"),
+			},
+			{
+				AttributeName: aws.String("created_at"),
+				KeyType:       aws.String("RANGE"),
+			},
+		},
+		GlobalSecondaryIndexes: []*dynamodb.GlobalSecondaryIndex{
+			db.keysOnlyIndex(idIndex, "id"),
+			db.keysOnlyIndex(createdAtIndex, "created_at"),
+			db.searchIndex(),
+			db.closingIndex(),
+			db.ownerIndex(),
+		},
+		ProvisionedThroughput: db.throughput(),
+		TableName:             aws.String(db.tableName),
+	})
+	if err != nil {
+		return errors.Wrap(err, "create table failed")
+	}
+
+	err = db.client.WaitUntilTableExists(&dynamodb.DescribeTableInput{TableName: aws.String(db.tableName)})
+	if err != nil {
+		return errors.Wrap(err, "wait for table failed")
+	}
+
+	return db.CreateDraftsTable()
+}
+
+// Migrate brings a table created by the previous versions to the current layout:
+// it fills IDs and the search attributes of the existing polls, creates the missing indexes and the drafts table.
+// The polls are scanned only while the indexes built on the filled attributes are missing, they are created after the scan,
+// so an interrupted migration is repeated on the next start
+func (db DB) Migrate() error {
+	existing, err := db.indexNames(db.tableName)
+	if err != nil {
+		return err
+	}
+
+	if !existing[idIndex] || !existing[searchIndex] {
+		log.Printf("filling ids and search attributes of the polls in table %s", db.tableName)
+		if err := db.backfill(); err != nil {
+			return errors.Wrap(err, "backfill attributes failed")
+		}
+	}
+
+	if !existing[closingIndex] {
+		log.Printf("filling close times of the open polls in table %s", db.tableName)
+		if err := db.backfillClosing(); err != nil {
+			return errors.Wrap(err, "backfill close times failed")
+		}
+	}
+
+	hasDrafts, err := db.hasDraftsTable()
+	if err != nil {
+		return err
+	}
+
+	if !hasDrafts {
+		log.Printf("creating drafts table %s", db.draftsTableName)
+		if err := db.CreateDraftsTable(); err != nil {
+			return err
+		}
+	} else if err := db.migrateDraftsTable(); err != nil {
+		return err
+	}
+
+	indexes := []struct {
+		index      *dynamodb.GlobalSecondaryIndex
+		attributes []*dynamodb.AttributeDefinition
+	}{
+		{
+			index: db.keysOnlyIndex(idIndex, "id"),
+			attributes: []*dynamodb.AttributeDefinition{
+				{AttributeName: aws.String("id"), AttributeType: aws.String("S")},
+			},
+		},
+		{
+			index: db.keysOnlyIndex(createdAtIndex, "created_at"),
+			attributes: []*dynamodb.AttributeDefinition{
+				{AttributeName: aws.String("created_at"), AttributeType: aws.String("N")},
+			},
+		},
+		{
+			index: db.searchIndex(),
+			attributes: []*dynamodb.AttributeDefinition{
+				{AttributeName: aws.String("kind"), AttributeType: aws.String("S")},
+				{AttributeName: aws.String("search_key"), AttributeType: aws.String("S")},
+			},
+		},
+		{
+			index: db.closingIndex(),
+			attributes: []*dynamodb.AttributeDefinition{
+				{AttributeName: aws.String("kind"), AttributeType: aws.String("S")},
+				{AttributeName: aws.String("closing_at"), AttributeType: aws.String("N")},
+			},
+		},
+		{
+			index: db.ownerIndex(),
+			attributes: []*dynamodb.AttributeDefinition{
+				{AttributeName: aws.String("created_by"), AttributeType: aws.String("S")},
+				{AttributeName: aws.String("created_at"), AttributeType: aws.String("N")},
+			},
+		},
+	}
+	for _, index := range indexes {
+		if existing[aws.StringValue(index.index.IndexName)] {
+			continue
+		}
+
+		if err := db.createIndex(db.tableName, index.index, index.attributes); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// indexNames returns the names of the global secondary indexes of the table
+func (db DB) indexNames(tableName string) (map[string]bool, error) {
+	result, err := db.client.DescribeTable(&dynamodb.DescribeTableInput{TableName: aws.String(tableName)})
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to get description of table %s", tableName)
+	}
+
+	names := make(map[string]bool)
+	for _, index := range result.Table.GlobalSecondaryIndexes {
+		names[aws.StringValue(index.IndexName)] = true
+	}
+
+	return names, nil
+}
+
+// createIndex adds the index to the existing table and waits until it's built
+func (db DB) createIndex(tableName string, index *dynamodb.GlobalSecondaryIndex, attributes []*dynamodb.AttributeDefinition) error {
+	name := aws.StringValue(index.IndexName)
+	log.Printf("creating index %s on table %s", name, tableName)
+
+	// only one index could be created by a single update
+	_, err := db.client.UpdateTable(&dynamodb.UpdateTableInput{
+		TableName:            aws.String(tableName),
+		AttributeDefinitions: attributes,
+		GlobalSecondaryIndexUpdates: []*dynamodb.GlobalSecondaryIndexUpdate{
+			{
+				Create: &dynamodb.CreateGlobalSecondaryIndexAction{
+					IndexName:             index.IndexName,
+					KeySchema:             index.KeySchema,
+					Projection:            index.Projection,
+					ProvisionedThroughput: index.ProvisionedThroughput,
+				},
+			},
+		},
+	})
+	if err != nil {
+		return errors.Wrapf(err, "create index %s failed", name)
+	}
+
+	return db.waitForIndex(tableName, name)
+}
+
+func (db DB) backfill() error {
+	var updateErr error
+	err := db.client.ScanPages(&dynamodb.ScanInput{
+		TableName:            aws.String(db.tableName),
+		FilterExpression:     aws.String("attribute_not_exists(search_key) OR attribute_not_exists(id)"),
+		ProjectionExpression: aws.String("subject, created_at"),
+	}, func(page *dynamodb.ScanOutput, _ bool) bool {
+		for _, item := range page.Items {
+			var id string
+			if id, updateErr = domain.NewPollID(); updateErr != nil {
+				return false
+			}
+
+			_, updateErr = db.client.UpdateItem(&dynamodb.UpdateItemInput{
+				TableName: aws.String(db.tableName),
+				Key: map[string]*dynamodb.AttributeValue{
+					"subject":    item["subject"],
+					"created_at": item["created_at"],
+				},
+				UpdateExpression: aws.String("set #kind = :k, search_key = :s, id = if_not_exists(id, :id)"),
+				ExpressionAttributeNames: map[string]*string{
+					"#kind": aws.String("kind"),
+				},
+				ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+					":k":  {S: aws.String(pollKind)},
+					":s":  {S: aws.String(domain.SearchKey(aws.StringValue(item["subject"].S)))},
+					":id": {S: aws.String(id)},
+				},
+			})
+			if updateErr != nil {
+				return false
+			}
+		}
+
+		return true
+	})
+	if err != nil {
+		return err
+	}
+
+	return updateErr
+}
+
+// backfillClosing copies the close time of the open polls into the attribute the closing index is built on
+func (db DB) backfillClosing() error {
+	var updateErr error
+	err := db.client.ScanPages(&dynamodb.ScanInput{
+		TableName:            aws.String(db.tableName),
+		FilterExpression:     aws.String("attribute_exists(closes_at) AND attribute_not_exists(closed_at)"),
+		ProjectionExpression: aws.String("subject, created_at, closes_at"),
+	}, func(page *dynamodb.ScanOutput, _ bool) bool {
+		for _, item := range page.Items {
+			_, updateErr = db.client.UpdateItem(&dynamodb.UpdateItemInput{
+				TableName: aws.String(db.tableName),
+				Key: map[string]*dynamodb.AttributeValue{
+					"subject":    item["subject"],
+					"created_at": item["created_at"],
+				},
+				UpdateExpression:    aws.String("set closing_at = :c"),
+				ConditionExpression: aws.String("attribute_exists(subject) AND attribute_not_exists(closed_at)"),
+				ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+					":c": item["closes_at"],
+				},
+			})
+			if isConditionFailed(updateErr) {
+				// closed or deleted after the scan
+				updateErr = nil
+			}
+			if updateErr != nil {
+				return false
+			}
+		}
+
+		return true
+	})
+	if err != nil {
+		return err
+	}
+
+	return updateErr
+}
+
+func (db DB) waitForIndex(tableName, name string) error {
+	deadline := time.Now().Add(indexWaitTimeout)
+	for {
+		result, err := db.client.DescribeTable(&dynamodb.DescribeTableInput{TableName: aws.String(tableName)})
+		if err != nil {
+			return errors.Wrap(err, "failed to get table description")
+		}
+
+		for _, index := range result.Table.GlobalSecondaryIndexes {
+			if aws.StringValue(index.IndexName) == name && aws.StringValue(index.IndexStatus) == dynamodb.IndexStatusActive {
+				return nil
+			}
+		}
+
+		if time.Now().After(deadline) {
+			return errors.Errorf("index %s isn't active after %s", name, indexWaitTimeout)
+		}
+
+		time.Sleep(indexPollInterval)
+	}
+}
+
+// keysOnlyIndex is used to resolve the attribute into the primary key of a poll
+func (db DB) keysOnlyIndex(name, attribute string) *dynamodb.GlobalSecondaryIndex {
+	return &dynamodb.GlobalSecondaryIndex{
+		IndexName: aws.String(name),
+		KeySchema: []*dynamodb.KeySchemaElement{
+			{
+				AttributeName: aws.String(attribute),
+				KeyType:       aws.String("HASH"),
+			},
+		},
+		Projection: &dynamodb.Projection{
+			ProjectionType: aws.String(dynamodb.ProjectionTypeKeysOnly),
+		},
+		ProvisionedThroughput: db.throughput(),
+	}
+}
+
+func (db DB) searchIndex() *dynamodb.GlobalSecondaryIndex {
+	return &dynamodb.GlobalSecondaryIndex{
+		IndexName: aws.String(searchIndex),
+		KeySchema: []*dynamodb.KeySchemaElement{
+			{
+				AttributeName: aws.String("kind"),
+				KeyType:       aws.String("HASH"),
+			},
+			{
+				AttributeName: aws.String("search_key"),
+				KeyType:       aws.String("RANGE"),
+			},
+		},
+		Projection: &dynamodb.Projection{
+			ProjectionType: aws.String(dynamodb.ProjectionTypeAll),
+		},
+		ProvisionedThroughput: db.throughput(),
+	}
+}
+
+func (db DB) closingIndex() *dynamodb.GlobalSecondaryIndex {
+	return &dynamodb.GlobalSecondaryIndex{
+		IndexName: aws.String(closingIndex),
+		KeySchema: []*dynamodb.KeySchemaElement{
+			{
+				AttributeName: aws.String("kind"),
+				KeyType:       aws.String("HASH"),
+			},
+			{
+				AttributeName: aws.String("closing_at"),
+				KeyType:       aws.String("RANGE"),
+			},
+		},
+		Projection: &dynamodb.Projection{
+			ProjectionType: aws.String(dynamodb.ProjectionTypeAll),
+		},
+		ProvisionedThroughput: db.throughput(),
+	}
+}
+
+func (db DB) ownerIndex() *dynamodb.GlobalSecondaryIndex {
+	return &dynamodb.GlobalSecondaryIndex{
+		IndexName: aws.String(ownerIndex),
+		KeySchema: []*dynamodb.KeySchemaElement{
+			{
+				AttributeName: aws.String("created_by"),
+				KeyType:       aws.String("HASH"),
+			},
+			{
+				AttributeName: aws.String("created_at"),
+				KeyType:       aws.String("RANGE"),
+			},
+		},
+		Projection: &dynamodb.Projection{
+			ProjectionType: aws.String(dynamodb.ProjectionTypeAll),
+		},
+		ProvisionedThroughput: db.throughput(),
+	}
+}
+
+func (db DB) throughput() *dynamodb.ProvisionedThroughput {
+	return &dynamodb.ProvisionedThroughput{
+		ReadCapacityUnits:  aws.Int64(db.readCapacity),
+		WriteCapacityUnits: aws.Int64(db.writeCapacity),
+	}
+}
+
+// withIndexKeys adds the attributes the search and the closing indexes are built on
+func withIndexKeys(item map[string]*dynamodb.AttributeValue) map[string]*dynamodb.AttributeValue {
+	item["kind"] = &dynamodb.AttributeValue{S: aws.String(pollKind)}
+	if subject, ok := item["subject"]; ok {
+		item["search_key"] = &dynamodb.AttributeValue{S: aws.String(domain.SearchKey(aws.StringValue(subject.S)))}
+	}
+	if closesAt, ok := item["closes_at"]; ok && item["closed_at"] == nil {
+		item["closing_at"] = closesAt
+	}
+
+	return item
+}
@@ -0,0 +1,317 @@
+package repository
+
+import (
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/incu6us/vote-bot/domain"
+	"github.com/pkg/errors"
+)
+
+// MemoryRepository keeps polls in the process memory, it is intended for tests and local development
+type MemoryRepository struct {
+	rwMu   sync.RWMutex
+	polls  map[string]*domain.Poll
+	drafts map[string]memoryDraft
+}
+
+type memoryDraft struct {
+	data      []byte
+	expiresAt time.Time
+}
+
+func NewMemory() *MemoryRepository {
+	return &MemoryRepository{
+		polls:  make(map[string]*domain.Poll),
+		drafts: make(map[string]memoryDraft),
+	}
+}
+
+func (r *MemoryRepository) GetPolls() ([]*domain.Poll, error) {
+	r.rwMu.RLock()
+	defer r.rwMu.RUnlock()
+
+	polls := make([]*domain.Poll, 0, len(r.polls))
+	for _, subject := range r.sortedSubjects() {
+		polls = append(polls, clonePoll(r.polls[subject]))
+	}
+
+	return polls, nil
+}
+
+func (r *MemoryRepository) GetPoll(pollName string) (*domain.Poll, error) {
+	r.rwMu.RLock()
+	defer r.rwMu.RUnlock()
+
+	poll, ok := r.polls[strings.TrimSpace(pollName)]
+	if !ok {
+		return nil, ErrPollIsNotFound
+	}
+
+	return clonePoll(poll), nil
+}
+
+func (r *MemoryRepository) GetPollBeginsWith(pollName string) (*domain.Poll, error) {
+	r.rwMu.RLock()
+	defer r.rwMu.RUnlock()
+
+	prefix := domain.SearchKey(pollName)
+	if prefix == "" {
+		return nil, ErrBadPollName
+	}
+
+	// the other storages return the first poll in the order of the search keys
+	subjects := r.sortedSubjects()
+	sort.SliceStable(subjects, func(i, j int) bool {
+		return domain.SearchKey(subjects[i]) < domain.SearchKey(subjects[j])
+	})
+	for _, subject := range subjects {
+		if strings.HasPrefix(domain.SearchKey(subject), prefix) {
+			return clonePoll(r.polls[subject]), nil
+		}
+	}
+
+	return nil, ErrPollIsNotFound
+}
+
+func (r *MemoryRepository) GetPollByID(id string) (*domain.Poll, error) {
+	r.rwMu.RLock()
+	defer r.rwMu.RUnlock()
+
+	for _, poll := range r.polls {
+		if poll.ID == id {
+			return clonePoll(poll), nil
+		}
+	}
+
+	return nil, ErrPollIsNotFound
+}
+
+func (r *MemoryRepository) GetPollByCreatedAt(createdAt int64) (*domain.Poll, error) {
+	r.rwMu.RLock()
+	defer r.rwMu.RUnlock()
+
+	for _, poll := range r.polls {
+		if poll.CreatedAt == createdAt {
+			return clonePoll(poll), nil
+		}
+	}
+
+	return nil, ErrPollIsNotFound
+}
+
+func (r *MemoryRepository) CreatePoll(poll *domain.Poll) error {
+	if err := initPoll(poll); err != nil {
+		return errors.Wrap(err, "create poll failed")
+	}
+
+	r.rwMu.Lock()
+	defer r.rwMu.Unlock()
+
+	if _, ok := r.polls[poll.Subject]; ok {
+		return ErrPollAlreadyExist
+	}
+
+	r.polls[poll.Subject] = clonePoll(poll)
+
+	return nil
+}
+
+func (r *MemoryRepository) DeletePoll(pollName, owner string) error {
+	r.rwMu.Lock()
+	defer r.rwMu.Unlock()
+
+	poll, err := r.getPollByOwner(strings.TrimSpace(pollName), owner)
+	if err != nil {
+		return err
+	}
+
+	delete(r.polls, poll.Subject)
+
+	return nil
+}
+
+func (r *MemoryRepository) UpdatePollIsClosed(pollName, owner string, isClosed bool) (*domain.Poll, error) {
+	r.rwMu.Lock()
+	defer r.rwMu.Unlock()
+
+	poll, err := r.getPollByOwner(strings.TrimSpace(pollName), owner)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := setIsClosed(poll, isClosed, time.Now()); err != nil {
+		return nil, err
+	}
+
+	return clonePoll(poll), nil
+}
+
+func (r *MemoryRepository) UpdatePollItems(pollName, owner string, items []string, kept map[string]string) (*domain.Poll, error) {
+	r.rwMu.Lock()
+	defer r.rwMu.Unlock()
+
+	poll, err := r.getPollByOwner(strings.TrimSpace(pollName), owner)
+	if err != nil {
+		return nil, err
+	}
+
+	poll.SetItems(items, kept)
+
+	return clonePoll(poll), nil
+}
+
+func (r *MemoryRepository) UpdateVote(pollID string, item, voter string) (*domain.Poll, error) {
+	r.rwMu.Lock()
+	defer r.rwMu.Unlock()
+
+	poll, err := r.getPollByID(pollID)
+	if err != nil {
+		return nil, err
+	}
+
+	if poll.IsClosed(time.Now()) {
+		return nil, ErrPollIsClosed
+	}
+
+	poll.Vote(item, voter)
+
+	return clonePoll(poll), nil
+}
+
+func (r *MemoryRepository) GetPollsByOwner(owner string) ([]*domain.Poll, error) {
+	polls, err := r.GetPolls()
+	if err != nil {
+		return nil, err
+	}
+
+	return ownerPolls(polls, owner), nil
+}
+
+func (r *MemoryRepository) GetExpiredPolls(now time.Time) ([]*domain.Poll, error) {
+	polls, err := r.GetPolls()
+	if err != nil {
+		return nil, err
+	}
+
+	return expiredPolls(polls, now), nil
+}
+
+func (r *MemoryRepository) ClosePoll(pollID string) (*domain.Poll, error) {
+	r.rwMu.Lock()
+	defer r.rwMu.Unlock()
+
+	poll, err := r.getPollByID(pollID)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := setIsClosed(poll, true, time.Now()); err != nil {
+		return nil, err
+	}
+
+	return clonePoll(poll), nil
+}
+
+func (r *MemoryRepository) AddInlineMessage(pollID, inlineMessageID string) error {
+	r.rwMu.Lock()
+	defer r.rwMu.Unlock()
+
+	poll, err := r.getPollByID(pollID)
+	if err != nil {
+		return err
+	}
+
+	poll.AddInlineMessage(inlineMessageID)
+
+	return nil
+}
+
+func (r *MemoryRepository) GetDraft(key string) ([]byte, error) {
+	r.rwMu.RLock()
+	defer r.rwMu.RUnlock()
+
+	draft, ok := r.drafts[key]
+	if !ok || !draft.expiresAt.After(time.Now()) {
+		return nil, ErrDraftIsNotFound
+	}
+
+	return append([]byte(nil), draft.data...), nil
+}
+
+func (r *MemoryRepository) SaveDraft(key string, data []byte, expiresAt time.Time) error {
+	r.rwMu.Lock()
+	defer r.rwMu.Unlock()
+
+	r.drafts[key] = memoryDraft{data: append([]byte(nil), data...), expiresAt: expiresAt}
+
+	return nil
+}
+
+func (r *MemoryRepository) DeleteDraft(key string) error {
+	r.rwMu.Lock()
+	defer r.rwMu.Unlock()
+
+	delete(r.drafts, key)
+
+	return nil
+}
+
+func (r *MemoryRepository) DeleteExpiredDrafts(now time.Time) (map[string][]byte, error) {
+	r.rwMu.Lock()
+	defer r.rwMu.Unlock()
+
+	result := make(map[string][]byte)
+	for key, draft := range r.drafts {
+		if !draft.expiresAt.After(now) {
+			result[key] = draft.data
+			delete(r.drafts, key)
+		}
+	}
+
+	return result, nil
+}
+
+func (r *MemoryRepository) getPollByID(pollID string) (*domain.Poll, error) {
+	for _, poll := range r.polls {
+		if poll.ID == pollID {
+			return poll, nil
+		}
+	}
+
+	return nil, ErrPollIsNotFound
+}
+
+func (r *MemoryRepository) getPollByOwner(pollName, owner string) (*domain.Poll, error) {
+	poll, ok := r.polls[pollName]
+	if !ok || poll.CreatedBy != owner {
+		return nil, ErrPollIsNotFound
+	}
+
+	return poll, nil
+}
+
+func (r *MemoryRepository) sortedSubjects() []string {
+	subjects := make([]string, 0, len(r.polls))
+	for subject := range r.polls {
+		subjects = append(subjects, subject)
+	}
+	sort.Strings(subjects)
+
+	return subjects
+}
+
+// clonePoll detaches a stored poll from the caller so it can't be modified outside of the lock
+func clonePoll(poll *domain.Poll) *domain.Poll {
+	clone := *poll
+	clone.Items = append([]string(nil), poll.Items...)
+	clone.InlineMessageIDs = append([]string(nil), poll.InlineMessageIDs...)
+	clone.Votes = make(map[string][]string, len(poll.Votes))
+	for item, voters := range poll.Votes {
+		clone.Votes[item] = append([]string(nil), voters...)
+	}
+
+	return &clone
+}
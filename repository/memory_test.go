@@ -0,0 +1,30 @@
+package repository
+
+import (
+	"testing"
+
+	"github.com/incu6us/vote-bot/domain"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMemoryRepository(t *testing.T) {
+	testStorage(t, func(t *testing.T) (Storage, func()) {
+		return NewMemory(), func() {}
+	})
+}
+
+func TestMemoryRepository_returnsCopies(t *testing.T) {
+	repo := NewMemory()
+	require.NoError(t, repo.CreatePoll(&domain.Poll{Subject: "test", CreatedBy: "me", Items: []string{"1", "2"}}))
+
+	poll, err := repo.GetPoll("test")
+	require.NoError(t, err)
+	poll.Items[0] = "changed"
+	poll.Votes["1"] = []string{"alice"}
+
+	stored, err := repo.GetPoll("test")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"1", "2"}, stored.Items)
+	assert.Empty(t, stored.Votes)
+}
@@ -1,9 +1,9 @@
 package repository
 
 import (
-	"log"
+	"math/rand"
+	"sort"
 	"strings"
-	"sync"
 	"time"
 
 	"github.com/aws/aws-sdk-go/service/dynamodb"
@@ -16,15 +16,79 @@ import (
 var (
 	ErrPollIsNotFound   = errors.New("poll is not found")
 	ErrPollAlreadyExist = errors.New("poll already exist")
+	ErrPollIsClosed     = errors.New("poll is closed")
+	ErrPollIsOpen       = errors.New("poll is open")
+	ErrDraftIsNotFound  = errors.New("draft is not found")
+	// ErrBadPollName is returned for an empty poll name
+	ErrBadPollName = errors.New("bad poll name")
+
+	// errInlineMessageIsKnown stops the update of a poll which has the message already
+	errInlineMessageIsKnown = errors.New("inline message is known")
 )
 
+const (
+	// updatePollTimeout bounds the retries of a conflicting update, a burst of votes on a popular poll
+	// is retried until every vote is written
+	updatePollTimeout = 10 * time.Second
+	// updateRetryDelay grows with every conflict up to maxUpdateRetryDelay, the pause is random within it
+	updateRetryDelay    = 20 * time.Millisecond
+	maxUpdateRetryDelay = 500 * time.Millisecond
+)
+
+// Storage is implemented by every poll storage backend
+type Storage interface {
+	DraftStorage
+
+	GetPolls() ([]*domain.Poll, error)
+	// GetPollsByOwner returns the polls created by the owner, the newest first
+	GetPollsByOwner(owner string) ([]*domain.Poll, error)
+	GetPoll(pollName string) (*domain.Poll, error)
+	GetPollBeginsWith(pollName string) (*domain.Poll, error)
+	GetPollByID(id string) (*domain.Poll, error)
+	// GetPollByCreatedAt is kept for the messages posted before polls got IDs
+	GetPollByCreatedAt(createdAt int64) (*domain.Poll, error)
+	// CreatePoll stores a new poll, ID, CreatedAt and Votes of the poll are set by the storage
+	CreatePoll(poll *domain.Poll) error
+	DeletePoll(pollName, owner string) error
+	// UpdatePollIsClosed closes or reopens the poll of the owner,
+	// ErrPollIsClosed or ErrPollIsOpen is returned if the poll is in the state already
+	UpdatePollIsClosed(pollName, owner string, isClosed bool) (*domain.Poll, error)
+	// UpdatePollItems replaces the items of the owner's poll, kept maps the previous items to their new names (see domain.Poll.SetItems)
+	UpdatePollItems(pollName, owner string, items []string, kept map[string]string) (*domain.Poll, error)
+	// UpdateVote returns ErrPollIsClosed when the voting has ended
+	UpdateVote(pollID string, item, voter string) (*domain.Poll, error)
+	// GetExpiredPolls returns the polls which are still open, but their close time has passed
+	GetExpiredPolls(now time.Time) ([]*domain.Poll, error)
+	// ClosePoll ends the voting, ErrPollIsClosed is returned if the poll has been closed already
+	ClosePoll(pollID string) (*domain.Poll, error)
+	// AddInlineMessage remembers a message the poll has been posted into
+	AddInlineMessage(pollID, inlineMessageID string) error
+}
+
+// DraftStorage keeps the unfinished polls of the creation wizard, so they survive restarts and are shared by the bot instances
+type DraftStorage interface {
+	// GetDraft returns ErrDraftIsNotFound for a missing or expired draft
+	GetDraft(key string) ([]byte, error)
+	// SaveDraft replaces the draft, it's treated as missing after expiresAt
+	SaveDraft(key string, data []byte, expiresAt time.Time) error
+	DeleteDraft(key string) error
+	// DeleteExpiredDrafts removes the drafts expired before now and returns their data by the keys
+	DeleteExpiredDrafts(now time.Time) (map[string][]byte, error)
+}
+
+// DynamoConfig holds the table location and the credentials for the DynamoDB repository
+type DynamoConfig = dynamo.Config
+
+// DynamoCredentials selects one of the credentials providers: "env" (default), "static", "shared" or "chain"
+type DynamoCredentials = dynamo.Credentials
+
+// Repository stores polls in DynamoDB
 type Repository struct {
-	mu sync.Mutex
 	db *dynamo.DB
 }
 
-func New(region, tableName string) (*Repository, error) {
-	db, err := dynamo.New(region, tableName)
+func New(cfg DynamoConfig) (*Repository, error) {
+	db, err := dynamo.New(cfg)
 	if err != nil {
 		return nil, errors.Wrap(err, "create repository failed")
 	}
@@ -36,34 +100,46 @@ func (r *Repository) CreateTable() error {
 	return r.db.CreateTable()
 }
 
+func (r *Repository) DeleteTable() error {
+	return r.db.DeleteTable()
+}
+
+// Migrate upgrades the table layout created by the previous versions of the bot
+func (r *Repository) Migrate() error {
+	return r.db.Migrate()
+}
+
 func (r *Repository) DescribeTable() (string, error) {
 	return r.db.DescribeTable()
 }
 
 func (r *Repository) GetPolls() ([]*domain.Poll, error) {
-	r.mu.Lock()
-	defer r.mu.Unlock()
-
 	result, err := r.db.GetPolls()
 	if err != nil {
 		return nil, errors.Wrap(err, "can't get polls from repository")
 	}
 
-	return r.convertMapToPoll(result.Items...)
+	return r.convertMapToPoll(result...)
 }
 
-func (r *Repository) GetPoll(pollName string) (*domain.Poll, error) {
-	r.mu.Lock()
-	defer r.mu.Unlock()
+func (r *Repository) GetPollsByOwner(owner string) ([]*domain.Poll, error) {
+	result, err := r.db.GetPollsByOwner(owner)
+	if err != nil {
+		return nil, errors.Wrap(err, "can't get polls of the owner from repository")
+	}
+
+	return r.convertMapToPoll(result...)
+}
 
+func (r *Repository) GetPoll(pollName string) (*domain.Poll, error) {
 	return r.getPoll(strings.TrimSpace(pollName))
 }
 
 func (r *Repository) GetPollBeginsWith(pollName string) (*domain.Poll, error) {
-	r.mu.Lock()
-	defer r.mu.Unlock()
-
 	item, err := r.db.GetPollBeginsWith(strings.TrimSpace(pollName))
+	if err == dynamo.ErrBadPollName {
+		return nil, ErrBadPollName
+	}
 	if err != nil {
 		return nil, errors.Wrap(err, "failed to get a poll by name")
 	}
@@ -80,11 +156,8 @@ func (r *Repository) GetPollBeginsWith(pollName string) (*domain.Poll, error) {
 	return poll, nil
 }
 
-func (r *Repository) CreatePoll(pollName, owner string, items []string) error {
-	r.mu.Lock()
-	defer r.mu.Unlock()
-
-	storedPoll, err := r.getPoll(strings.TrimSpace(pollName))
+func (r *Repository) CreatePoll(poll *domain.Poll) error {
+	storedPoll, err := r.getPoll(strings.TrimSpace(poll.Subject))
 	if err != nil && errors.Cause(err) != ErrPollIsNotFound {
 		return errors.Wrap(err, "create poll failed")
 	}
@@ -93,12 +166,8 @@ func (r *Repository) CreatePoll(pollName, owner string, items []string) error {
 		return ErrPollAlreadyExist
 	}
 
-	poll := &domain.Poll{
-		CreatedAt: time.Now().UnixNano(),
-		Subject:   strings.TrimSpace(pollName),
-		Items:     items,
-		Votes:     map[string][]string{},
-		CreatedBy: owner,
+	if err := initPoll(poll); err != nil {
+		return errors.Wrap(err, "create poll failed")
 	}
 
 	item, err := dynamodbattribute.MarshalMap(poll)
@@ -106,13 +175,38 @@ func (r *Repository) CreatePoll(pollName, owner string, items []string) error {
 		return errors.Wrap(err, "filed to marshal an item")
 	}
 
-	return r.db.CreatePoll(item)
+	err = r.db.CreatePoll(item)
+	if err == dynamo.ErrItemExists {
+		return ErrPollAlreadyExist
+	}
+
+	return err
 }
 
-func (r *Repository) DeletePoll(pollName, owner string) error {
-	r.mu.Lock()
-	defer r.mu.Unlock()
+// initPoll fills the fields which are set by the storage on creation
+func initPoll(poll *domain.Poll) error {
+	id, err := domain.NewPollID()
+	if err != nil {
+		return err
+	}
 
+	poll.ID = id
+	if poll.Anonymous && poll.Salt == "" {
+		if poll.Salt, err = domain.NewSalt(); err != nil {
+			return err
+		}
+	}
+	poll.CreatedAt = time.Now().UnixNano()
+	poll.Subject = strings.TrimSpace(poll.Subject)
+	poll.Votes = map[string][]string{}
+	poll.ClosedAt = 0
+	poll.InlineMessageIDs = nil
+	poll.Version = 0
+
+	return nil
+}
+
+func (r *Repository) DeletePoll(pollName, owner string) error {
 	result, err := r.db.GetPollByOwner(strings.TrimSpace(pollName), owner)
 	if err != nil {
 		return err
@@ -130,95 +224,194 @@ func (r *Repository) DeletePoll(pollName, owner string) error {
 	return r.db.DeletePoll(strings.TrimSpace(pollName), poll.CreatedAt)
 }
 
-func (r *Repository) UpdatePollIsPublished(pollName, owner string, isPublished bool) error {
-	r.mu.Lock()
-	defer r.mu.Unlock()
+func (r *Repository) UpdatePollIsClosed(pollName, owner string, isClosed bool) (*domain.Poll, error) {
+	return r.updateIsClosed(func() (*domain.Poll, error) {
+		return r.getPollByOwner(strings.TrimSpace(pollName), owner)
+	}, isClosed)
+}
 
-	result, err := r.db.GetPollByOwner(strings.TrimSpace(pollName), owner)
-	if err != nil {
-		return err
-	}
+func (r *Repository) UpdatePollItems(pollName, owner string, items []string, kept map[string]string) (*domain.Poll, error) {
+	return r.updatePoll(func() (*domain.Poll, error) {
+		return r.getPollByOwner(strings.TrimSpace(pollName), owner)
+	}, func(poll *domain.Poll) error {
+		poll.SetItems(items, kept)
+		return nil
+	}, func(poll *domain.Poll, version int64) error {
+		votes, err := dynamodbattribute.MarshalMap(poll.Votes)
+		if err != nil {
+			return errors.Wrap(err, "failed to marshal votes")
+		}
 
-	if len(result.Items) == 0 {
-		return ErrPollIsNotFound
-	}
+		return r.db.UpdateItems(poll.Subject, poll.CreatedAt, poll.Items, votes, poll.ItemsRevision, version)
+	})
+}
 
-	var poll domain.Poll
-	if err := dynamodbattribute.UnmarshalMap(result.Items[0], &poll); err != nil {
-		return err
-	}
+func (r *Repository) UpdateVote(pollID string, item, voter string) (*domain.Poll, error) {
+	return r.updatePoll(func() (*domain.Poll, error) {
+		return r.GetPollByID(pollID)
+	}, func(poll *domain.Poll) error {
+		if poll.IsClosed(time.Now()) {
+			return ErrPollIsClosed
+		}
+
+		poll.Vote(item, voter)
+		return nil
+	}, func(poll *domain.Poll, version int64) error {
+		votes, err := dynamodbattribute.MarshalMap(poll.Votes)
+		if err != nil {
+			return errors.Wrap(err, "failed to marshal votes")
+		}
 
-	return r.db.UpdateIsPublish(strings.TrimSpace(pollName), poll.CreatedAt, isPublished)
+		return r.db.UpdateVotes(poll.Subject, poll.CreatedAt, votes, version)
+	})
 }
 
-func (r *Repository) UpdatePollItems(pollName, owner string, items []string) error {
-	r.mu.Lock()
-	defer r.mu.Unlock()
+// updatePoll doesn't lock anything: the changes made by fn are written only if the poll wasn't changed after the read,
+// otherwise the poll is re-read and fn is applied again until updatePollTimeout. It's safe to run several bot instances
+func (r *Repository) updatePoll(getPoll func() (*domain.Poll, error), fn func(poll *domain.Poll) error, write func(poll *domain.Poll, version int64) error) (*domain.Poll, error) {
+	deadline := time.Now().Add(updatePollTimeout)
+	for attempt := 1; ; attempt++ {
+		poll, err := getPoll()
+		if err != nil {
+			return nil, errors.Wrap(err, "get poll failed")
+		}
 
-	result, err := r.db.GetPollByOwner(strings.TrimSpace(pollName), owner)
-	if err != nil {
-		return err
-	}
+		version := poll.Version
+		if err := fn(poll); err != nil {
+			return nil, err
+		}
 
-	if len(result.Items) == 0 {
-		return ErrPollIsNotFound
+		err = write(poll, version)
+		switch {
+		case err == nil:
+			return poll, nil
+		case err == dynamo.ErrVersionConflict && time.Now().Before(deadline):
+			delay := updateRetryDelay * time.Duration(attempt)
+			if delay > maxUpdateRetryDelay {
+				delay = maxUpdateRetryDelay
+			}
+			time.Sleep(time.Duration(rand.Int63n(int64(delay))))
+		case err == dynamo.ErrItemIsNotFound:
+			return nil, ErrPollIsNotFound
+		default:
+			return nil, errors.Wrap(err, "failed to update poll in database")
+		}
 	}
+}
 
-	var poll domain.Poll
-	if err := dynamodbattribute.UnmarshalMap(result.Items[0], &poll); err != nil {
-		return err
+func (r *Repository) GetExpiredPolls(now time.Time) ([]*domain.Poll, error) {
+	result, err := r.db.GetPollsClosingBefore(now.UnixNano())
+	if err != nil {
+		return nil, errors.Wrap(err, "can't get expired polls from repository")
 	}
 
-	return r.db.UpdateItems(strings.TrimSpace(pollName), poll.CreatedAt, items)
+	return r.convertMapToPoll(result...)
 }
 
-func (r *Repository) UpdateVote(createdAt int64, item, voter string) (*domain.Poll, error) {
-	r.mu.Lock()
-	defer r.mu.Unlock()
+func (r *Repository) ClosePoll(pollID string) (*domain.Poll, error) {
+	return r.updateIsClosed(func() (*domain.Poll, error) {
+		return r.GetPollByID(pollID)
+	}, true)
+}
 
-	poll, err := r.getPollByCreatedAt(createdAt)
-	if err != nil {
-		return nil, errors.Wrap(err, "get poll failed")
-	}
-
-	// delete previous vote fo the user
-	for item, users := range poll.Votes {
-		for i, user := range users {
-			if user == voter {
-				users = append(users[:i], users[i+1:]...)
-				if len(users) > 0 {
-					poll.Votes[item] = users
-				} else {
-					delete(poll.Votes, item)
-				}
-			}
+// updateIsClosed increases the version of the poll as UpdateVote does, so a concurrent vote is re-read and rejected
+func (r *Repository) updateIsClosed(getPoll func() (*domain.Poll, error), isClosed bool) (*domain.Poll, error) {
+	return r.updatePoll(getPoll, func(poll *domain.Poll) error {
+		return setIsClosed(poll, isClosed, time.Now())
+	}, func(poll *domain.Poll, version int64) error {
+		return r.db.UpdateClosedAt(poll.Subject, poll.CreatedAt, poll.ClosedAt, poll.ClosesAt, version)
+	})
+}
+
+func (r *Repository) AddInlineMessage(pollID, inlineMessageID string) error {
+	_, err := r.updatePoll(func() (*domain.Poll, error) {
+		return r.GetPollByID(pollID)
+	}, func(poll *domain.Poll) error {
+		if !poll.AddInlineMessage(inlineMessageID) {
+			return errInlineMessageIsKnown
 		}
+
+		return nil
+	}, func(poll *domain.Poll, version int64) error {
+		return r.db.UpdateInlineMessages(poll.Subject, poll.CreatedAt, poll.InlineMessageIDs, version)
+	})
+	if err == errInlineMessageIsKnown {
+		return nil
 	}
 
-	// add user to vote item
-	if poll.Votes == nil {
-		poll.Votes = make(map[string][]string)
+	return err
+}
+
+// setIsClosed closes or reopens the poll, a poll with the passed close time is treated as closed
+func setIsClosed(poll *domain.Poll, isClosed bool, now time.Time) error {
+	switch {
+	case isClosed && poll.ClosedAt != 0:
+		return ErrPollIsClosed
+	case isClosed:
+		poll.Close(now)
+	case !poll.IsClosed(now):
+		return ErrPollIsOpen
+	default:
+		poll.Reopen(now)
 	}
 
-	if _, ok := poll.Votes[item]; !ok {
-		poll.Votes[item] = []string{voter}
-	} else {
-		poll.Votes[item] = append(poll.Votes[item], voter)
+	return nil
+}
+
+// ownerPolls filters the polls for the storages which can't query by the owner, the newest first
+func ownerPolls(polls []*domain.Poll, owner string) []*domain.Poll {
+	var result []*domain.Poll
+	for _, poll := range polls {
+		if poll.CreatedBy == owner {
+			result = append(result, poll)
+		}
+	}
+
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].CreatedAt > result[j].CreatedAt
+	})
+
+	return result
+}
+
+// expiredPolls filters the polls for the storages which can't query by the close time
+func expiredPolls(polls []*domain.Poll, now time.Time) []*domain.Poll {
+	var result []*domain.Poll
+	for _, poll := range polls {
+		if poll.ClosedAt == 0 && poll.IsClosed(now) {
+			result = append(result, poll)
+		}
 	}
 
-	voteAttributes, err := dynamodbattribute.MarshalMap(poll.Votes)
+	return result
+}
+
+func (r *Repository) GetDraft(key string) ([]byte, error) {
+	data, err := r.db.GetDraft(key, time.Now())
 	if err != nil {
-		return nil, errors.Wrap(err, "failed to marshal votes")
+		return nil, errors.Wrap(err, "failed to get a draft")
 	}
 
-	if err := r.db.UpdateVotes(poll.Subject, poll.CreatedAt, voteAttributes); err != nil {
-		return nil, errors.Wrap(err, "failed to update vote in database")
+	if data == nil {
+		return nil, ErrDraftIsNotFound
 	}
 
-	return poll, nil
+	return data, nil
+}
+
+func (r *Repository) SaveDraft(key string, data []byte, expiresAt time.Time) error {
+	return r.db.SaveDraft(key, data, expiresAt)
 }
 
-func (r Repository) convertMapToPoll(items ...map[string]*dynamodb.AttributeValue) ([]*domain.Poll, error) {
+func (r *Repository) DeleteDraft(key string) error {
+	return r.db.DeleteDraft(key)
+}
+
+func (r *Repository) DeleteExpiredDrafts(now time.Time) (map[string][]byte, error) {
+	return r.db.DeleteExpiredDrafts(now)
+}
+
+func (r *Repository) convertMapToPoll(items ...map[string]*dynamodb.AttributeValue) ([]*domain.Poll, error) {
 	polls := make([]*domain.Poll, len(items))
 
 	for i, item := range items {
@@ -233,26 +426,51 @@ func (r Repository) convertMapToPoll(items ...map[string]*dynamodb.AttributeValu
 	return polls, nil
 }
 
-func (r Repository) getPollByCreatedAt(createdAt int64) (*domain.Poll, error) {
-	items, err := r.db.GetPollByCreatedAt(createdAt)
+func (r *Repository) GetPollByID(id string) (*domain.Poll, error) {
+	item, err := r.db.GetPollByID(id)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to get a poll by id")
+	}
+
+	return r.convertItemToPoll(item)
+}
+
+func (r *Repository) GetPollByCreatedAt(createdAt int64) (*domain.Poll, error) {
+	item, err := r.db.GetPollByCreatedAt(createdAt)
 	if err != nil {
 		return nil, errors.Wrap(err, "failed to get a poll by created_at field")
 	}
 
-	log.Printf("ITEM: %+v", items)
-	if items == nil || items.Items == nil || len(items.Items) == 0 {
+	return r.convertItemToPoll(item)
+}
+
+func (r *Repository) convertItemToPoll(item map[string]*dynamodb.AttributeValue) (*domain.Poll, error) {
+	if len(item) == 0 {
 		return nil, ErrPollIsNotFound
 	}
 
 	poll := new(domain.Poll)
-	if err := dynamodbattribute.UnmarshalMap(items.Items[0], poll); err != nil {
+	if err := dynamodbattribute.UnmarshalMap(item, poll); err != nil {
 		return nil, errors.Wrap(err, "failed to unmarshal items")
 	}
 
 	return poll, nil
 }
 
-func (r Repository) getPoll(pollName string) (*domain.Poll, error) {
+func (r *Repository) getPollByOwner(pollName, owner string) (*domain.Poll, error) {
+	result, err := r.db.GetPollByOwner(pollName, owner)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(result.Items) == 0 {
+		return nil, ErrPollIsNotFound
+	}
+
+	return r.convertItemToPoll(result.Items[0])
+}
+
+func (r *Repository) getPoll(pollName string) (*domain.Poll, error) {
 	item, err := r.db.GetPoll(pollName)
 	if err != nil {
 		return nil, errors.Wrap(err, "failed to get a poll by name")
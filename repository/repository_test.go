@@ -1,147 +1,341 @@
 package repository
 
 import (
+	"fmt"
+	"strconv"
+	"sync"
 	"testing"
+	"time"
+
+	"github.com/incu6us/vote-bot/domain"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
-func TestRepository(t *testing.T) {
-	// awsCfg := aws.NewConfig().WithRegion(region).WithCredentials(credentials.NewEnvCredentials())
-	//
-	// sess, err := session.NewSession(awsCfg)
-	// if err != nil {
-	// 	log.Printf("create session failed: %s", err)
-	// 	return
-	// }
-	//
-	// ddb := dynamodb.New(sess)
-
-	// result, err := ddb.ListTables(&dynamodb.ListTablesInput{})
-	// if err != nil {
-	// 	log.Printf("get list error: %s", err)
-	// }
-	//
-	// log.Printf("result:%+v", result)
-
-	// result1, err := ddb.DescribeTable(&dynamodb.DescribeTableInput{TableName: aws.String(tableName)})
-	// if err != nil {
-	// 	log.Printf("ddb error: %s", err)
-	// }
-	//
-	// log.Printf("result: %+v", result1)
-	//
-	// result, err := ddb.GetItem(&dynamodb.GetItemInput{
-	// 	TableName: aws.String(tableName),
-	// 	Key: map[string]*dynamodb.AttributeValue{
-	// 		"subject": {
-	// 			S: aws.String(""),
-	// 		},
-	// 	},
-	// })
-	// if err != nil {
-	// 	log.Printf("get data error: %s", err)
-	// }
-	//
-	// log.Printf("data: %+v", result)
-
-	// result, err := ddb.Query(&dynamodb.QueryInput{
-	// 	TableName:        aws.String(tableName),
-	// 	ScanIndexForward: aws.Bool(false),
-	// 	Limit:            aws.Int64(10),
-	// 	KeyConditions: map[string]*dynamodb.Condition{
-	// 		"kind": {
-	// 			ComparisonOperator: aws.String("EQ"),
-	// 			AttributeValueList: []*dynamodb.AttributeValue{
-	// 				{
-	// 					S: aws.String("vote"),
-	// 				},
-	// 			},
-	// 		},
-	// 		"created_at": {
-	// 			ComparisonOperator: aws.String("LE"),
-	// 			AttributeValueList: []*dynamodb.AttributeValue{
-	// 				{
-	// 					N: aws.String(strconv.FormatInt(time.Now().UnixNano(), 10)),
-	// 				},
-	// 			},
-	// 		},
-	// 	},
-	// })
-	// if err != nil {
-	// 	log.Printf("query database error: %s", err)
-	// 	return
-	// }
-
-	// result, err := ddb.Scan(&dynamodb.ScanInput{
-	// 	TableName: aws.String(tableName),
-	// 	Limit:     aws.Int64(pageLimit),
-	// })
-	// if err != nil {
-	// 	log.Printf("list tables error: %s", err)
-	// 	return
-	// }
-	// log.Printf("DATA: %+v", result)
-
-	// repo, err := repository.New(region, tableName)
-	// if err != nil {
-	// 	log.Printf("failed to initiate repository: %s", err)
-	// 	return
-	// }
-	//
-	// _, err = repo.DescribeTable()
-	// if err != nil {
-	// 	if awsErr, ok := errors.Cause(err).(awserr.Error); ok {
-	// 		switch awsErr.Code() {
-	// 		case dynamodb.ErrCodeResourceNotFoundException:
-	// 			if err1 := repo.CreateTable(); err != nil {
-	// 				log.Printf("create table error: %s", err1)
-	// 			}
-	// 			log.Println("table created")
-	// 		}
-	// 	} else {
-	// 		log.Println(err)
-	// 		return
-	// 	}
-	// }
-
-	// desc, _ := repo.DescribeTable()
-	//
-	// log.Printf("desc: %+v", desc)
-
-	// polls, err := repo.GetPolls()
-	// if err != nil {
-	// 	log.Printf("can't get polls: %s", err)
-	// 	return
-	// }
-	// //
-	// for _, poll := range polls {
-	// 	log.Printf("data: %+v", *poll)
-	// }
-
-	// err = repo.CreatePoll("test", "me", []string{"1", "2"})
-	// if err != nil {
-	// 	log.Printf("poll created failed: %s", err)
-	// 	return
-	// }
-	// log.Println("poll created")
-
-	// err = repo.DeletePoll("test", "me")
-	// if err != nil {
-	// 	log.Printf("poll delete failed: %s", err)
-	// 	return
-	// }
-	// log.Println("poll deleted")
-
-	// err = repo.UpdatePollIsPublished("test1", "me", true)
-	// if err != nil {
-	// 	log.Printf("poll update failed: %s", err)
-	// 	return
-	// }
-	// log.Println("poll updated")
-
-	// err = repo.UpdatePollItems("test1", "me", []string{"qw", "rt"})
-	// if err != nil {
-	// 	log.Printf("poll update failed: %s", err)
-	// 	return
-	// }
-	// log.Println("poll updated")
+// testStorage runs the same behaviour checks against every Storage implementation
+func testStorage(t *testing.T, newStorage func(t *testing.T) (Storage, func())) {
+	tests := []struct {
+		name string
+		test func(t *testing.T, repo Storage)
+	}{
+		{name: "create poll", test: testStorageCreatePoll},
+		{name: "create anonymous poll", test: testStorageCreateAnonymousPoll},
+		{name: "get poll begins with", test: testStorageGetPollBeginsWith},
+		{name: "get polls by owner", test: testStorageGetPollsByOwner},
+		{name: "update vote", test: testStorageUpdateVote},
+		{name: "concurrent votes", test: testStorageConcurrentVotes},
+		{name: "close poll", test: testStorageClosePoll},
+		{name: "close and reopen poll", test: testStorageUpdatePollIsClosed},
+		{name: "add inline message", test: testStorageAddInlineMessage},
+		{name: "update poll", test: testStorageUpdatePoll},
+		{name: "delete poll", test: testStorageDeletePoll},
+		{name: "drafts", test: testStorageDrafts},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			repo, cleanup := newStorage(t)
+			defer cleanup()
+
+			tt.test(t, repo)
+		})
+	}
+}
+
+func testStorageCreatePoll(t *testing.T, repo Storage) {
+	require.NoError(t, repo.CreatePoll(&domain.Poll{Subject: " test poll ", CreatedBy: "me", Items: []string{"1", "2"}}))
+	assert.Equal(t, ErrPollAlreadyExist, errors.Cause(repo.CreatePoll(&domain.Poll{Subject: "test poll", CreatedBy: "me", Items: []string{"3"}})))
+
+	poll, err := repo.GetPoll("test poll")
+	require.NoError(t, err)
+	assert.Equal(t, "test poll", poll.Subject)
+	assert.Equal(t, []string{"1", "2"}, poll.Items)
+	assert.Equal(t, "me", poll.CreatedBy)
+	assert.NotZero(t, poll.CreatedAt)
+	assert.NotEmpty(t, poll.ID)
+
+	byID, err := repo.GetPollByID(poll.ID)
+	require.NoError(t, err)
+	assert.Equal(t, poll.Subject, byID.Subject)
+
+	byCreatedAt, err := repo.GetPollByCreatedAt(poll.CreatedAt)
+	require.NoError(t, err)
+	assert.Equal(t, poll.ID, byCreatedAt.ID)
+
+	_, err = repo.GetPoll("unknown")
+	assert.Equal(t, ErrPollIsNotFound, errors.Cause(err))
+}
+
+func testStorageGetPollsByOwner(t *testing.T, repo Storage) {
+	for _, poll := range []*domain.Poll{
+		{Subject: "old", CreatedBy: "me", Items: []string{"1"}},
+		{Subject: "other", CreatedBy: "someone else", Items: []string{"1"}},
+		{Subject: "new", CreatedBy: "me", Items: []string{"1"}},
+	} {
+		require.NoError(t, repo.CreatePoll(poll))
+	}
+
+	polls, err := repo.GetPollsByOwner("me")
+	require.NoError(t, err)
+	require.Len(t, polls, 2)
+	assert.Equal(t, "new", polls[0].Subject)
+	assert.Equal(t, "old", polls[1].Subject)
+
+	polls, err = repo.GetPollsByOwner("nobody")
+	require.NoError(t, err)
+	assert.Empty(t, polls)
+}
+
+func testStorageCreateAnonymousPoll(t *testing.T, repo Storage) {
+	require.NoError(t, repo.CreatePoll(&domain.Poll{Subject: "public", CreatedBy: "me", Items: []string{"1"}}))
+	require.NoError(t, repo.CreatePoll(&domain.Poll{Subject: "secret", CreatedBy: "me", Items: []string{"1"}, Anonymous: true}))
+
+	public, err := repo.GetPoll("public")
+	require.NoError(t, err)
+	assert.False(t, public.Anonymous)
+	assert.Empty(t, public.Salt)
+
+	secret, err := repo.GetPoll("secret")
+	require.NoError(t, err)
+	assert.True(t, secret.Anonymous)
+	assert.NotEmpty(t, secret.Salt)
+}
+
+func testStorageGetPollBeginsWith(t *testing.T, repo Storage) {
+	require.NoError(t, repo.CreatePoll(&domain.Poll{Subject: "lunch today", CreatedBy: "me", Items: []string{"pizza"}}))
+	require.NoError(t, repo.CreatePoll(&domain.Poll{Subject: "Dinner", CreatedBy: "me", Items: []string{"soup"}}))
+	// it goes before "lunch today" by the subject, but after it by the search key
+	require.NoError(t, repo.CreatePoll(&domain.Poll{Subject: "Lunch tomorrow", CreatedBy: "me", Items: []string{"sushi"}}))
+
+	tests := []struct {
+		name    string
+		prefix  string
+		want    string
+		wantErr error
+	}{
+		{name: "found", prefix: "lunch", want: "lunch today"},
+		{name: "full name", prefix: "dinner", want: "Dinner"},
+		{name: "case insensitive", prefix: "LUNCH T", want: "lunch today"},
+		{name: "first by search key", prefix: "lunch to", want: "lunch today"},
+		{name: "next by search key", prefix: "lunch tom", want: "Lunch tomorrow"},
+		{name: "not found", prefix: "breakfast", wantErr: ErrPollIsNotFound},
+		{name: "empty prefix", prefix: "", wantErr: ErrBadPollName},
+		{name: "blank prefix", prefix: "  ", wantErr: ErrBadPollName},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			poll, err := repo.GetPollBeginsWith(tt.prefix)
+			if tt.wantErr != nil {
+				assert.Equal(t, tt.wantErr, errors.Cause(err))
+				return
+			}
+
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, poll.Subject)
+		})
+	}
+}
+
+func testStorageUpdateVote(t *testing.T, repo Storage) {
+	require.NoError(t, repo.CreatePoll(&domain.Poll{Subject: "test", CreatedBy: "me", Items: []string{"1", "2"}}))
+	poll, err := repo.GetPoll("test")
+	require.NoError(t, err)
+
+	_, err = repo.UpdateVote(poll.ID, "1", "alice")
+	require.NoError(t, err)
+	_, err = repo.UpdateVote(poll.ID, "1", "bob")
+	require.NoError(t, err)
+	poll, err = repo.UpdateVote(poll.ID, "2", "alice")
+	require.NoError(t, err)
+
+	assert.Equal(t, map[string][]string{"1": {"bob"}, "2": {"alice"}}, poll.Votes)
+
+	stored, err := repo.GetPoll("test")
+	require.NoError(t, err)
+	assert.Equal(t, poll.Votes, stored.Votes)
+
+	_, err = repo.UpdateVote("unknown", "1", "alice")
+	assert.Equal(t, ErrPollIsNotFound, errors.Cause(err))
+}
+
+func testStorageConcurrentVotes(t *testing.T, repo Storage) {
+	const voters = 20
+
+	require.NoError(t, repo.CreatePoll(&domain.Poll{Subject: "test", CreatedBy: "me", Items: []string{"1", "2"}}))
+	poll, err := repo.GetPoll("test")
+	require.NoError(t, err)
+
+	var wg sync.WaitGroup
+	errCh := make(chan error, voters)
+	for i := 0; i < voters; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			_, err := repo.UpdateVote(poll.ID, strconv.Itoa(i%2+1), "voter"+strconv.Itoa(i))
+			errCh <- err
+		}(i)
+	}
+	wg.Wait()
+	close(errCh)
+
+	for err := range errCh {
+		require.NoError(t, err)
+	}
+
+	poll, err = repo.GetPoll("test")
+	require.NoError(t, err)
+	assert.Len(t, poll.Votes["1"], voters/2)
+	assert.Len(t, poll.Votes["2"], voters/2)
+	assert.EqualValues(t, voters, poll.Version)
+}
+
+func testStorageUpdatePoll(t *testing.T, repo Storage) {
+	require.NoError(t, repo.CreatePoll(&domain.Poll{Subject: "test", CreatedBy: "me", Items: []string{"1", "2"}}))
+
+	poll, err := repo.GetPoll("test")
+	require.NoError(t, err)
+	_, err = repo.UpdateVote(poll.ID, "1", "alice")
+	require.NoError(t, err)
+	_, err = repo.UpdateVote(poll.ID, "2", "bob")
+	require.NoError(t, err)
+
+	updated, err := repo.UpdatePollItems("test", "me", []string{"one", "3"}, map[string]string{"1": "one"})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"one", "3"}, updated.Items)
+	_, err = repo.UpdatePollItems("test", "someone else", []string{"6"}, nil)
+	assert.Equal(t, ErrPollIsNotFound, errors.Cause(err))
+
+	poll, err = repo.GetPoll("test")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"one", "3"}, poll.Items)
+	assert.Equal(t, map[string][]string{"one": {"alice"}}, poll.Votes)
+	assert.Equal(t, updated.Version, poll.Version)
+}
+
+func testStorageDeletePoll(t *testing.T, repo Storage) {
+	require.NoError(t, repo.CreatePoll(&domain.Poll{Subject: "test", CreatedBy: "me", Items: []string{"1"}}))
+
+	assert.Equal(t, ErrPollIsNotFound, errors.Cause(repo.DeletePoll("test", "someone else")))
+	require.NoError(t, repo.DeletePoll("test", "me"))
+
+	polls, err := repo.GetPolls()
+	require.NoError(t, err)
+	assert.Empty(t, polls)
+}
+
+func testStorageClosePoll(t *testing.T, repo Storage) {
+	now := time.Now()
+	require.NoError(t, repo.CreatePoll(&domain.Poll{Subject: "expired", CreatedBy: "me", Items: []string{"1"}, ClosesAt: now.Add(-time.Minute).UnixNano()}))
+	require.NoError(t, repo.CreatePoll(&domain.Poll{Subject: "running", CreatedBy: "me", Items: []string{"1"}, ClosesAt: now.Add(time.Hour).UnixNano()}))
+	require.NoError(t, repo.CreatePoll(&domain.Poll{Subject: "endless", CreatedBy: "me", Items: []string{"1"}}))
+
+	expired, err := repo.GetExpiredPolls(now)
+	require.NoError(t, err)
+	require.Len(t, expired, 1)
+	assert.Equal(t, "expired", expired[0].Subject)
+
+	_, err = repo.UpdateVote(expired[0].ID, "1", "alice")
+	assert.Equal(t, ErrPollIsClosed, errors.Cause(err))
+
+	closed, err := repo.ClosePoll(expired[0].ID)
+	require.NoError(t, err)
+	assert.NotZero(t, closed.ClosedAt)
+
+	_, err = repo.ClosePoll(expired[0].ID)
+	assert.Equal(t, ErrPollIsClosed, errors.Cause(err))
+
+	expired, err = repo.GetExpiredPolls(now)
+	require.NoError(t, err)
+	assert.Empty(t, expired)
+
+	endless, err := repo.GetPoll("endless")
+	require.NoError(t, err)
+	_, err = repo.ClosePoll(endless.ID)
+	require.NoError(t, err)
+	_, err = repo.UpdateVote(endless.ID, "1", "alice")
+	assert.Equal(t, ErrPollIsClosed, errors.Cause(err))
+}
+
+func testStorageUpdatePollIsClosed(t *testing.T, repo Storage) {
+	require.NoError(t, repo.CreatePoll(&domain.Poll{Subject: "test", CreatedBy: "me", Items: []string{"1"}}))
+	require.NoError(t, repo.CreatePoll(&domain.Poll{Subject: "expired", CreatedBy: "me", Items: []string{"1"}, ClosesAt: time.Now().Add(-time.Minute).UnixNano()}))
+
+	_, err := repo.UpdatePollIsClosed("test", "someone else", true)
+	assert.Equal(t, ErrPollIsNotFound, errors.Cause(err))
+	_, err = repo.UpdatePollIsClosed("test", "me", false)
+	assert.Equal(t, ErrPollIsOpen, errors.Cause(err))
+
+	closed, err := repo.UpdatePollIsClosed(" test ", "me", true)
+	require.NoError(t, err)
+	assert.NotZero(t, closed.ClosedAt)
+	_, err = repo.UpdateVote(closed.ID, "1", "alice")
+	assert.Equal(t, ErrPollIsClosed, errors.Cause(err))
+	_, err = repo.UpdatePollIsClosed("test", "me", true)
+	assert.Equal(t, ErrPollIsClosed, errors.Cause(err))
+
+	reopened, err := repo.UpdatePollIsClosed("test", "me", false)
+	require.NoError(t, err)
+	assert.Zero(t, reopened.ClosedAt)
+	_, err = repo.UpdateVote(closed.ID, "1", "alice")
+	require.NoError(t, err)
+
+	// the passed close time is dropped on reopening
+	reopened, err = repo.UpdatePollIsClosed("expired", "me", false)
+	require.NoError(t, err)
+	assert.Zero(t, reopened.ClosesAt)
+	stored, err := repo.GetPoll("expired")
+	require.NoError(t, err)
+	assert.False(t, stored.IsClosed(time.Now()))
+}
+
+func testStorageAddInlineMessage(t *testing.T, repo Storage) {
+	require.NoError(t, repo.CreatePoll(&domain.Poll{Subject: "test", CreatedBy: "me", Items: []string{"1"}}))
+	poll, err := repo.GetPoll("test")
+	require.NoError(t, err)
+
+	require.NoError(t, repo.AddInlineMessage(poll.ID, "msg-1"))
+	require.NoError(t, repo.AddInlineMessage(poll.ID, "msg-2"))
+	require.NoError(t, repo.AddInlineMessage(poll.ID, "msg-1"))
+
+	poll, err = repo.GetPollByID(poll.ID)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"msg-1", "msg-2"}, poll.InlineMessageIDs)
+
+	for i := 3; i <= domain.MaxInlineMessages+1; i++ {
+		require.NoError(t, repo.AddInlineMessage(poll.ID, fmt.Sprintf("msg-%d", i)))
+	}
+	poll, err = repo.GetPollByID(poll.ID)
+	require.NoError(t, err)
+	assert.Len(t, poll.InlineMessageIDs, domain.MaxInlineMessages)
+	assert.Equal(t, "msg-2", poll.InlineMessageIDs[0])
+
+	assert.Equal(t, ErrPollIsNotFound, errors.Cause(repo.AddInlineMessage("unknown", "msg-1")))
+}
+
+func testStorageDrafts(t *testing.T, repo Storage) {
+	now := time.Now()
+	require.NoError(t, repo.SaveDraft("active", []byte("first"), now.Add(time.Hour)))
+	require.NoError(t, repo.SaveDraft("active", []byte("second"), now.Add(time.Hour)))
+	require.NoError(t, repo.SaveDraft("expired", []byte("data"), now.Add(-2*time.Second)))
+
+	data, err := repo.GetDraft("active")
+	require.NoError(t, err)
+	assert.Equal(t, []byte("second"), data)
+
+	_, err = repo.GetDraft("expired")
+	assert.Equal(t, ErrDraftIsNotFound, errors.Cause(err))
+	_, err = repo.GetDraft("unknown")
+	assert.Equal(t, ErrDraftIsNotFound, errors.Cause(err))
+
+	drafts, err := repo.DeleteExpiredDrafts(now)
+	require.NoError(t, err)
+	assert.Equal(t, map[string][]byte{"expired": []byte("data")}, drafts)
+
+	drafts, err = repo.DeleteExpiredDrafts(now)
+	require.NoError(t, err)
+	assert.Empty(t, drafts)
+
+	require.NoError(t, repo.DeleteDraft("active"))
+	_, err = repo.GetDraft("active")
+	assert.Equal(t, ErrDraftIsNotFound, errors.Cause(err))
 }
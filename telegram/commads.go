@@ -2,14 +2,24 @@ package telegram
 
 import (
 	"fmt"
+	"strings"
+	"time"
 
 	tgbot "github.com/go-telegram-bot-api/telegram-bot-api"
+	"github.com/incu6us/vote-bot/domain"
+	"github.com/incu6us/vote-bot/repository"
 	"github.com/incu6us/vote-bot/telegram/models"
 	"github.com/pkg/errors"
 )
 
 const (
 	sendMessageErrorString = "send message error"
+
+	anonymousDisabledText = "Anonymous polls are disabled, the bot is started without the anonymous secret"
+
+	draftExpiredText = "The poll you were creating or editing has expired. Send /newpoll or /editpoll to start again"
+	draftEvictedText = "Too many polls are being created now, so the poll you were creating or editing was dropped. " +
+		"Send /newpoll or /editpoll to start again"
 )
 
 func (c Client) cmdHelp(chatID int64) error {
@@ -43,6 +53,10 @@ func (c *Client) cmdDone(chatID int64, userID int) error {
 		return nil
 	}
 
+	if poll.EditPollID != "" {
+		return c.saveEditedPoll(chatID, userID, poll)
+	}
+
 	if poll.PollName == "" || len(poll.Items) == 0 {
 		c.pollsStore.Delete(models.UserID(userID))
 		if _, err := c.bot.Send(tgbot.NewMessage(chatID, "Poll name and items should be set. Try again to create a new poll")); err != nil {
@@ -52,7 +66,24 @@ func (c *Client) cmdDone(chatID int64, userID int) error {
 		return nil
 	}
 
-	if err := c.store.CreatePoll(poll.PollName, poll.Owner, poll.Items); err != nil {
+	// the draft could be made before the secret was removed
+	if poll.Anonymous && len(c.anonymousSecret) == 0 {
+		c.pollsStore.Delete(models.UserID(userID))
+		if _, err := c.bot.Send(tgbot.NewMessage(chatID, anonymousDisabledText+". Try again to create a new poll")); err != nil {
+			return errors.Wrap(err, sendMessageErrorString)
+		}
+
+		return nil
+	}
+
+	if err := c.store.CreatePoll(&domain.Poll{
+		Subject:        poll.PollName,
+		CreatedBy:      poll.Owner,
+		Items:          poll.Items,
+		MultipleChoice: poll.MultipleChoice,
+		Anonymous:      poll.Anonymous,
+		ClosesAt:       poll.ClosesAt,
+	}); err != nil {
 		c.pollsStore.Delete(models.UserID(userID))
 		if _, err := c.bot.Send(tgbot.NewMessage(chatID, fmt.Sprintf("Poll creation error: %s", err))); err != nil {
 			return errors.Wrap(err, sendMessageErrorString)
@@ -82,9 +113,193 @@ func (c *Client) cmdDone(chatID int64, userID int) error {
 	return nil
 }
 
+// cmdMultiple switches the poll under creation between single and multiple choice
+func (c *Client) cmdMultiple(chatID int64, userID int) error {
+	poll := c.creationDraft(userID)
+	if poll == nil {
+		if _, err := c.bot.Send(tgbot.NewMessage(chatID, "No such poll")); err != nil {
+			return errors.Wrap(err, sendMessageErrorString)
+		}
+
+		return nil
+	}
+
+	poll.MultipleChoice = !poll.MultipleChoice
+	c.pollsStore.Store(models.UserID(userID), poll)
+
+	text := "Voters could choose only one item"
+	if poll.MultipleChoice {
+		text = "Voters could choose several items"
+	}
+	if _, err := c.bot.Send(tgbot.NewMessage(chatID, text)); err != nil {
+		return errors.Wrap(err, sendMessageErrorString)
+	}
+
+	return nil
+}
+
+// cmdAnonymous switches the poll under creation between public and anonymous voting
+func (c *Client) cmdAnonymous(chatID int64, userID int) error {
+	if len(c.anonymousSecret) == 0 {
+		if _, err := c.bot.Send(tgbot.NewMessage(chatID, anonymousDisabledText)); err != nil {
+			return errors.Wrap(err, sendMessageErrorString)
+		}
+
+		return nil
+	}
+
+	poll := c.creationDraft(userID)
+	if poll == nil {
+		if _, err := c.bot.Send(tgbot.NewMessage(chatID, "No such poll")); err != nil {
+			return errors.Wrap(err, sendMessageErrorString)
+		}
+
+		return nil
+	}
+
+	poll.Anonymous = !poll.Anonymous
+	c.pollsStore.Store(models.UserID(userID), poll)
+
+	text := "Voters will be shown under the items"
+	if poll.Anonymous {
+		text = "Voters will be hidden, only the numbers of votes will be shown"
+	}
+	if _, err := c.bot.Send(tgbot.NewMessage(chatID, text)); err != nil {
+		return errors.Wrap(err, sendMessageErrorString)
+	}
+
+	return nil
+}
+
+// cmdDeadline sets the close time of the poll under creation, an empty value removes it
+func (c *Client) cmdDeadline(chatID int64, userID int, value string) error {
+	poll := c.creationDraft(userID)
+	if poll == nil {
+		if _, err := c.bot.Send(tgbot.NewMessage(chatID, "No such poll")); err != nil {
+			return errors.Wrap(err, sendMessageErrorString)
+		}
+
+		return nil
+	}
+
+	var text string
+	if value == "" {
+		poll.ClosesAt = 0
+		text = "The poll will be open until it's closed"
+	} else {
+		closeTime, err := parseCloseTime(value, time.Now())
+		if err != nil {
+			msg := tgbot.NewMessage(chatID, fmt.Sprintf("Bad close time: %s. Use a duration like `in 2h`, `90m`, `3d` or a date like `2020-01-02 15:04` (UTC)", err))
+			msg.ParseMode = string(parseMode)
+			if _, err := c.bot.Send(msg); err != nil {
+				return errors.Wrap(err, sendMessageErrorString)
+			}
+
+			return nil
+		}
+
+		poll.ClosesAt = closeTime.UnixNano()
+		text = fmt.Sprintf("The poll will be closed at %s", formatCloseTime(poll.ClosesAt))
+	}
+
+	c.pollsStore.Store(models.UserID(userID), poll)
+	if _, err := c.bot.Send(tgbot.NewMessage(chatID, text)); err != nil {
+		return errors.Wrap(err, sendMessageErrorString)
+	}
+
+	return nil
+}
+
+// cmdClose closes or reopens the poll of the user and re-renders its messages
+func (c *Client) cmdClose(chatID int64, userID int, fullUserName, pollName string, isClosed bool) error {
+	command := "/reopen"
+	if isClosed {
+		command = "/close"
+	}
+
+	if strings.TrimSpace(pollName) == "" {
+		if _, err := c.bot.Send(tgbot.NewMessage(chatID, fmt.Sprintf("Usage: %s <poll name>", command))); err != nil {
+			return errors.Wrap(err, sendMessageErrorString)
+		}
+
+		return nil
+	}
+
+	var text string
+	poll, err := c.store.UpdatePollIsClosed(pollName, getOwner(userID, fullUserName), isClosed)
+	switch errors.Cause(err) {
+	case nil:
+		text = "The poll is reopened"
+		if isClosed {
+			text = "The poll is closed"
+		}
+		c.updatePollCh <- pollUpdate(poll, "")
+	case repository.ErrPollIsNotFound:
+		text = "No such poll"
+	case repository.ErrPollIsClosed:
+		text = "The poll is closed already"
+	case repository.ErrPollIsOpen:
+		text = "The poll is open already"
+	default:
+		return errors.Wrapf(err, "command %s failed", command)
+	}
+
+	if _, err := c.bot.Send(tgbot.NewMessage(chatID, text)); err != nil {
+		return errors.Wrap(err, sendMessageErrorString)
+	}
+
+	return nil
+}
+
+// cmdMyPolls sends the first page of the polls created by the user, the list isn't shown in the group chats,
+// where the other users could use its buttons
+func (c *Client) cmdMyPolls(chat *tgbot.Chat, userID int, fullUserName string) error {
+	if !chat.IsPrivate() {
+		_, err := c.bot.Send(tgbot.NewMessage(chat.ID, privateChatOnlyText))
+		return errors.Wrap(err, sendMessageErrorString)
+	}
+
+	return c.showMyPolls(chat.ID, 0, getOwner(userID, fullUserName), 0)
+}
+
+// cmdDeletePoll asks the owner to confirm the deletion of the poll, the confirmation is answered only in the private chat
+func (c *Client) cmdDeletePoll(chat *tgbot.Chat, userID int, fullUserName, pollName string) error {
+	chatID := chat.ID
+	if !chat.IsPrivate() {
+		_, err := c.bot.Send(tgbot.NewMessage(chatID, privateChatOnlyText))
+		return errors.Wrap(err, sendMessageErrorString)
+	}
+
+	if strings.TrimSpace(pollName) == "" {
+		if _, err := c.bot.Send(tgbot.NewMessage(chatID, "Usage: /deletepoll <poll name>")); err != nil {
+			return errors.Wrap(err, sendMessageErrorString)
+		}
+
+		return nil
+	}
+
+	poll, err := c.store.GetPoll(pollName)
+	if err != nil && errors.Cause(err) != repository.ErrPollIsNotFound {
+		return errors.Wrap(err, "get poll failed")
+	}
+
+	if poll == nil || poll.CreatedBy != getOwner(userID, fullUserName) {
+		if _, err := c.bot.Send(tgbot.NewMessage(chatID, "No such poll")); err != nil {
+			return errors.Wrap(err, sendMessageErrorString)
+		}
+
+		return nil
+	}
+
+	text, markup := deleteConfirmationMessage(poll, noListPage, c.callbackSecret)
+
+	return c.sendOrEdit(chatID, 0, text, markup)
+}
+
 func (c *Client) cmdNewPoll(chatID int64, userID int, fullUserName string) error {
-	if prestoredPoll := c.pollsStore.Load(models.UserID(userID)); prestoredPoll == nil {
-		c.pollsStore.Store(models.UserID(userID), &models.Poll{Owner: getOwner(userID, fullUserName)})
+	// a started edit of a poll is abandoned
+	if prestoredPoll := c.creationDraft(userID); prestoredPoll == nil {
+		c.pollsStore.Store(models.UserID(userID), &models.Poll{Owner: getOwner(userID, fullUserName), ChatID: chatID})
 	}
 	msg := tgbot.NewMessage(chatID, "Enter a poll name")
 	if _, err := c.bot.Send(msg); err != nil {
@@ -93,3 +308,109 @@ func (c *Client) cmdNewPoll(chatID int64, userID int, fullUserName string) error
 
 	return nil
 }
+
+// creationDraft returns the poll which is being created by the user, nil if the user edits a poll
+func (c *Client) creationDraft(userID int) *models.Poll {
+	poll := c.pollsStore.Load(models.UserID(userID))
+	if poll == nil || poll.EditPollID != "" {
+		return nil
+	}
+
+	return poll
+}
+
+// cmdEditPoll starts editing the items of the user's poll, the changes are saved with /done
+func (c *Client) cmdEditPoll(chatID int64, userID int, fullUserName, pollName string) error {
+	if strings.TrimSpace(pollName) == "" {
+		if _, err := c.bot.Send(tgbot.NewMessage(chatID, "Usage: /editpoll <poll name>")); err != nil {
+			return errors.Wrap(err, sendMessageErrorString)
+		}
+
+		return nil
+	}
+
+	owner := getOwner(userID, fullUserName)
+	poll, err := c.store.GetPoll(pollName)
+	if err != nil && errors.Cause(err) != repository.ErrPollIsNotFound {
+		return errors.Wrap(err, "get poll failed")
+	}
+
+	if poll == nil || poll.CreatedBy != owner {
+		if _, err := c.bot.Send(tgbot.NewMessage(chatID, "No such poll")); err != nil {
+			return errors.Wrap(err, sendMessageErrorString)
+		}
+
+		return nil
+	}
+
+	draft := &models.Poll{
+		PollName:    poll.Subject,
+		Owner:       owner,
+		Items:       append([]string(nil), poll.Items...),
+		ItemOrigins: append([]string(nil), poll.Items...),
+		EditPollID:  poll.ID,
+		ChatID:      chatID,
+	}
+	c.pollsStore.Store(models.UserID(userID), draft)
+
+	return c.sendEditPollMessage(chatID, draft, "")
+}
+
+// cmdEditItems applies "add", "remove" or "rename" to the poll which is being edited
+func (c *Client) cmdEditItems(chatID int64, userID int, command, args string) error {
+	draft := c.pollsStore.Load(models.UserID(userID))
+	if draft == nil || draft.EditPollID == "" {
+		if _, err := c.bot.Send(tgbot.NewMessage(chatID, "No poll is being edited, use /editpoll <poll name>")); err != nil {
+			return errors.Wrap(err, sendMessageErrorString)
+		}
+
+		return nil
+	}
+
+	if err := editDraftItems(draft, command, args, c.maximumAnswers); err != nil {
+		return c.sendEditPollMessage(chatID, draft, fmt.Sprintf("Can't %s the item: %s", command, err))
+	}
+
+	c.pollsStore.Store(models.UserID(userID), draft)
+
+	return c.sendEditPollMessage(chatID, draft, "")
+}
+
+func (c *Client) sendEditPollMessage(chatID int64, draft *models.Poll, note string) error {
+	text := editPollText(draft)
+	if note != "" {
+		text = escapeURLMarkdownSymbols(note) + "\n\n" + text
+	}
+
+	msg := tgbot.NewMessage(chatID, text)
+	msg.ParseMode = string(parseMode)
+	if _, err := c.bot.Send(msg); err != nil {
+		return errors.Wrap(err, sendMessageErrorString)
+	}
+
+	return nil
+}
+
+func (c *Client) saveEditedPoll(chatID int64, userID int, draft *models.Poll) error {
+	if len(draft.Items) == 0 {
+		return c.sendEditPollMessage(chatID, draft, "A poll should have at least one item")
+	}
+
+	poll, err := c.store.UpdatePollItems(draft.PollName, draft.Owner, draft.Items, keptItems(draft))
+	c.pollsStore.Delete(models.UserID(userID))
+	if err != nil {
+		if _, err := c.bot.Send(tgbot.NewMessage(chatID, fmt.Sprintf("Poll update error: %s", errors.Cause(err)))); err != nil {
+			return errors.Wrap(err, sendMessageErrorString)
+		}
+
+		return errors.Wrap(err, "update poll items failed")
+	}
+
+	c.updatePollCh <- pollUpdate(poll, "")
+
+	if _, err := c.bot.Send(tgbot.NewMessage(chatID, "The poll is updated")); err != nil {
+		return errors.Wrap(err, sendMessageErrorString)
+	}
+
+	return nil
+}
@@ -0,0 +1,98 @@
+package telegram
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	tgbot "github.com/go-telegram-bot-api/telegram-bot-api"
+	"github.com/incu6us/vote-bot/cache"
+	"github.com/incu6us/vote-bot/domain"
+	"github.com/incu6us/vote-bot/repository"
+	"github.com/incu6us/vote-bot/telegram/telegramtest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const (
+	testToken   = "123:test-token"
+	testTimeout = 5 * time.Second
+)
+
+// TestClient_EndToEnd runs the bot against the fake Bot API: the poll is created in the private chat,
+// shared into a group with the inline query and voted in the posted message
+func TestClient_EndToEnd(t *testing.T) {
+	server := telegramtest.NewServer(testToken)
+	defer server.Close()
+
+	store := repository.NewMemory()
+	client, err := New(cache.NewShared(), store, Config{
+		Token:           testToken,
+		BotName:         testBotName,
+		UserIDs:         []int{testUserID},
+		CallbackSecret:  testSecret,
+		DraftsInMemory:  true,
+		AnonymousSecret: testAnonSecret,
+		PollingTimeout:  time.Second,
+		EditDelay:       100 * time.Millisecond,
+		APIEndpoint:     server.URL(),
+	})
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	runErr := make(chan error, 1)
+	go func() { runErr <- client.Run(ctx) }()
+
+	user := tgbot.User{ID: testUserID, FirstName: "Test", LastName: "User"}
+	for _, text := range []string{"/newpoll", testPollName, testPollItem, testPollItem2, "/done"} {
+		server.SendMessage(user, text)
+	}
+
+	var poll *domain.Poll
+	require.True(t, server.Wait(testTimeout, func() bool {
+		poll, err = store.GetPoll(testPollName)
+		return err == nil
+	}), "poll isn't created")
+	assert.Equal(t, []string{testPollItem, testPollItem2}, poll.Items)
+
+	queryID := server.SendInlineQuery(user, testPollName[:5])
+	require.True(t, server.Wait(testTimeout, func() bool {
+		return len(server.InlineAnswers()) == 1
+	}), "inline query isn't answered")
+	results := server.InlineAnswers()[0].Results
+	require.Len(t, results, 1)
+	assert.Equal(t, poll.ID, results[0].ID)
+
+	inlineID, ok := server.ChooseInlineResult(user, queryID, poll.ID)
+	require.True(t, ok)
+	require.True(t, server.Wait(testTimeout, func() bool {
+		poll, err = store.GetPollByID(poll.ID)
+		return err == nil && poll.HasInlineMessage(inlineID)
+	}), "posted message isn't remembered")
+
+	require.True(t, server.PressButton(user, inlineID, testPollItem2))
+	wantText := "Lunch place\n---\nLast Vote: Test User\nVotes: \n```\n- Sushi:\n\t\t\t\tTest User\n```"
+	require.True(t, server.Wait(testTimeout, func() bool {
+		message, _ := server.InlineMessage(inlineID)
+		return message.Text == wantText
+	}), "posted message isn't updated")
+
+	assert.Equal(t, []telegramtest.CallbackAnswer{{CallbackQueryID: "2", Text: "Vote 'Sushi' accepted"}}, server.CallbackAnswers())
+	message, _ := server.InlineMessage(inlineID)
+	assert.NotNil(t, message.ReplyMarkup, "keyboard of an open poll is removed")
+
+	poll, err = store.GetPollByID(poll.ID)
+	require.NoError(t, err)
+	assert.Equal(t, []string{testOwnerName}, poll.Votes[testPollItem2])
+
+	cancel()
+	select {
+	case err := <-runErr:
+		assert.NoError(t, err)
+	case <-time.After(testTimeout):
+		t.Fatal("run doesn't return after the cancellation")
+	}
+	assert.NoError(t, client.Close())
+}
@@ -0,0 +1,356 @@
+package telegram
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	tgbot "github.com/go-telegram-bot-api/telegram-bot-api"
+	"github.com/incu6us/vote-bot/cache"
+)
+
+const (
+	// editBatchSize and editBatchInterval keep the edits under the Telegram limit of 30 requests per second
+	editBatchSize     = 20
+	editBatchInterval = time.Second
+	// maxEditAttempts limits the retries of an edit rejected with "Too Many Requests"
+	maxEditAttempts = 3
+
+	defaultEditDelay = time.Second
+	// sentEditsLimit is the number of messages the last sent edits are remembered for
+	sentEditsLimit = 10000
+	// idleWait is how long the queue sleeps without the pending edits, a new edit wakes it up earlier
+	idleWait = time.Minute
+	// sentVersionTTL is how long the poll version of a sent edit is kept to drop the older edits coming late
+	sentVersionTTL = time.Minute
+)
+
+type sendFunc func(c tgbot.Chattable) (tgbot.Message, error)
+
+type pendingEdit struct {
+	edit tgbot.EditMessageTextConfig
+	// version is the version of the poll the edit shows
+	version  int64
+	due      time.Time
+	attempts int
+}
+
+type sentVersion struct {
+	version int64
+	at      time.Time
+}
+
+// editQueue merges the edits of an inline message made within the delay into one, so a burst of votes
+// makes a single request. The workers could finish the votes out of order, so an edit of an older poll version than
+// the pending or the sent one is dropped. An edit rejected because of the rate limit pauses the queue for the time
+// Telegram asks for, an edit which doesn't change the message isn't sent
+type editQueue struct {
+	send  sendFunc
+	delay time.Duration
+	now   func() time.Time
+	sleep func(ctx context.Context, d time.Duration) error
+	// sent keeps the text and the markup of the last edit sent to a message
+	sent *cache.Store[string, string]
+
+	mu      sync.Mutex
+	pending map[string]*pendingEdit
+	// sentVersions keeps the poll versions of the recently sent edits
+	sentVersions map[string]sentVersion
+	// pausedUntil is set by "Too Many Requests" and by a full batch, no edits are sent until then
+	pausedUntil time.Time
+	running     bool
+	wakeCh      chan struct{}
+	closeCh     chan struct{}
+	doneCh      chan struct{}
+	closeOnce   sync.Once
+}
+
+func newEditQueue(send sendFunc, delay time.Duration) *editQueue {
+	return &editQueue{
+		send:         send,
+		delay:        delay,
+		now:          time.Now,
+		sleep:        sleepContext,
+		sent:         cache.NewStoreWithOptions(cache.Options[string, string]{MaxEntries: sentEditsLimit}),
+		pending:      make(map[string]*pendingEdit),
+		sentVersions: make(map[string]sentVersion),
+		wakeCh:       make(chan struct{}, 1),
+		closeCh:      make(chan struct{}),
+		doneCh:       make(chan struct{}),
+	}
+}
+
+func (c *Client) editMessages(version int64, edits ...tgbot.EditMessageTextConfig) {
+	c.edits.add(version, edits...)
+}
+
+// add replaces the pending edit of the message unless it shows a newer version of the poll,
+// the edit is sent when the delay since the first pending one passes
+func (q *editQueue) add(version int64, edits ...tgbot.EditMessageTextConfig) {
+	now := q.now()
+
+	q.mu.Lock()
+	for _, edit := range edits {
+		if sent, ok := q.sentVersions[edit.InlineMessageID]; ok && version < sent.version && now.Sub(sent.at) < sentVersionTTL {
+			continue
+		}
+
+		if p, ok := q.pending[edit.InlineMessageID]; ok {
+			if version < p.version {
+				continue
+			}
+
+			p.edit = edit
+			p.version = version
+			p.attempts = 0
+			continue
+		}
+
+		q.pending[edit.InlineMessageID] = &pendingEdit{edit: edit, version: version, due: now.Add(q.delay)}
+	}
+	q.mu.Unlock()
+
+	select {
+	case q.wakeCh <- struct{}{}:
+	default:
+	}
+}
+
+// start runs the sending in the background until close
+func (q *editQueue) start() {
+	q.mu.Lock()
+	q.running = true
+	q.mu.Unlock()
+
+	go q.run()
+}
+
+func (q *editQueue) run() {
+	defer close(q.doneCh)
+
+	for {
+		wait := idleWait
+		if next, ok := q.nextDue(); ok {
+			wait = next.Sub(q.now())
+		}
+		if wait <= 0 {
+			q.sendDue(q.now(), false)
+			continue
+		}
+
+		timer := time.NewTimer(wait)
+		select {
+		case <-q.closeCh:
+			timer.Stop()
+			return
+		case <-q.wakeCh:
+			timer.Stop()
+		case <-timer.C:
+			q.sendDue(q.now(), false)
+		}
+	}
+}
+
+// close stops the background sending and sends the pending edits without the delay until ctx is done
+func (q *editQueue) close(ctx context.Context) {
+	q.closeOnce.Do(func() {
+		close(q.closeCh)
+
+		q.mu.Lock()
+		running := q.running
+		q.mu.Unlock()
+		if running {
+			<-q.doneCh
+		}
+
+		q.flush(ctx)
+		q.sent.Close()
+	})
+}
+
+// flush sends all pending edits, waiting for the pauses requested by Telegram. The edits left when ctx is done are dropped
+func (q *editQueue) flush(ctx context.Context) {
+	for {
+		q.mu.Lock()
+		pending := len(q.pending)
+		pause := q.pausedUntil.Sub(q.now())
+		q.mu.Unlock()
+
+		if pending == 0 {
+			return
+		}
+		if pause > 0 && ctx.Err() == nil {
+			_ = q.sleep(ctx, pause)
+		}
+		if ctx.Err() != nil {
+			log.Printf("%d pending edits are dropped: %s", pending, ctx.Err())
+			q.mu.Lock()
+			q.pending = make(map[string]*pendingEdit)
+			q.mu.Unlock()
+			return
+		}
+
+		q.sendDue(q.now(), true)
+	}
+}
+
+// nextDue returns the time the next edit could be sent at, false without the pending edits
+func (q *editQueue) nextDue() (time.Time, bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	var next time.Time
+	for _, p := range q.pending {
+		if next.IsZero() || p.due.Before(next) {
+			next = p.due
+		}
+	}
+	if next.IsZero() {
+		return next, false
+	}
+
+	if next.Before(q.pausedUntil) {
+		next = q.pausedUntil
+	}
+
+	return next, true
+}
+
+// sendDue sends a batch of the edits due at now, all pending edits are due when force is set
+func (q *editQueue) sendDue(now time.Time, force bool) {
+	q.mu.Lock()
+	if now.Before(q.pausedUntil) {
+		q.mu.Unlock()
+		return
+	}
+
+	var due []*pendingEdit
+	for _, p := range q.pending {
+		if force || !p.due.After(now) {
+			due = append(due, p)
+		}
+	}
+	sort.Slice(due, func(i, j int) bool {
+		if !due[i].due.Equal(due[j].due) {
+			return due[i].due.Before(due[j].due)
+		}
+
+		return due[i].edit.InlineMessageID < due[j].edit.InlineMessageID
+	})
+	if len(due) > editBatchSize {
+		due = due[:editBatchSize]
+	}
+	for id, sent := range q.sentVersions {
+		if now.Sub(sent.at) >= sentVersionTTL {
+			delete(q.sentVersions, id)
+		}
+	}
+	for _, p := range due {
+		delete(q.pending, p.edit.InlineMessageID)
+		q.sentVersions[p.edit.InlineMessageID] = sentVersion{version: p.version, at: now}
+	}
+	q.mu.Unlock()
+
+	for i, p := range due {
+		delay := q.sendEdit(p.edit)
+		if delay == 0 {
+			continue
+		}
+
+		p.attempts++
+		q.mu.Lock()
+		q.pausedUntil = q.now().Add(delay)
+		if p.attempts == maxEditAttempts {
+			log.Printf("edit message '%s' is given up after %d attempts", p.edit.InlineMessageID, p.attempts)
+			q.requeue(due[i+1:])
+		} else {
+			q.requeue(due[i:])
+		}
+		q.mu.Unlock()
+
+		return
+	}
+
+	if len(due) == editBatchSize {
+		q.mu.Lock()
+		if next := q.now().Add(editBatchInterval); q.pausedUntil.Before(next) {
+			q.pausedUntil = next
+		}
+		q.mu.Unlock()
+	}
+}
+
+// requeue returns the unsent edits to the queue unless newer edits of their messages have been added, it's called under the lock
+func (q *editQueue) requeue(edits []*pendingEdit) {
+	for _, p := range edits {
+		if _, ok := q.pending[p.edit.InlineMessageID]; !ok {
+			q.pending[p.edit.InlineMessageID] = p
+		}
+	}
+}
+
+// sendEdit returns the delay Telegram asks to wait before the edit is repeated, zero when it's sent or failed for another reason
+func (q *editQueue) sendEdit(edit tgbot.EditMessageTextConfig) time.Duration {
+	content := editContent(edit)
+	if last, ok := q.sent.Load(edit.InlineMessageID); ok && last == content {
+		return 0
+	}
+
+	_, err := q.send(edit)
+	if err == nil || isNotModified(err) {
+		q.sent.Store(edit.InlineMessageID, content)
+		return 0
+	}
+
+	if delay := retryAfter(err); delay > 0 {
+		return delay
+	}
+
+	log.Printf("edit message '%s' error: %s", edit.InlineMessageID, err)
+
+	return 0
+}
+
+// sleepContext waits for the delay, it returns earlier with the error when ctx is done
+func sleepContext(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+// editContent is what the message looks like after the edit
+func editContent(edit tgbot.EditMessageTextConfig) string {
+	markup, err := json.Marshal(edit.ReplyMarkup)
+	if err != nil {
+		markup = nil
+	}
+
+	return edit.ParseMode + "\n" + string(markup) + "\n" + edit.Text
+}
+
+// isNotModified tells that the message already has the text and the markup of the edit
+func isNotModified(err error) bool {
+	tgErr, ok := err.(tgbot.Error)
+
+	return ok && strings.Contains(tgErr.Message, "message is not modified")
+}
+
+// retryAfter returns the delay requested by Telegram when the rate limit is exceeded, zero for the other errors
+func retryAfter(err error) time.Duration {
+	tgErr, ok := err.(tgbot.Error)
+	if !ok {
+		return 0
+	}
+
+	return time.Duration(tgErr.RetryAfter) * time.Second
+}
@@ -0,0 +1,274 @@
+package telegram
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"testing"
+	"time"
+
+	tgbot "github.com/go-telegram-bot-api/telegram-bot-api"
+	"github.com/stretchr/testify/assert"
+)
+
+const testEditDelay = time.Second
+
+func testEdit(id, text string) tgbot.EditMessageTextConfig {
+	return tgbot.EditMessageTextConfig{BaseEdit: tgbot.BaseEdit{InlineMessageID: id}, Text: text}
+}
+
+// newTestEditQueue returns a queue with the clock set to start, which is moved by the sleeps,
+// and the sent edits in the "id:text" form
+func newTestEditQueue(start time.Time, errs map[string][]error) (*editQueue, *time.Time, *[]string) {
+	var sent []string
+	now := start
+	q := newEditQueue(func(c tgbot.Chattable) (tgbot.Message, error) {
+		edit := c.(tgbot.EditMessageTextConfig)
+		sent = append(sent, edit.InlineMessageID+":"+edit.Text)
+		if e := errs[edit.InlineMessageID]; len(e) > 0 {
+			errs[edit.InlineMessageID] = e[1:]
+			return tgbot.Message{}, e[0]
+		}
+
+		return tgbot.Message{}, nil
+	}, testEditDelay)
+	q.now = func() time.Time { return now }
+	q.sleep = func(_ context.Context, d time.Duration) error {
+		now = now.Add(d)
+		return nil
+	}
+
+	return q, &now, &sent
+}
+
+func Test_editQueue_sendDue(t *testing.T) {
+	type step struct {
+		// at is the time since the start the edits are added and the due ones are sent at
+		at time.Duration
+		// version is the poll version of the added edits
+		version int64
+		add     []tgbot.EditMessageTextConfig
+	}
+	batch := func(n int) []tgbot.EditMessageTextConfig {
+		result := make([]tgbot.EditMessageTextConfig, n)
+		for i := range result {
+			result[i] = testEdit(strconv.Itoa(100+i), "a")
+		}
+
+		return result
+	}
+	tooManyRequests := tgbot.Error{Message: "Too Many Requests", ResponseParameters: tgbot.ResponseParameters{RetryAfter: 3}}
+
+	tests := []struct {
+		name      string
+		steps     []step
+		errs      map[string][]error
+		wantSent  []string
+		wantCount int
+	}{
+		{
+			name:  "edit waits for the delay",
+			steps: []step{{add: []tgbot.EditMessageTextConfig{testEdit("1", "a")}}, {at: testEditDelay / 2}},
+		},
+		{
+			name: "burst is merged",
+			steps: []step{
+				{add: []tgbot.EditMessageTextConfig{testEdit("1", "a"), testEdit("2", "a")}},
+				{at: testEditDelay / 2, add: []tgbot.EditMessageTextConfig{testEdit("1", "b")}},
+				{at: testEditDelay},
+			},
+			wantSent: []string{"1:b", "2:a"},
+		},
+		{
+			name: "older version doesn't replace the pending edit",
+			steps: []step{
+				{version: 2, add: []tgbot.EditMessageTextConfig{testEdit("1", "b")}},
+				{at: testEditDelay / 2, version: 1, add: []tgbot.EditMessageTextConfig{testEdit("1", "a")}},
+				{at: testEditDelay},
+			},
+			wantSent: []string{"1:b"},
+		},
+		{
+			name: "older version after the sent edit is dropped",
+			steps: []step{
+				{version: 2, add: []tgbot.EditMessageTextConfig{testEdit("1", "b")}},
+				{at: testEditDelay},
+				{at: 2 * testEditDelay, version: 1, add: []tgbot.EditMessageTextConfig{testEdit("1", "a")}},
+				{at: 3 * testEditDelay},
+			},
+			wantSent: []string{"1:b"},
+		},
+		{
+			name: "older version is sent after the sent version is forgotten",
+			steps: []step{
+				{version: 2, add: []tgbot.EditMessageTextConfig{testEdit("1", "b")}},
+				{at: testEditDelay},
+				{at: testEditDelay + sentVersionTTL, version: 1, add: []tgbot.EditMessageTextConfig{testEdit("1", "a")}},
+				{at: 2*testEditDelay + sentVersionTTL},
+			},
+			wantSent: []string{"1:b", "1:a"},
+		},
+		{
+			name: "unchanged edit is skipped",
+			steps: []step{
+				{add: []tgbot.EditMessageTextConfig{testEdit("1", "a")}},
+				{at: testEditDelay},
+				{at: 2 * testEditDelay, add: []tgbot.EditMessageTextConfig{testEdit("1", "a")}},
+				{at: 3 * testEditDelay},
+			},
+			wantSent: []string{"1:a"},
+		},
+		{
+			name: "not modified message is remembered",
+			steps: []step{
+				{add: []tgbot.EditMessageTextConfig{testEdit("1", "a")}},
+				{at: testEditDelay},
+				{at: 2 * testEditDelay, add: []tgbot.EditMessageTextConfig{testEdit("1", "a")}},
+				{at: 3 * testEditDelay},
+			},
+			errs:     map[string][]error{"1": {tgbot.Error{Message: "Bad Request: message is not modified"}}},
+			wantSent: []string{"1:a"},
+		},
+		{
+			name: "failed edit is not remembered",
+			steps: []step{
+				{add: []tgbot.EditMessageTextConfig{testEdit("1", "a")}},
+				{at: testEditDelay},
+				{at: 2 * testEditDelay, add: []tgbot.EditMessageTextConfig{testEdit("1", "a")}},
+				{at: 3 * testEditDelay},
+			},
+			errs:     map[string][]error{"1": {errors.New("connection reset")}},
+			wantSent: []string{"1:a", "1:a"},
+		},
+		{
+			name: "rate limited edit is repeated after the requested delay",
+			steps: []step{
+				{add: []tgbot.EditMessageTextConfig{testEdit("1", "a"), testEdit("2", "a")}},
+				{at: testEditDelay},
+				{at: testEditDelay + 2*time.Second},
+				{at: testEditDelay + 3*time.Second},
+			},
+			errs:     map[string][]error{"1": {tooManyRequests}},
+			wantSent: []string{"1:a", "1:a", "2:a"},
+		},
+		{
+			name: "rate limited edit is replaced by a newer one",
+			steps: []step{
+				{add: []tgbot.EditMessageTextConfig{testEdit("1", "a")}},
+				{at: testEditDelay},
+				{at: testEditDelay + time.Second, add: []tgbot.EditMessageTextConfig{testEdit("1", "b")}},
+				{at: testEditDelay + 3*time.Second},
+			},
+			errs:     map[string][]error{"1": {tooManyRequests}},
+			wantSent: []string{"1:a", "1:b"},
+		},
+		{
+			name: "rate limited edit is given up",
+			steps: []step{
+				{add: []tgbot.EditMessageTextConfig{testEdit("1", "a"), testEdit("2", "a")}},
+				{at: testEditDelay},
+				{at: testEditDelay + 3*time.Second},
+				{at: testEditDelay + 6*time.Second},
+				{at: testEditDelay + 9*time.Second},
+			},
+			errs:     map[string][]error{"1": {tooManyRequests, tooManyRequests, tooManyRequests, tooManyRequests}},
+			wantSent: []string{"1:a", "1:a", "1:a", "2:a"},
+		},
+		{
+			name: "batches are delayed",
+			steps: []step{
+				{add: batch(editBatchSize + 1)},
+				{at: testEditDelay},
+				{at: testEditDelay + editBatchInterval/2},
+			},
+			wantCount: editBatchSize,
+		},
+		{
+			name: "next batch is sent after the interval",
+			steps: []step{
+				{add: batch(editBatchSize + 1)},
+				{at: testEditDelay},
+				{at: testEditDelay + editBatchInterval},
+			},
+			wantCount: editBatchSize + 1,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			start := time.Date(2020, 1, 2, 15, 4, 0, 0, time.UTC)
+			q, now, sent := newTestEditQueue(start, tt.errs)
+			defer q.close(context.Background())
+
+			for _, s := range tt.steps {
+				*now = start.Add(s.at)
+				q.add(s.version, s.add...)
+				q.sendDue(*now, false)
+			}
+
+			if tt.wantCount != 0 {
+				assert.Len(t, *sent, tt.wantCount)
+				return
+			}
+			assert.Equal(t, tt.wantSent, *sent)
+		})
+	}
+}
+
+func Test_editQueue_close(t *testing.T) {
+	start := time.Date(2020, 1, 2, 15, 4, 0, 0, time.UTC)
+	tooManyRequests := tgbot.Error{Message: "Too Many Requests", ResponseParameters: tgbot.ResponseParameters{RetryAfter: 3}}
+	q, _, sent := newTestEditQueue(start, map[string][]error{"1": {tooManyRequests}})
+
+	var sleeps []time.Duration
+	sleep := q.sleep
+	q.sleep = func(ctx context.Context, d time.Duration) error {
+		sleeps = append(sleeps, d)
+		return sleep(ctx, d)
+	}
+
+	q.add(0, testEdit("1", "a"), testEdit("2", "a"))
+	q.close(context.Background())
+
+	assert.Equal(t, []string{"1:a", "1:a", "2:a"}, *sent)
+	assert.Equal(t, []time.Duration{3 * time.Second}, sleeps)
+}
+
+func Test_editQueue_close_deadline(t *testing.T) {
+	start := time.Date(2020, 1, 2, 15, 4, 0, 0, time.UTC)
+	tooManyRequests := tgbot.Error{Message: "Too Many Requests", ResponseParameters: tgbot.ResponseParameters{RetryAfter: 3}}
+	q, _, sent := newTestEditQueue(start, map[string][]error{"1": {tooManyRequests}})
+
+	// the deadline passes during the pause requested by Telegram
+	ctx, cancel := context.WithCancel(context.Background())
+	q.sleep = func(ctx context.Context, _ time.Duration) error {
+		cancel()
+		return ctx.Err()
+	}
+
+	q.add(0, testEdit("1", "a"), testEdit("2", "a"))
+	q.close(ctx)
+
+	assert.Equal(t, []string{"1:a"}, *sent)
+	assert.Empty(t, q.pending)
+}
+
+func Test_editQueue_run(t *testing.T) {
+	sent := make(chan string, 10)
+	q := newEditQueue(func(c tgbot.Chattable) (tgbot.Message, error) {
+		sent <- c.(tgbot.EditMessageTextConfig).Text
+		return tgbot.Message{}, nil
+	}, 50*time.Millisecond)
+	q.start()
+	defer q.close(context.Background())
+
+	q.add(1, testEdit("1", "a"))
+	q.add(2, testEdit("1", "b"))
+
+	select {
+	case text := <-sent:
+		assert.Equal(t, "b", text)
+	case <-time.After(5 * time.Second):
+		t.Fatal("edit isn't sent")
+	}
+}
@@ -0,0 +1,37 @@
+package telegram
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// endpointTransport redirects the requests of tgbot, which has the Telegram address built in,
+// to another Bot API server, e.g. a local one or the fake one of the tests
+type endpointTransport struct {
+	endpoint *url.URL
+	base     http.RoundTripper
+}
+
+func newEndpointClient(endpoint string) (*http.Client, error) {
+	link, err := url.Parse(endpoint)
+	if err != nil || (link.Scheme != "http" && link.Scheme != "https") || link.Host == "" {
+		return nil, errors.Errorf("api endpoint '%s' should be an absolute http or https url", endpoint)
+	}
+
+	return &http.Client{Transport: endpointTransport{endpoint: link, base: http.DefaultTransport}}, nil
+}
+
+// RoundTrip keeps the "/bot<token>/<method>" path of the request under the path of the endpoint
+func (t endpointTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	req = req.Clone(req.Context())
+	req.URL.Scheme = t.endpoint.Scheme
+	req.URL.Host = t.endpoint.Host
+	req.URL.Path = strings.TrimSuffix(t.endpoint.Path, "/") + req.URL.Path
+	req.URL.RawPath = ""
+	req.Host = t.endpoint.Host
+
+	return t.base.RoundTrip(req)
+}
@@ -0,0 +1,67 @@
+package telegram
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestEndpointTransport(t *testing.T) {
+	tests := []struct {
+		name     string
+		prefix   string
+		wantPath string
+	}{
+		{name: "root", prefix: "", wantPath: "/bot123:token/getMe"},
+		{name: "root with slash", prefix: "/", wantPath: "/bot123:token/getMe"},
+		{name: "path", prefix: "/telegram", wantPath: "/telegram/bot123:token/getMe"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var gotPath string
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				gotPath = r.URL.Path
+			}))
+			defer server.Close()
+
+			client, err := newEndpointClient(server.URL + tt.prefix)
+			require.NoError(t, err)
+
+			resp, err := client.Post("https://api.telegram.org/bot123:token/getMe", "application/x-www-form-urlencoded", nil)
+			require.NoError(t, err)
+			resp.Body.Close()
+
+			assert.Equal(t, tt.wantPath, gotPath)
+		})
+	}
+}
+
+func TestNewEndpointClient(t *testing.T) {
+	tests := []struct {
+		name     string
+		endpoint string
+		wantErr  bool
+	}{
+		{name: "http", endpoint: "http://localhost:8081"},
+		{name: "https with path", endpoint: "https://bot-api.example.com/api"},
+		{name: "no scheme", endpoint: "localhost:8081", wantErr: true},
+		{name: "other scheme", endpoint: "ftp://localhost", wantErr: true},
+		{name: "relative", endpoint: "/api", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := newEndpointClient(tt.endpoint)
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+
+			assert.NoError(t, err)
+		})
+	}
+}
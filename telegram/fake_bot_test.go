@@ -0,0 +1,169 @@
+package telegram
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+	"sync"
+
+	tgbot "github.com/go-telegram-bot-api/telegram-bot-api"
+)
+
+// fakeBot records the requests of the client instead of sending them to Telegram
+type fakeBot struct {
+	mu              sync.Mutex
+	messageID       int
+	messages        []tgbot.MessageConfig
+	edits           []tgbot.EditMessageTextConfig
+	other           []tgbot.Chattable
+	inlineAnswers   []tgbot.InlineConfig
+	callbackAnswers []tgbot.CallbackConfig
+	requests        []string
+	// beforeSend is called outside of the lock, e.g. to block a request
+	beforeSend func(c tgbot.Chattable)
+	// updates is returned by GetUpdatesChan, the nil channel never delivers
+	updates chan tgbot.Update
+}
+
+func (b *fakeBot) Send(c tgbot.Chattable) (tgbot.Message, error) {
+	if b.beforeSend != nil {
+		b.beforeSend(c)
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	switch config := c.(type) {
+	case tgbot.MessageConfig:
+		b.messages = append(b.messages, config)
+	case tgbot.EditMessageTextConfig:
+		b.edits = append(b.edits, config)
+	default:
+		b.other = append(b.other, c)
+	}
+
+	b.messageID++
+	return tgbot.Message{MessageID: b.messageID}, nil
+}
+
+func (b *fakeBot) AnswerInlineQuery(config tgbot.InlineConfig) (tgbot.APIResponse, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.inlineAnswers = append(b.inlineAnswers, config)
+	return tgbot.APIResponse{Ok: true}, nil
+}
+
+func (b *fakeBot) AnswerCallbackQuery(config tgbot.CallbackConfig) (tgbot.APIResponse, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.callbackAnswers = append(b.callbackAnswers, config)
+	return tgbot.APIResponse{Ok: true}, nil
+}
+
+func (b *fakeBot) MakeRequest(endpoint string, params url.Values) (tgbot.APIResponse, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.requests = append(b.requests, endpoint+" "+params.Encode())
+	return tgbot.APIResponse{Ok: true}, nil
+}
+
+func (b *fakeBot) UploadFile(endpoint string, params map[string]string, fieldname string, file interface{}) (tgbot.APIResponse, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.requests = append(b.requests, fmt.Sprintf("%s %s=%v", endpoint, fieldname, file))
+	return tgbot.APIResponse{Ok: true}, nil
+}
+
+func (b *fakeBot) GetUpdatesChan(config tgbot.UpdateConfig) (tgbot.UpdatesChannel, error) {
+	return b.updates, nil
+}
+
+func (b *fakeBot) StopReceivingUpdates() {}
+
+func (b *fakeBot) messageTexts() []string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	texts := make([]string, len(b.messages))
+	for i, msg := range b.messages {
+		texts[i] = msg.Text
+	}
+
+	return texts
+}
+
+func (b *fakeBot) callbackTexts() []string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	texts := make([]string, len(b.callbackAnswers))
+	for i, answer := range b.callbackAnswers {
+		texts[i] = answer.Text
+	}
+
+	return texts
+}
+
+// editTexts maps the edited messages to their last text, a message edited without a keyboard is marked with "[no keyboard]"
+func (b *fakeBot) editTexts() map[string]string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	texts := make(map[string]string, len(b.edits))
+	for _, edit := range b.edits {
+		text := edit.Text
+		if edit.ReplyMarkup == nil {
+			text += " [no keyboard]"
+		}
+		texts[edit.InlineMessageID] = text
+	}
+
+	return texts
+}
+
+// textUpdate builds a private message, a text starting with "/" is a command
+func textUpdate(userID int, text string) tgbot.Update {
+	msg := &tgbot.Message{
+		From: &tgbot.User{ID: userID, FirstName: "Test", LastName: "User"},
+		Chat: &tgbot.Chat{ID: int64(userID), Type: "private"},
+		Text: text,
+	}
+	if strings.HasPrefix(text, "/") {
+		length := strings.Index(text, " ")
+		if length == -1 {
+			length = len(text)
+		}
+		msg.Entities = &[]tgbot.MessageEntity{{Type: "bot_command", Offset: 0, Length: length}}
+	}
+
+	return tgbot.Update{Message: msg}
+}
+
+func inlineQueryUpdate(userID int, query string) tgbot.Update {
+	return tgbot.Update{InlineQuery: &tgbot.InlineQuery{
+		ID:    "query-" + query,
+		From:  &tgbot.User{ID: userID, FirstName: "Test", LastName: "User"},
+		Query: query,
+	}}
+}
+
+func chosenResultUpdate(userID int, pollID, inlineMessageID string) tgbot.Update {
+	return tgbot.Update{ChosenInlineResult: &tgbot.ChosenInlineResult{
+		ResultID:        pollID,
+		From:            &tgbot.User{ID: userID, FirstName: "Test", LastName: "User"},
+		InlineMessageID: inlineMessageID,
+	}}
+}
+
+func callbackUpdate(userID int, inlineMessageID, data string) tgbot.Update {
+	return tgbot.Update{CallbackQuery: &tgbot.CallbackQuery{
+		ID:              "callback-" + data,
+		From:            &tgbot.User{ID: userID, FirstName: "Test", LastName: "User"},
+		InlineMessageID: inlineMessageID,
+		Data:            data,
+	}}
+}
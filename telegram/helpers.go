@@ -1,10 +1,15 @@
 package telegram
 
 import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
 	"encoding/json"
 	"fmt"
 	"strconv"
 	"strings"
+	"time"
+	"unicode/utf8"
 
 	"github.com/incu6us/vote-bot/telegram/models"
 
@@ -15,45 +20,417 @@ import (
 
 const (
 	inlineButtonLength = 32
+
+	// maxButtonsInRow is the Telegram limit of buttons in one keyboard row
+	maxButtonsInRow = 8
+	// maxRowWidth is the number of symbols which fits into a row on a phone screen
+	maxRowWidth = 30
+	// buttonPadding is the width a button takes in addition to its label, it's small enough for maxButtonsInRow
+	// one-symbol labels to fit into maxRowWidth
+	buttonPadding = 2
+
+	// callbackDataVersion prefixes the compact callback data: "<version>:<poll id>:<item index>:<items revision>[:<signature>]"
+	callbackDataVersion   = "2"
+	callbackDataSeparator = ":"
+	// callbackSignatureLength is the number of bytes of HMAC-SHA256 kept in the callback data
+	callbackSignatureLength = 12
+
+	// closeTimeLayout is used to show the close time of a poll, absolute close times are accepted in UTC
+	closeTimeLayout = "2006-01-02 15:04 MST"
+)
+
+// closeTimeLayouts are the accepted formats of an absolute close time
+var closeTimeLayouts = []string{time.RFC3339, "2006-01-02 15:04", "2006-01-02T15:04", "2006-01-02"}
+
+var (
+	errBadCallbackData      = errors.New("bad callback data")
+	errBadCallbackSignature = errors.New("bad callback data signature")
+	errStaleItems           = errors.New("poll items have been changed")
+	errBadCloseTime         = errors.New("bad close time")
+	errCloseTimeInPast      = errors.New("close time is in the past")
+
+	errEmptyItem      = errors.New("item is empty")
+	errItemExists     = errors.New("item exists already")
+	errBadItemNumber  = errors.New("bad item number")
+	errTooManyItems   = errors.New("too many items")
+	errUnknownCommand = errors.New("unknown command")
+)
+
+// commands editing the items of a poll
+const (
+	editAdd    = "add"
+	editRemove = "remove"
+	editRename = "rename"
 )
 
-func preparePollArticle(poll *domain.Poll) tgbot.InlineQueryResultArticle {
-	id := strconv.FormatInt(poll.CreatedAt, 10)
+func preparePollArticle(poll *domain.Poll, secret []byte) tgbot.InlineQueryResultArticle {
 	subject := poll.Subject
 	if len(subject) >= inlineButtonLength {
 		subject = poll.Subject[:inlineButtonLength] + "..."
 	}
-	resultArticleMarkdown := tgbot.NewInlineQueryResultArticleMarkdown(id, subject, escapeURLMarkdownSymbols(poll.Subject))
-	resultArticleMarkdown.ReplyMarkup = preparePollKeyboardMarkup(poll)
+	text := escapeURLMarkdownSymbols(poll.Subject)
+	if poll.ClosedAt != 0 {
+		text = pollResultsText(poll, "")
+	}
+	resultArticleMarkdown := tgbot.NewInlineQueryResultArticleMarkdown(poll.ID, subject, text)
+	resultArticleMarkdown.ReplyMarkup = preparePollKeyboardMarkup(poll, secret)
 
 	return resultArticleMarkdown
 }
 
-func preparePollKeyboardMarkup(poll *domain.Poll) *tgbot.InlineKeyboardMarkup {
-	keyboard := new(tgbot.InlineKeyboardMarkup)
-	var row []tgbot.InlineKeyboardButton
+// preparePollKeyboardMarkup returns nil for closed polls, a message edited without the markup loses its keyboard
+func preparePollKeyboardMarkup(poll *domain.Poll, secret []byte) *tgbot.InlineKeyboardMarkup {
+	if poll.ClosedAt != 0 {
+		return nil
+	}
+
+	buttons := make([]tgbot.InlineKeyboardButton, len(poll.Items))
+	for i, item := range poll.Items {
+		buttons[i] = tgbot.NewInlineKeyboardButtonData(pollButtonText(poll, item), prepareCallbackData(poll.ID, i, poll.ItemsRevision, secret))
+	}
+
+	return &tgbot.InlineKeyboardMarkup{InlineKeyboard: layoutKeyboard(buttons)}
+}
+
+// pollButtonText marks chosen items of multiple choice polls with a check mark and the number of votes
+func pollButtonText(poll *domain.Poll, item string) string {
+	if !poll.MultipleChoice || len(poll.Votes[item]) == 0 {
+		return item
+	}
+
+	return fmt.Sprintf("✅ %s (%d)", item, len(poll.Votes[item]))
+}
+
+// voterKey identifies the voter in the stored votes. Anonymous polls keep a salted hash of the user ID,
+// so neither the name nor the ID of the voter gets into the storage
+func voterKey(poll *domain.Poll, user *tgbot.User, secret []byte) string {
+	if !poll.Anonymous {
+		return user.String()
+	}
+
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(poll.Salt + callbackDataSeparator + strconv.Itoa(user.ID)))
+
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// pollResultsText renders the poll message, anonymous polls show only the numbers of votes per item
+func pollResultsText(poll *domain.Poll, voter string) string {
+	header := escapeURLMarkdownSymbols(poll.Subject) + "\n---"
+	switch {
+	case poll.ClosedAt != 0:
+		header += "\nClosed, final results"
+	case poll.ClosesAt != 0:
+		header += "\nCloses at: " + formatCloseTime(poll.ClosesAt)
+	}
+
+	if !poll.Anonymous {
+		var votes string
+		for _, item := range poll.Items {
+			if len(poll.Votes[item]) == 0 {
+				continue
+			}
+
+			votes += "\n- " + item + ":\n"
+			for _, v := range poll.Votes[item] {
+				votes += "\t\t\t\t" + v + "\n"
+			}
+		}
+
+		if poll.ClosedAt == 0 && voter != "" {
+			header += "\nLast Vote: " + voter
+		}
+
+		return fmt.Sprintf("%s\nVotes: \n```%s```", header, votes)
+	}
+
+	// percents are counted from the number of voters, so they don't sum up to 100 for multiple choice polls
+	voters := poll.VotersCount()
+
+	var votes string
 	for _, item := range poll.Items {
-		btn := tgbot.NewInlineKeyboardButtonData(item, prepareCallbackData(poll.CreatedAt, item))
+		var percent int
+		if voters > 0 {
+			percent = len(poll.Votes[item]) * 100 / voters
+		}
+		votes += fmt.Sprintf("\n- %s: %d (%d%%)", item, len(poll.Votes[item]), percent)
+	}
+
+	return fmt.Sprintf("%s\nVoters: %d\n```%s```", header, voters, votes)
+}
+
+// editDraftItems applies the command to the items of the edited poll: "add <item>", "remove <number>" or "rename <number> <item>"
+func editDraftItems(draft *models.Poll, command, args string, maximumAnswers int) error {
+	args = strings.TrimSpace(args)
+	switch command {
+	case editAdd:
+		if len(draft.Items) >= maximumAnswers {
+			return errTooManyItems
+		}
+
+		if err := checkNewItem(draft, args); err != nil {
+			return err
+		}
+
+		draft.Items = append(draft.Items, args)
+		draft.ItemOrigins = append(draft.ItemOrigins, "")
+	case editRemove:
+		i, err := itemIndex(draft, args)
+		if err != nil {
+			return err
+		}
+
+		draft.Items = append(draft.Items[:i], draft.Items[i+1:]...)
+		draft.ItemOrigins = append(draft.ItemOrigins[:i], draft.ItemOrigins[i+1:]...)
+	case editRename:
+		fields := strings.SplitN(args, " ", 2)
+		i, err := itemIndex(draft, fields[0])
+		if err != nil {
+			return err
+		}
+
+		var item string
+		if len(fields) == 2 {
+			item = strings.TrimSpace(fields[1])
+		}
+		if err := checkNewItem(draft, item); err != nil {
+			return err
+		}
+
+		draft.Items[i] = item
+	default:
+		return errUnknownCommand
+	}
+
+	return nil
+}
+
+func checkNewItem(draft *models.Poll, item string) error {
+	if item == "" {
+		return errEmptyItem
+	}
+
+	for _, existing := range draft.Items {
+		if existing == item {
+			return errItemExists
+		}
+	}
+
+	return nil
+}
+
+// itemIndex converts the number of the item shown to the user into the index
+func itemIndex(draft *models.Poll, number string) (int, error) {
+	n, err := strconv.Atoi(number)
+	if err != nil || n < 1 || n > len(draft.Items) {
+		return 0, errBadItemNumber
+	}
+
+	return n - 1, nil
+}
+
+// keptItems maps the previous items of the edited poll to their current names
+func keptItems(draft *models.Poll) map[string]string {
+	kept := make(map[string]string)
+	for i, origin := range draft.ItemOrigins {
+		if origin != "" {
+			kept[origin] = draft.Items[i]
+		}
+	}
+
+	return kept
+}
+
+func editPollText(draft *models.Poll) string {
+	text := fmt.Sprintf("Editing '%s':\n", escapeURLMarkdownSymbols(draft.PollName))
+	for i, item := range draft.Items {
+		text += fmt.Sprintf("\n%d. %s", i+1, escapeURLMarkdownSymbols(item))
+		if origin := draft.ItemOrigins[i]; origin == "" {
+			text += " (new)"
+		} else if origin != item {
+			text += fmt.Sprintf(" (was %s)", escapeURLMarkdownSymbols(origin))
+		}
+	}
+
+	return text + "\n\n- put an item or `/add <item>` - to add an item;" +
+		"\n- `/remove <number>` - to remove an item, its votes are dropped;" +
+		"\n- `/rename <number> <item>` - to rename an item, its votes are kept;" +
+		"\n- `/done` - to save the changes;\n- `/cancel` - to discard them"
+}
+
+// deletedPollText replaces the messages of a deleted poll, the subject is unknown for the polls deleted before the click
+func deletedPollText(poll *domain.Poll) string {
+	if poll.Subject == "" {
+		return "The poll was deleted"
+	}
+
+	return fmt.Sprintf("%s\n---\nThe poll was deleted", escapeURLMarkdownSymbols(poll.Subject))
+}
+
+// parseCloseTime accepts a duration from now ("in 2h", "90m", "3d") or an absolute time in UTC ("2020-01-02 15:04")
+func parseCloseTime(value string, now time.Time) (time.Time, error) {
+	value = strings.TrimSpace(strings.ToLower(value))
+	value = strings.TrimSpace(strings.TrimPrefix(value, "in "))
+
+	closeTime, err := parseAbsoluteTime(value)
+	if err != nil {
+		d, err := parseDuration(value)
+		if err != nil {
+			return time.Time{}, errBadCloseTime
+		}
+
+		closeTime = now.Add(d)
+	}
+
+	if !closeTime.After(now) {
+		return time.Time{}, errCloseTimeInPast
+	}
+
+	return closeTime, nil
+}
+
+func parseAbsoluteTime(value string) (time.Time, error) {
+	for _, layout := range closeTimeLayouts {
+		if t, err := time.ParseInLocation(layout, strings.ToUpper(value), time.UTC); err == nil {
+			return t, nil
+		}
+	}
+
+	return time.Time{}, errBadCloseTime
+}
+
+// parseDuration extends time.ParseDuration with days
+func parseDuration(value string) (time.Duration, error) {
+	if strings.HasSuffix(value, "d") {
+		days, err := strconv.Atoi(strings.TrimSuffix(value, "d"))
+		if err != nil {
+			return 0, err
+		}
+
+		return time.Duration(days) * 24 * time.Hour, nil
+	}
+
+	return time.ParseDuration(value)
+}
+
+func formatCloseTime(closesAt int64) string {
+	return time.Unix(0, closesAt).UTC().Format(closeTimeLayout)
+}
+
+func voteAnswerText(poll *domain.Poll, vote, voter string) string {
+	if !poll.MultipleChoice {
+		return fmt.Sprintf("Vote '%s' accepted", vote)
+	}
+
+	for _, item := range poll.VoterItems(voter) {
+		if item == vote {
+			return fmt.Sprintf("Vote '%s' accepted", vote)
+		}
+	}
+
+	return fmt.Sprintf("Vote '%s' withdrawn", vote)
+}
+
+// layoutKeyboard wraps buttons into rows, so short answers share a row and long ones get a row of their own
+func layoutKeyboard(buttons []tgbot.InlineKeyboardButton) [][]tgbot.InlineKeyboardButton {
+	var (
+		rows     [][]tgbot.InlineKeyboardButton
+		row      []tgbot.InlineKeyboardButton
+		rowWidth int
+	)
+	for _, btn := range buttons {
+		width := utf8.RuneCountInString(btn.Text) + buttonPadding
+		if len(row) > 0 && (len(row) == maxButtonsInRow || rowWidth+width > maxRowWidth) {
+			rows = append(rows, row)
+			row, rowWidth = nil, 0
+		}
+
 		row = append(row, btn)
+		rowWidth += width
+	}
+
+	if len(row) > 0 {
+		rows = append(rows, row)
 	}
-	keyboard.InlineKeyboard = append(keyboard.InlineKeyboard, row)
 
-	return keyboard
+	return rows
 }
 
-// TODO: add FFJSON
-func prepareCallbackData(createdAt int64, vote string) string {
-	data, _ := json.Marshal(models.CallbackData{CreatedAt: createdAt, Vote: vote})
-	return string(data)
+// prepareCallbackData encodes the answer as "2:<poll id>:<item index>:<items revision>", the signature is appended when the secret is set
+func prepareCallbackData(pollID string, item int, itemsRevision int64, secret []byte) string {
+	return joinCallbackData([]string{callbackDataVersion, pollID, strconv.Itoa(item), strconv.FormatInt(itemsRevision, 10)}, secret)
 }
 
-func serializeCallbackData(data string) (*models.CallbackData, error) {
-	callbackData := new(models.CallbackData)
-	if err := json.Unmarshal([]byte(data), callbackData); err != nil {
-		return nil, errors.Wrap(err, "serialize callback data error")
+// serializeCallbackData decodes both the compact forms and the legacy JSON of the messages posted by the previous versions.
+// The legacy buttons were posted before the signatures, so they are accepted unsigned; their vote is checked against the items
+func serializeCallbackData(data string, secret []byte) (*models.CallbackData, error) {
+	if strings.HasPrefix(data, "{") {
+		callbackData := new(models.CallbackData)
+		if err := json.Unmarshal([]byte(data), callbackData); err != nil {
+			return nil, errors.Wrap(err, "serialize callback data error")
+		}
+
+		if callbackData.CreatedAt == 0 || callbackData.Vote == "" {
+			return nil, errBadCallbackData
+		}
+
+		return callbackData, nil
 	}
 
-	return callbackData, nil
+	parts, err := splitCallbackData(data, 4, secret)
+	if err != nil {
+		return nil, err
+	}
+
+	if parts[0] != callbackDataVersion || parts[1] == "" {
+		return nil, errBadCallbackData
+	}
+
+	item, err := strconv.Atoi(parts[2])
+	if err != nil || item < 0 {
+		return nil, errBadCallbackData
+	}
+
+	itemsRevision, err := strconv.ParseInt(parts[3], 10, 64)
+	if err != nil || itemsRevision < 0 {
+		return nil, errBadCallbackData
+	}
+
+	return &models.CallbackData{ID: parts[1], Item: item, ItemsRevision: itemsRevision}, nil
+}
+
+// joinCallbackData joins the parts of the callback data, the signature is appended when the secret is set
+func joinCallbackData(parts []string, secret []byte) string {
+	data := strings.Join(parts, callbackDataSeparator)
+	if len(secret) == 0 {
+		return data
+	}
+
+	return data + callbackDataSeparator + signCallbackData(data, secret)
+}
+
+// splitCallbackData returns n parts of the callback data, the signature is checked when the secret is set
+func splitCallbackData(data string, n int, secret []byte) ([]string, error) {
+	parts := strings.Split(data, callbackDataSeparator)
+	if len(parts) < n || len(parts) > n+1 {
+		return nil, errBadCallbackData
+	}
+
+	if len(secret) > 0 {
+		signed := strings.Join(parts[:n], callbackDataSeparator)
+		if len(parts) != n+1 || !hmac.Equal([]byte(parts[n]), []byte(signCallbackData(signed, secret))) {
+			return nil, errBadCallbackSignature
+		}
+	}
+
+	return parts[:n], nil
+}
+
+func signCallbackData(data string, secret []byte) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(data))
+
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil)[:callbackSignatureLength])
 }
 
 func msgYouHaveNoAccess(id int64) string {
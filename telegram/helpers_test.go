@@ -2,7 +2,11 @@ package telegram
 
 import (
 	"testing"
+	"time"
 
+	tgbot "github.com/go-telegram-bot-api/telegram-bot-api"
+	"github.com/incu6us/vote-bot/domain"
+	"github.com/incu6us/vote-bot/telegram/models"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -32,3 +36,379 @@ func Test_escapeURLMarkdownSymbols(t *testing.T) {
 		})
 	}
 }
+
+func Test_serializeCallbackData(t *testing.T) {
+	secret := []byte("secret")
+	tests := []struct {
+		name    string
+		data    string
+		secret  []byte
+		want    *models.CallbackData
+		wantErr error
+	}{
+		{
+			name: "compact",
+			data: prepareCallbackData("AbCdEf012345", 2, 0, nil),
+			want: &models.CallbackData{ID: "AbCdEf012345", Item: 2},
+		},
+		{
+			name: "compact with items revision",
+			data: prepareCallbackData("AbCdEf012345", 2, 3, nil),
+			want: &models.CallbackData{ID: "AbCdEf012345", Item: 2, ItemsRevision: 3},
+		},
+		{
+			name:   "signed",
+			data:   prepareCallbackData("AbCdEf012345", 9, 0, secret),
+			secret: secret,
+			want:   &models.CallbackData{ID: "AbCdEf012345", Item: 9},
+		},
+		{
+			name:    "not signed when secret is set",
+			data:    prepareCallbackData("AbCdEf012345", 1, 0, nil),
+			secret:  secret,
+			wantErr: errBadCallbackSignature,
+		},
+		{
+			name:    "signed with another secret",
+			data:    prepareCallbackData("AbCdEf012345", 1, 0, []byte("another")),
+			secret:  secret,
+			wantErr: errBadCallbackSignature,
+		},
+		{
+			name:    "unknown version",
+			data:    "3:AbCdEf012345:1:0",
+			wantErr: errBadCallbackData,
+		},
+		{
+			name:    "missing items revision",
+			data:    "2:AbCdEf012345:1",
+			wantErr: errBadCallbackData,
+		},
+		{
+			name:    "bad items revision",
+			data:    "2:AbCdEf012345:1:-1",
+			wantErr: errBadCallbackData,
+		},
+		{
+			name:    "bad item",
+			data:    "2:AbCdEf012345:-1:0",
+			wantErr: errBadCallbackData,
+		},
+		{
+			name:    "legacy poll id isn't supported",
+			data:    `{"id":"AbCdEf012345","vote":"yes"}`,
+			secret:  secret,
+			wantErr: errBadCallbackData,
+		},
+		{
+			name: "legacy created_at",
+			data: `{"created_at":1541678134000000000,"vote":"yes"}`,
+			want: &models.CallbackData{CreatedAt: 1541678134000000000, Vote: "yes"},
+		},
+		{
+			name:   "legacy created_at with secret",
+			data:   `{"created_at":1541678134000000000,"vote":"yes"}`,
+			secret: secret,
+			want:   &models.CallbackData{CreatedAt: 1541678134000000000, Vote: "yes"},
+		},
+		{
+			name:    "legacy without vote",
+			data:    `{"created_at":1541678134000000000}`,
+			wantErr: errBadCallbackData,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := serializeCallbackData(tt.data, tt.secret)
+			if tt.wantErr != nil {
+				assert.Equal(t, tt.wantErr, err)
+				return
+			}
+
+			assert.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func Test_prepareCallbackData_fitsTelegramLimit(t *testing.T) {
+	// Telegram rejects buttons with more than 64 bytes of callback_data
+	const maxCallbackDataLength = 64
+
+	data := prepareCallbackData("AbCdEf012345", 99, 999999, []byte("secret"))
+	assert.True(t, len(data) <= maxCallbackDataLength, "callback data %q is %d bytes", data, len(data))
+}
+
+func Test_layoutKeyboard(t *testing.T) {
+	buttons := func(labels ...string) []tgbot.InlineKeyboardButton {
+		result := make([]tgbot.InlineKeyboardButton, len(labels))
+		for i, label := range labels {
+			result[i] = tgbot.NewInlineKeyboardButtonData(label, label)
+		}
+
+		return result
+	}
+	labels := func(rows [][]tgbot.InlineKeyboardButton) [][]string {
+		result := make([][]string, len(rows))
+		for i, row := range rows {
+			for _, btn := range row {
+				result[i] = append(result[i], btn.Text)
+			}
+		}
+
+		return result
+	}
+
+	tests := []struct {
+		name    string
+		buttons []tgbot.InlineKeyboardButton
+		want    [][]string
+	}{
+		{
+			name:    "short answers share a row",
+			buttons: buttons("yes", "no", "maybe"),
+			want:    [][]string{{"yes", "no", "maybe"}},
+		},
+		{
+			name:    "row is limited by the number of buttons",
+			buttons: buttons("1", "2", "3", "4", "5", "6", "7", "8", "9", "10"),
+			want:    [][]string{{"1", "2", "3", "4", "5", "6", "7", "8"}, {"9", "10"}},
+		},
+		{
+			name:    "row is limited by the width",
+			buttons: buttons("Monday", "Tuesday", "Wednesday", "Thursday"),
+			want:    [][]string{{"Monday", "Tuesday", "Wednesday"}, {"Thursday"}},
+		},
+		{
+			name:    "long answers get own rows",
+			buttons: buttons("Pizza with pineapple and ham", "Sushi", "Burger", "A very long answer about nothing"),
+			want:    [][]string{{"Pizza with pineapple and ham"}, {"Sushi", "Burger"}, {"A very long answer about nothing"}},
+		},
+		{
+			name:    "non latin labels are measured in symbols",
+			buttons: buttons("Так", "Ні", "Не знаю"),
+			want:    [][]string{{"Так", "Ні", "Не знаю"}},
+		},
+		{
+			name: "no buttons",
+			want: [][]string{},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, labels(layoutKeyboard(tt.buttons)))
+		})
+	}
+}
+
+func Test_pollButtonText(t *testing.T) {
+	votes := map[string][]string{"pizza": {"alice", "bob"}}
+	tests := []struct {
+		name string
+		poll *domain.Poll
+		item string
+		want string
+	}{
+		{
+			name: "single choice",
+			poll: &domain.Poll{Votes: votes},
+			item: "pizza",
+			want: "pizza",
+		},
+		{
+			name: "multiple choice with votes",
+			poll: &domain.Poll{Votes: votes, MultipleChoice: true},
+			item: "pizza",
+			want: "✅ pizza (2)",
+		},
+		{
+			name: "multiple choice without votes",
+			poll: &domain.Poll{Votes: votes, MultipleChoice: true},
+			item: "sushi",
+			want: "sushi",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, pollButtonText(tt.poll, tt.item))
+		})
+	}
+}
+
+func Test_voterKey(t *testing.T) {
+	alice := &tgbot.User{ID: 1, UserName: "alice"}
+	public := &domain.Poll{}
+	anonymous := &domain.Poll{Anonymous: true, Salt: "salt"}
+	secret := []byte("secret")
+
+	assert.Equal(t, "alice", voterKey(public, alice, secret))
+
+	key := voterKey(anonymous, alice, secret)
+	assert.NotContains(t, key, "alice")
+	assert.NotEqual(t, "1", key)
+	assert.Equal(t, key, voterKey(anonymous, &tgbot.User{ID: 1, UserName: "renamed"}, secret))
+	assert.NotEqual(t, key, voterKey(anonymous, &tgbot.User{ID: 2}, secret))
+	assert.NotEqual(t, key, voterKey(&domain.Poll{Anonymous: true, Salt: "other"}, alice, secret))
+	assert.NotEqual(t, key, voterKey(anonymous, alice, []byte("other")))
+}
+
+func Test_pollResultsText(t *testing.T) {
+	tests := []struct {
+		name string
+		poll *domain.Poll
+		want string
+	}{
+		{
+			name: "anonymous",
+			poll: &domain.Poll{
+				Subject:   "Lunch",
+				Items:     []string{"pizza", "sushi", "soup"},
+				Votes:     map[string][]string{"pizza": {"h1", "h2"}, "sushi": {"h3"}},
+				Anonymous: true,
+			},
+			want: "Lunch\n---\nVoters: 3\n```\n- pizza: 2 (66%)\n- sushi: 1 (33%)\n- soup: 0 (0%)```",
+		},
+		{
+			name: "anonymous multiple choice counts voters once",
+			poll: &domain.Poll{
+				Subject:        "Lunch",
+				Items:          []string{"pizza", "sushi"},
+				Votes:          map[string][]string{"pizza": {"h1", "h2"}, "sushi": {"h1"}},
+				Anonymous:      true,
+				MultipleChoice: true,
+			},
+			want: "Lunch\n---\nVoters: 2\n```\n- pizza: 2 (100%)\n- sushi: 1 (50%)```",
+		},
+		{
+			name: "anonymous without votes",
+			poll: &domain.Poll{Subject: "Lunch", Items: []string{"pizza"}, Anonymous: true},
+			want: "Lunch\n---\nVoters: 0\n```\n- pizza: 0 (0%)```",
+		},
+		{
+			name: "anonymous closed",
+			poll: &domain.Poll{Subject: "Lunch", Items: []string{"pizza"}, Anonymous: true, ClosedAt: 1},
+			want: "Lunch\n---\nClosed, final results\nVoters: 0\n```\n- pizza: 0 (0%)```",
+		},
+		{
+			name: "public with close time",
+			poll: &domain.Poll{Subject: "Lunch", Items: []string{"pizza"}, ClosesAt: time.Date(2020, 1, 2, 15, 4, 0, 0, time.UTC).UnixNano()},
+			want: "Lunch\n---\nCloses at: 2020-01-02 15:04 UTC\nLast Vote: alice\nVotes: \n``````",
+		},
+		{
+			name: "public closed",
+			poll: &domain.Poll{Subject: "Lunch", Items: []string{"pizza", "sushi"}, Votes: map[string][]string{"sushi": {"bob"}, "pizza": {"alice"}}, ClosedAt: 1},
+			want: "Lunch\n---\nClosed, final results\nVotes: \n```\n- pizza:\n\t\t\t\talice\n\n- sushi:\n\t\t\t\tbob\n```",
+		},
+		{
+			name: "public",
+			poll: &domain.Poll{Subject: "Lunch", Items: []string{"pizza"}, Votes: map[string][]string{"pizza": {"alice"}}},
+			want: "Lunch\n---\nLast Vote: alice\nVotes: \n```\n- pizza:\n\t\t\t\talice\n```",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, pollResultsText(tt.poll, "alice"))
+		})
+	}
+}
+
+func Test_parseCloseTime(t *testing.T) {
+	now := time.Date(2020, 1, 2, 10, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name    string
+		value   string
+		want    time.Time
+		wantErr error
+	}{
+		{name: "duration", value: "2h", want: now.Add(2 * time.Hour)},
+		{name: "duration with in", value: "In 90m", want: now.Add(90 * time.Minute)},
+		{name: "days", value: "in 3d", want: now.Add(72 * time.Hour)},
+		{name: "date and time", value: "2020-01-02 15:04", want: time.Date(2020, 1, 2, 15, 4, 0, 0, time.UTC)},
+		{name: "date", value: "2020-01-03", want: time.Date(2020, 1, 3, 0, 0, 0, 0, time.UTC)},
+		{name: "rfc3339", value: "2020-01-02T12:00:00+01:00", want: time.Date(2020, 1, 2, 11, 0, 0, 0, time.UTC)},
+		{name: "in the past", value: "2020-01-01", wantErr: errCloseTimeInPast},
+		{name: "negative duration", value: "-1h", wantErr: errCloseTimeInPast},
+		{name: "garbage", value: "tomorrow", wantErr: errBadCloseTime},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseCloseTime(tt.value, now)
+			if tt.wantErr != nil {
+				assert.Equal(t, tt.wantErr, err)
+				return
+			}
+
+			assert.NoError(t, err)
+			assert.True(t, tt.want.Equal(got), "want %s, got %s", tt.want, got)
+		})
+	}
+}
+
+func Test_pollUpdate(t *testing.T) {
+	poll := &domain.Poll{InlineMessageIDs: []string{"a", "b"}}
+
+	update := pollUpdate(poll, "alice", "b", "c", "")
+	assert.Len(t, update, 3)
+	for _, id := range []inlineMessageID{"a", "b", "c"} {
+		assert.Equal(t, &models.UpdatedPoll{Voter: "alice", Poll: poll}, update[id])
+	}
+	assert.Equal(t, []string{"a", "b"}, poll.InlineMessageIDs)
+}
+
+func Test_editDraftItems(t *testing.T) {
+	newDraft := func() *models.Poll {
+		return &models.Poll{
+			Items:       []string{"pizza", "sushi"},
+			ItemOrigins: []string{"pizza", "sushi"},
+			EditPollID:  "AbCdEf012345",
+		}
+	}
+
+	tests := []struct {
+		name        string
+		command     string
+		args        string
+		wantItems   []string
+		wantOrigins []string
+		wantErr     error
+	}{
+		{name: "add", command: editAdd, args: " soup ", wantItems: []string{"pizza", "sushi", "soup"}, wantOrigins: []string{"pizza", "sushi", ""}},
+		{name: "add existing", command: editAdd, args: "sushi", wantErr: errItemExists},
+		{name: "add empty", command: editAdd, args: " ", wantErr: errEmptyItem},
+		{name: "remove", command: editRemove, args: "1", wantItems: []string{"sushi"}, wantOrigins: []string{"sushi"}},
+		{name: "remove unknown", command: editRemove, args: "3", wantErr: errBadItemNumber},
+		{name: "remove not a number", command: editRemove, args: "pizza", wantErr: errBadItemNumber},
+		{name: "rename", command: editRename, args: "2 sushi rolls", wantItems: []string{"pizza", "sushi rolls"}, wantOrigins: []string{"pizza", "sushi"}},
+		{name: "rename to existing", command: editRename, args: "2 pizza", wantErr: errItemExists},
+		{name: "rename without name", command: editRename, args: "2", wantErr: errEmptyItem},
+		{name: "unknown", command: "move", args: "1", wantErr: errUnknownCommand},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			draft := newDraft()
+			err := editDraftItems(draft, tt.command, tt.args, 3)
+			if tt.wantErr != nil {
+				assert.Equal(t, tt.wantErr, err)
+				assert.Equal(t, newDraft(), draft)
+				return
+			}
+
+			assert.NoError(t, err)
+			assert.Equal(t, tt.wantItems, draft.Items)
+			assert.Equal(t, tt.wantOrigins, draft.ItemOrigins)
+		})
+	}
+
+	draft := newDraft()
+	assert.NoError(t, editDraftItems(draft, editAdd, "soup", 3))
+	assert.Equal(t, errTooManyItems, editDraftItems(draft, editAdd, "salad", 3))
+}
+
+func Test_keptItems(t *testing.T) {
+	draft := &models.Poll{
+		Items:       []string{"sushi rolls", "soup"},
+		ItemOrigins: []string{"sushi", ""},
+	}
+
+	assert.Equal(t, map[string]string{"sushi": "sushi rolls"}, keptItems(draft))
+}
@@ -7,16 +7,43 @@ import (
 type Poll struct {
 	PollName, Owner string
 	Items           []string
+	MultipleChoice  bool
+	Anonymous       bool
+	// ClosesAt is the close time in nanoseconds, zero means the poll has no close time
+	ClosesAt int64
+	// EditPollID is set when the draft edits the items of an existing poll
+	EditPollID string
+	// ItemOrigins keeps the previous name of every item of an edited poll, it's empty for the added items
+	ItemOrigins []string
+	// ChatID is the chat the draft is made in, the user is told there when the draft is lost
+	ChatID int64
 }
 
+// CallbackData is attached to the poll buttons. The compact form sets ID and Item,
+// the legacy JSON form sets Vote and CreatedAt (messages posted before polls got IDs)
 type CallbackData struct {
-	CreatedAt int64  `json:"created_at"`
+	ID        string `json:"-"`
+	CreatedAt int64  `json:"created_at,omitempty"`
 	Vote      string `json:"vote"`
+	Item      int    `json:"-"`
+	// ItemsRevision is the revision of the poll items the button was posted for
+	ItemsRevision int64 `json:"-"`
 }
 
 type UpdatedPoll struct {
+	// Voter is the name of the last voter, it's empty for anonymous polls
 	Voter string
 	Poll  *domain.Poll
+	// Deleted replaces the poll message with a note that the poll was deleted
+	Deleted bool
 }
 
 type UserID int
+
+// Action is attached to the buttons of the poll management messages
+type Action struct {
+	Name   string
+	PollID string
+	// Page is the page of the polls list the action was sent from, -1 if it wasn't sent from the list
+	Page int
+}
@@ -0,0 +1,290 @@
+package telegram
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	tgbot "github.com/go-telegram-bot-api/telegram-bot-api"
+	"github.com/incu6us/vote-bot/domain"
+	"github.com/incu6us/vote-bot/repository"
+	"github.com/incu6us/vote-bot/telegram/models"
+	"github.com/pkg/errors"
+)
+
+const (
+	// actionDataPrefix starts the callback data of the management buttons: "m:<action>:<poll id>:<page>[:<signature>]"
+	actionDataPrefix = "m"
+
+	actionPage          = "p"
+	actionResults       = "r"
+	actionClose         = "c"
+	actionReopen        = "o"
+	actionDelete        = "d"
+	actionConfirmDelete = "y"
+	actionCancelDelete  = "n"
+
+	// noListPage marks the actions which weren't sent from the polls list
+	noListPage = -1
+
+	myPollsPageSize = 5
+	// shareButtonLength limits the subject shown on the share button
+	shareButtonLength = 20
+
+	privateChatOnlyText = "Your polls are managed in the private chat with the bot"
+)
+
+// showMyPolls sends the page of the owner's polls, or replaces the message with it when the message ID is set
+func (c *Client) showMyPolls(chatID int64, messageID int, owner string, page int) error {
+	polls, err := c.store.GetPollsByOwner(owner)
+	if err != nil {
+		return errors.Wrap(err, "get polls failed")
+	}
+
+	text, markup := myPollsMessage(polls, page, time.Now(), c.callbackSecret)
+
+	return c.sendOrEdit(chatID, messageID, text, markup)
+}
+
+func (c *Client) sendOrEdit(chatID int64, messageID int, text string, markup *tgbot.InlineKeyboardMarkup) error {
+	if messageID == 0 {
+		msg := tgbot.NewMessage(chatID, text)
+		msg.ParseMode = string(parseMode)
+		if markup != nil {
+			msg.ReplyMarkup = markup
+		}
+
+		_, err := c.bot.Send(msg)
+		return errors.Wrap(err, sendMessageErrorString)
+	}
+
+	editMsg := tgbot.NewEditMessageText(chatID, messageID, text)
+	editMsg.ParseMode = string(parseMode)
+	editMsg.ReplyMarkup = markup
+	_, err := c.bot.Send(editMsg)
+
+	return errors.Wrap(err, "edit message error")
+}
+
+// processAction handles the buttons of the poll management messages, every action is allowed only to the owner of the poll.
+// The messages are sent only to the private chats, so the one who clicks is the one the list was sent to
+func (c *Client) processAction(callback *tgbot.CallbackQuery) error {
+	action, err := parseActionData(callback.Data, c.callbackSecret)
+	if err != nil {
+		return errors.Wrap(err, "get action data error")
+	}
+
+	if callback.Message == nil || callback.Message.Chat == nil {
+		return errors.New("action is sent without a message")
+	}
+
+	if !callback.Message.Chat.IsPrivate() {
+		return c.answerCallback(callback.ID, privateChatOnlyText)
+	}
+
+	var (
+		chatID    = callback.Message.Chat.ID
+		messageID = callback.Message.MessageID
+		owner     = getOwner(callback.From.ID, callback.From.String())
+		answer    string
+	)
+
+	var poll *domain.Poll
+	if action.Name != actionPage {
+		poll, err = c.ownerPoll(action.PollID, owner)
+		if err == repository.ErrPollIsNotFound {
+			if err := c.answerCallback(callback.ID, "No such poll"); err != nil {
+				return err
+			}
+
+			if action.Page == noListPage {
+				return c.sendOrEdit(chatID, messageID, "No such poll", nil)
+			}
+
+			return c.showMyPolls(chatID, messageID, owner, action.Page)
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	switch action.Name {
+	case actionPage:
+	case actionResults:
+		msg := tgbot.NewMessage(chatID, pollResultsText(poll, ""))
+		msg.ParseMode = string(parseMode)
+		if _, err := c.bot.Send(msg); err != nil {
+			return errors.Wrap(err, sendMessageErrorString)
+		}
+	case actionClose, actionReopen:
+		updated, err := c.store.UpdatePollIsClosed(poll.Subject, owner, action.Name == actionClose)
+		switch errors.Cause(err) {
+		case nil:
+			answer = "The poll is reopened"
+			if action.Name == actionClose {
+				answer = "The poll is closed"
+			}
+			c.updatePollCh <- pollUpdate(updated, "")
+		case repository.ErrPollIsClosed:
+			answer = "The poll is closed already"
+		case repository.ErrPollIsOpen:
+			answer = "The poll is open already"
+		default:
+			return errors.Wrap(err, "update poll failed")
+		}
+	case actionDelete:
+		if err := c.answerCallback(callback.ID, ""); err != nil {
+			return err
+		}
+
+		text, markup := deleteConfirmationMessage(poll, action.Page, c.callbackSecret)
+
+		return c.sendOrEdit(chatID, messageID, text, markup)
+	case actionConfirmDelete:
+		if err := c.deletePoll(poll, owner); err != nil {
+			return err
+		}
+		answer = fmt.Sprintf("The poll '%s' is deleted", poll.Subject)
+	case actionCancelDelete:
+		answer = fmt.Sprintf("The poll '%s' is kept", poll.Subject)
+	default:
+		return errBadCallbackData
+	}
+
+	if err := c.answerCallback(callback.ID, answer); err != nil {
+		return err
+	}
+
+	if action.Page == noListPage {
+		return c.sendOrEdit(chatID, messageID, escapeURLMarkdownSymbols(answer), nil)
+	}
+
+	return c.showMyPolls(chatID, messageID, owner, action.Page)
+}
+
+// deletePoll removes the poll of the owner and replaces its messages with a note that the poll was deleted
+func (c *Client) deletePoll(poll *domain.Poll, owner string) error {
+	if err := c.store.DeletePoll(poll.Subject, owner); err != nil {
+		return errors.Wrap(err, "delete poll failed")
+	}
+
+	update := pollUpdate(poll, "")
+	for _, updatedPoll := range update {
+		updatedPoll.Deleted = true
+	}
+	c.updatePollCh <- update
+
+	return nil
+}
+
+// ownerPoll hides the polls of the other users as if they don't exist
+func (c *Client) ownerPoll(pollID, owner string) (*domain.Poll, error) {
+	poll, err := c.store.GetPollByID(pollID)
+	if errors.Cause(err) == repository.ErrPollIsNotFound || (err == nil && poll.CreatedBy != owner) {
+		return nil, repository.ErrPollIsNotFound
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, "get poll failed")
+	}
+
+	return poll, nil
+}
+
+// myPollsMessage renders the page of the polls list, the page is moved into the range of the existing pages
+func myPollsMessage(polls []*domain.Poll, page int, now time.Time, secret []byte) (string, *tgbot.InlineKeyboardMarkup) {
+	if len(polls) == 0 {
+		return "You have no polls, use /newpoll to create one", nil
+	}
+
+	pages := (len(polls) + myPollsPageSize - 1) / myPollsPageSize
+	if page >= pages {
+		page = pages - 1
+	}
+	if page < 0 {
+		page = 0
+	}
+
+	first := page * myPollsPageSize
+	last := first + myPollsPageSize
+	if last > len(polls) {
+		last = len(polls)
+	}
+
+	text := fmt.Sprintf("Your polls, page %d of %d:\n", page+1, pages)
+	var rows [][]tgbot.InlineKeyboardButton
+	for i, poll := range polls[first:last] {
+		status, closeAction, closeButton := "open", actionClose, "🔒 Close"
+		if poll.IsClosed(now) {
+			status, closeAction, closeButton = "closed", actionReopen, "🔓 Reopen"
+		}
+		text += fmt.Sprintf("\n%d. %s - %s, voters: %d", first+i+1, escapeURLMarkdownSymbols(poll.Subject), status, poll.VotersCount())
+
+		rows = append(rows, []tgbot.InlineKeyboardButton{
+			{Text: fmt.Sprintf("📤 %d. %s", first+i+1, truncate(poll.Subject, shareButtonLength)), SwitchInlineQuery: stringToPtr(poll.Subject)},
+			tgbot.NewInlineKeyboardButtonData("📊", prepareActionData(models.Action{Name: actionResults, PollID: poll.ID, Page: page}, secret)),
+			tgbot.NewInlineKeyboardButtonData(closeButton, prepareActionData(models.Action{Name: closeAction, PollID: poll.ID, Page: page}, secret)),
+			tgbot.NewInlineKeyboardButtonData("🗑", prepareActionData(models.Action{Name: actionDelete, PollID: poll.ID, Page: page}, secret)),
+		})
+	}
+
+	var navigation []tgbot.InlineKeyboardButton
+	if page > 0 {
+		navigation = append(navigation, tgbot.NewInlineKeyboardButtonData("« Prev", prepareActionData(models.Action{Name: actionPage, Page: page - 1}, secret)))
+	}
+	if page < pages-1 {
+		navigation = append(navigation, tgbot.NewInlineKeyboardButtonData("Next »", prepareActionData(models.Action{Name: actionPage, Page: page + 1}, secret)))
+	}
+	if len(navigation) > 0 {
+		rows = append(rows, navigation)
+	}
+
+	return text, &tgbot.InlineKeyboardMarkup{InlineKeyboard: rows}
+}
+
+// deleteConfirmationMessage asks to confirm the deletion, "No" returns to the polls list if the deletion was started from it
+func deleteConfirmationMessage(poll *domain.Poll, page int, secret []byte) (string, *tgbot.InlineKeyboardMarkup) {
+	cancel := models.Action{Name: actionPage, Page: page}
+	if page == noListPage {
+		cancel = models.Action{Name: actionCancelDelete, PollID: poll.ID, Page: page}
+	}
+
+	text := fmt.Sprintf("Delete the poll '%s'? Its messages will stop accepting votes", escapeURLMarkdownSymbols(poll.Subject))
+	markup := tgbot.NewInlineKeyboardMarkup(tgbot.NewInlineKeyboardRow(
+		tgbot.NewInlineKeyboardButtonData("Yes, delete", prepareActionData(models.Action{Name: actionConfirmDelete, PollID: poll.ID, Page: page}, secret)),
+		tgbot.NewInlineKeyboardButtonData("No", prepareActionData(cancel, secret)),
+	))
+
+	return text, &markup
+}
+
+func isActionData(data string) bool {
+	return strings.HasPrefix(data, actionDataPrefix+callbackDataSeparator)
+}
+
+func prepareActionData(action models.Action, secret []byte) string {
+	return joinCallbackData([]string{actionDataPrefix, action.Name, action.PollID, strconv.Itoa(action.Page)}, secret)
+}
+
+func parseActionData(data string, secret []byte) (*models.Action, error) {
+	parts, err := splitCallbackData(data, 4, secret)
+	if err != nil {
+		return nil, err
+	}
+
+	page, err := strconv.Atoi(parts[3])
+	if err != nil || parts[0] != actionDataPrefix || parts[1] == "" || page < noListPage {
+		return nil, errBadCallbackData
+	}
+
+	return &models.Action{Name: parts[1], PollID: parts[2], Page: page}, nil
+}
+
+func truncate(s string, length int) string {
+	if utf8.RuneCountInString(s) <= length {
+		return s
+	}
+
+	return string([]rune(s)[:length]) + "…"
+}
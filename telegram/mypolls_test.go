@@ -0,0 +1,115 @@
+package telegram
+
+import (
+	"strconv"
+	"testing"
+	"time"
+
+	tgbot "github.com/go-telegram-bot-api/telegram-bot-api"
+	"github.com/incu6us/vote-bot/domain"
+	"github.com/incu6us/vote-bot/telegram/models"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_myPollsMessage(t *testing.T) {
+	now := time.Unix(1000, 0)
+	polls := make([]*domain.Poll, myPollsPageSize*2+1)
+	for i := range polls {
+		polls[i] = &domain.Poll{ID: "id" + strconv.Itoa(i), Subject: "poll " + strconv.Itoa(i+1)}
+	}
+	polls[0].ClosedAt = 1
+
+	navigation := func(markup *tgbot.InlineKeyboardMarkup) []string {
+		var labels []string
+		for _, btn := range markup.InlineKeyboard[len(markup.InlineKeyboard)-1] {
+			if btn.CallbackData != nil && (btn.Text == "« Prev" || btn.Text == "Next »") {
+				labels = append(labels, btn.Text)
+			}
+		}
+
+		return labels
+	}
+
+	tests := []struct {
+		name           string
+		page           int
+		wantTitle      string
+		wantRows       int
+		wantNavigation []string
+	}{
+		{name: "first page", page: 0, wantTitle: "Your polls, page 1 of 3:", wantRows: myPollsPageSize + 1, wantNavigation: []string{"Next »"}},
+		{name: "middle page", page: 1, wantTitle: "Your polls, page 2 of 3:", wantRows: myPollsPageSize + 1, wantNavigation: []string{"« Prev", "Next »"}},
+		{name: "last page", page: 2, wantTitle: "Your polls, page 3 of 3:", wantRows: 2, wantNavigation: []string{"« Prev"}},
+		{name: "page out of range", page: 10, wantTitle: "Your polls, page 3 of 3:", wantRows: 2, wantNavigation: []string{"« Prev"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			text, markup := myPollsMessage(polls, tt.page, now, nil)
+			assert.Contains(t, text, tt.wantTitle)
+			require.NotNil(t, markup)
+			assert.Len(t, markup.InlineKeyboard, tt.wantRows)
+			assert.Equal(t, tt.wantNavigation, navigation(markup))
+		})
+	}
+
+	text, markup := myPollsMessage(polls, 0, now, nil)
+	assert.Contains(t, text, "1. poll 1 - closed, voters: 0")
+	assert.Contains(t, text, "2. poll 2 - open, voters: 0")
+	assert.Equal(t, "🔓 Reopen", markup.InlineKeyboard[0][2].Text)
+	assert.Equal(t, "🔒 Close", markup.InlineKeyboard[1][2].Text)
+	assert.Equal(t, "poll 1", *markup.InlineKeyboard[0][0].SwitchInlineQuery)
+
+	text, markup = myPollsMessage(nil, 0, now, nil)
+	assert.Equal(t, "You have no polls, use /newpoll to create one", text)
+	assert.Nil(t, markup)
+}
+
+func Test_parseActionData(t *testing.T) {
+	secret := []byte("secret")
+	action := models.Action{Name: actionConfirmDelete, PollID: "AbCdEf012345", Page: 3}
+
+	got, err := parseActionData(prepareActionData(action, secret), secret)
+	require.NoError(t, err)
+	assert.Equal(t, &action, got)
+
+	_, err = parseActionData(prepareActionData(action, nil), secret)
+	assert.Equal(t, errBadCallbackSignature, err)
+
+	_, err = parseActionData("m:p::-2", nil)
+	assert.Equal(t, errBadCallbackData, err)
+
+	got, err = parseActionData("m:n:AbCdEf012345:-1", nil)
+	require.NoError(t, err)
+	assert.Equal(t, noListPage, got.Page)
+
+	assert.True(t, isActionData(prepareActionData(action, secret)))
+	assert.False(t, isActionData(prepareCallbackData("AbCdEf012345", 1, 0, secret)))
+	assert.True(t, len(prepareActionData(action, secret)) <= 64)
+}
+
+func Test_deleteConfirmationMessage(t *testing.T) {
+	poll := &domain.Poll{ID: "AbCdEf012345", Subject: "lunch"}
+	tests := []struct {
+		name       string
+		page       int
+		wantCancel models.Action
+	}{
+		{name: "from the list", page: 2, wantCancel: models.Action{Name: actionPage, Page: 2}},
+		{name: "from the command", page: noListPage, wantCancel: models.Action{Name: actionCancelDelete, PollID: poll.ID, Page: noListPage}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			text, markup := deleteConfirmationMessage(poll, tt.page, nil)
+			assert.Equal(t, "Delete the poll 'lunch'? Its messages will stop accepting votes", text)
+
+			confirm, err := parseActionData(*markup.InlineKeyboard[0][0].CallbackData, nil)
+			require.NoError(t, err)
+			assert.Equal(t, &models.Action{Name: actionConfirmDelete, PollID: poll.ID, Page: tt.page}, confirm)
+
+			cancel, err := parseActionData(*markup.InlineKeyboard[0][1].CallbackData, nil)
+			require.NoError(t, err)
+			assert.Equal(t, &tt.wantCancel, cancel)
+		})
+	}
+}
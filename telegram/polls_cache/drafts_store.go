@@ -0,0 +1,95 @@
+package polls_cache
+
+import (
+	"encoding/json"
+	"log"
+	"strconv"
+	"time"
+
+	"github.com/incu6us/vote-bot/repository"
+	"github.com/incu6us/vote-bot/telegram/models"
+	"github.com/pkg/errors"
+)
+
+type draftStorageInterface interface {
+	GetDraft(key string) ([]byte, error)
+	SaveDraft(key string, data []byte, expiresAt time.Time) error
+	DeleteDraft(key string) error
+	DeleteExpiredDrafts(now time.Time) (map[string][]byte, error)
+}
+
+// draftsStore keeps the drafts in the poll storage, so they survive restarts and are shared by the bot instances.
+// A draft expires when it isn't changed for ttl
+type draftsStore struct {
+	storage draftStorageInterface
+	ttl     time.Duration
+	now     func() time.Time
+}
+
+func NewDraftsStore(storage draftStorageInterface, ttl time.Duration) *draftsStore {
+	return &draftsStore{storage: storage, ttl: ttl, now: time.Now}
+}
+
+// Load returns nil for a missing draft, the storage errors are logged and the draft is treated as missing
+func (d draftsStore) Load(key models.UserID) *models.Poll {
+	data, err := d.storage.GetDraft(strconv.Itoa(int(key)))
+	if err != nil {
+		if errors.Cause(err) != repository.ErrDraftIsNotFound {
+			log.Printf("load draft of %d error: %s", key, err)
+		}
+
+		return nil
+	}
+
+	poll := new(models.Poll)
+	if err := json.Unmarshal(data, poll); err != nil {
+		log.Printf("unmarshal draft of %d error: %s", key, err)
+		return nil
+	}
+
+	return poll
+}
+
+func (d *draftsStore) Store(key models.UserID, poll *models.Poll) {
+	data, err := json.Marshal(poll)
+	if err != nil {
+		log.Printf("marshal draft of %d error: %s", key, err)
+		return
+	}
+
+	if err := d.storage.SaveDraft(strconv.Itoa(int(key)), data, d.now().Add(d.ttl)); err != nil {
+		log.Printf("save draft of %d error: %s", key, err)
+	}
+}
+
+func (d *draftsStore) Delete(key models.UserID) {
+	if err := d.storage.DeleteDraft(strconv.Itoa(int(key))); err != nil {
+		log.Printf("delete draft of %d error: %s", key, err)
+	}
+}
+
+// DeleteExpired removes the drafts expired before now and returns them by the users, the drafts which can't be decoded
+// are logged and returned as nil
+func (d *draftsStore) DeleteExpired(now time.Time) map[models.UserID]*models.Poll {
+	drafts, err := d.storage.DeleteExpiredDrafts(now)
+	if err != nil {
+		log.Printf("delete expired drafts error: %s", err)
+	}
+
+	result := make(map[models.UserID]*models.Poll, len(drafts))
+	for key, data := range drafts {
+		userID, err := strconv.Atoi(key)
+		if err != nil {
+			log.Printf("bad draft key '%s': %s", key, err)
+			continue
+		}
+
+		var poll *models.Poll
+		if err := json.Unmarshal(data, &poll); err != nil {
+			log.Printf("unmarshal draft of %d error: %s", userID, err)
+		}
+		result[models.UserID(userID)] = poll
+	}
+
+	return result
+}
@@ -0,0 +1,62 @@
+package polls_cache
+
+import (
+	"testing"
+	"time"
+
+	"github.com/incu6us/vote-bot/repository"
+	"github.com/incu6us/vote-bot/telegram/models"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_draftsStore(t *testing.T) {
+	now := time.Now()
+	poll := &models.Poll{PollName: "test poll", Owner: "me", Items: []string{"first item"}, MultipleChoice: true, ClosesAt: 42}
+	tests := []struct {
+		name    string
+		elapsed time.Duration
+		want    *models.Poll
+	}{
+		{
+			name:    "active draft",
+			elapsed: time.Minute,
+			want:    poll,
+		},
+		{
+			name:    "expired draft",
+			elapsed: 2 * time.Hour,
+			want:    nil,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			storage := repository.NewMemory()
+			d := NewDraftsStore(storage, time.Hour)
+			d.now = func() time.Time { return now.Add(-tt.elapsed) }
+
+			d.Store(1234, poll)
+			assert.Equal(t, tt.want, d.Load(1234))
+			assert.Nil(t, d.Load(4321))
+
+			d.Delete(1234)
+			assert.Nil(t, d.Load(1234))
+		})
+	}
+}
+
+func Test_draftsStore_DeleteExpired(t *testing.T) {
+	now := time.Now()
+	storage := repository.NewMemory()
+	d := NewDraftsStore(storage, time.Hour)
+	poll := &models.Poll{PollName: "test poll", Owner: "me", ChatID: -42}
+
+	d.now = func() time.Time { return now.Add(-2 * time.Hour) }
+	d.Store(1234, poll)
+	d.now = func() time.Time { return now }
+	d.Store(4321, &models.Poll{PollName: "active"})
+	assert.NoError(t, storage.SaveDraft("broken", []byte("{}"), now.Add(-time.Hour)))
+
+	assert.Equal(t, map[models.UserID]*models.Poll{1234: poll}, d.DeleteExpired(now))
+	assert.Empty(t, d.DeleteExpired(now))
+	assert.NotNil(t, d.Load(4321))
+}
@@ -1,37 +1,50 @@
 package polls_cache
 
 import (
+	"log"
 	"strconv"
+	"time"
 
+	"github.com/incu6us/vote-bot/cache"
 	"github.com/incu6us/vote-bot/telegram/models"
 )
 
-type pollsStoreInterface interface {
-	Load(key string) interface{}
-	Store(key string, value interface{})
-	Delete(key string)
-}
+// draftsNamespace keeps the drafts apart from the other entries of the shared cache
+const draftsNamespace = "drafts"
 
 type pollsStore struct {
-	store pollsStoreInterface
+	store *cache.Namespace[*models.Poll]
+	// ttl is the lifetime of a draft after its last change, zero keeps the drafts until they are deleted
+	ttl time.Duration
 }
 
-func NewPollsStore(store pollsStoreInterface) *pollsStore {
-	return &pollsStore{store: store}
+func NewPollsStore(store *cache.Shared, ttl time.Duration) *pollsStore {
+	return &pollsStore{store: cache.NewNamespace[*models.Poll](store, draftsNamespace), ttl: ttl}
 }
 
 func (p pollsStore) Load(key models.UserID) *models.Poll {
-	if val := p.store.Load(strconv.Itoa(int(key))); val != nil {
-		return val.(*models.Poll)
-	}
+	poll, _ := p.store.Load(strconv.Itoa(int(key)))
 
-	return nil
+	return poll
 }
 
 func (p *pollsStore) Store(key models.UserID, poll *models.Poll) {
-	p.store.Store(strconv.Itoa(int(key)), poll)
+	p.store.StoreWithTTL(strconv.Itoa(int(key)), poll, p.ttl)
 }
 
 func (p *pollsStore) Delete(key models.UserID) {
 	p.store.Delete(strconv.Itoa(int(key)))
 }
+
+// OnEvict adds a callback for the drafts which have expired or have been evicted from the cache
+func (p *pollsStore) OnEvict(fn func(key models.UserID, poll *models.Poll, reason cache.EvictionReason)) {
+	p.store.OnEvict(func(key string, poll *models.Poll, reason cache.EvictionReason) {
+		userID, err := strconv.Atoi(key)
+		if err != nil {
+			log.Printf("bad draft key '%s': %s", key, err)
+			return
+		}
+
+		fn(models.UserID(userID), poll, reason)
+	})
+}
@@ -2,6 +2,7 @@ package polls_cache
 
 import (
 	"testing"
+	"time"
 
 	"github.com/incu6us/vote-bot/cache"
 	"github.com/incu6us/vote-bot/telegram/models"
@@ -9,83 +10,93 @@ import (
 )
 
 func Test_pollsStore_Store_Load(t *testing.T) {
-	type fields struct {
-		tmpStore pollsStoreInterface
-	}
-	type want struct {
-		userID int
-		poll   *models.Poll
-	}
 	type args struct {
 		key  models.UserID
 		poll *models.Poll
 	}
 	tests := []struct {
 		name   string
-		fields fields
+		shared map[string]interface{}
 		args   args
-		want   want
+		userID models.UserID
+		want   *models.Poll
 	}{
 		{
 			name:   "success",
-			fields: struct{ tmpStore pollsStoreInterface }{tmpStore: cache.NewStore()},
-			args: struct {
-				key  models.UserID
-				poll *models.Poll
-			}{key: 1234, poll: &models.Poll{PollName: "test poll", Owner: "me", Items: []string{"first item"}}},
-			want: struct {
-				userID int
-				poll   *models.Poll
-			}{userID: 1234, poll: &models.Poll{PollName: "test poll", Owner: "me", Items: []string{"first item"}}},
+			args:   args{key: 1234, poll: &models.Poll{PollName: "test poll", Owner: "me", Items: []string{"first item"}}},
+			userID: 1234,
+			want:   &models.Poll{PollName: "test poll", Owner: "me", Items: []string{"first item"}},
+		},
+		{
+			name:   "other namespace",
+			shared: map[string]interface{}{"1234": "not a poll", "other:1234": 42},
+			args:   args{key: 4321, poll: &models.Poll{PollName: "test poll"}},
+			userID: 1234,
+			want:   nil,
+		},
+		{
+			name:   "value of another type",
+			shared: map[string]interface{}{draftsNamespace + ":1234": "not a poll"},
+			args:   args{key: 4321, poll: &models.Poll{PollName: "test poll"}},
+			userID: 1234,
+			want:   nil,
 		},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			p := &pollsStore{
-				store: tt.fields.tmpStore,
+			shared := cache.NewShared()
+			for key, value := range tt.shared {
+				shared.Store(key, value)
 			}
+
+			p := NewPollsStore(shared, 0)
 			p.Store(tt.args.key, tt.args.poll)
-			assert.Equal(t, p.Load(models.UserID(tt.want.userID)), tt.want.poll)
+			assert.Equal(t, tt.want, p.Load(tt.userID))
 		})
 	}
 }
 
 func Test_pollsStore_Delete(t *testing.T) {
-	type fields struct {
-		tmpStore pollsStoreInterface
-	}
 	type storedData struct {
 		userID models.UserID
 		poll   *models.Poll
 	}
-	type args struct {
-		key models.UserID
-	}
 	tests := []struct {
 		name       string
-		fields     fields
-		args       args
+		key        models.UserID
 		storedData storedData
 	}{
 		{
-			name:   "success",
-			args:   struct{ key models.UserID }{key: models.UserID(1234)},
-			fields: struct{ tmpStore pollsStoreInterface }{tmpStore: cache.NewStore()},
-			storedData: struct {
-				userID models.UserID
-				poll   *models.Poll
-			}{userID: models.UserID(1234), poll: &models.Poll{PollName: "test poll", Owner: "me", Items: []string{"first item"}}},
+			name:       "success",
+			key:        models.UserID(1234),
+			storedData: storedData{userID: models.UserID(1234), poll: &models.Poll{PollName: "test poll", Owner: "me", Items: []string{"first item"}}},
 		},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			p := &pollsStore{
-				store: tt.fields.tmpStore,
-			}
+			p := NewPollsStore(cache.NewShared(), 0)
 			p.Store(tt.storedData.userID, tt.storedData.poll)
 			assert.Equal(t, tt.storedData.poll, p.Load(tt.storedData.userID))
-			p.Delete(tt.args.key)
+			p.Delete(tt.key)
 			assert.Nil(t, p.Load(tt.storedData.userID))
 		})
 	}
 }
+
+func Test_pollsStore_OnEvict(t *testing.T) {
+	shared := cache.NewStoreWithOptions(cache.Options[string, any]{MaxEntries: 1})
+	defer shared.Close()
+
+	var evicted []models.UserID
+	p := NewPollsStore(shared, time.Hour)
+	p.OnEvict(func(key models.UserID, poll *models.Poll, reason cache.EvictionReason) {
+		assert.Equal(t, cache.Evicted, reason)
+		evicted = append(evicted, key)
+	})
+
+	p.Store(1234, &models.Poll{PollName: "first"})
+	p.Store(4321, &models.Poll{PollName: "second"})
+
+	assert.Equal(t, []models.UserID{1234}, evicted)
+	assert.Nil(t, p.Load(1234))
+}
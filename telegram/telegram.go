@@ -1,11 +1,19 @@
 package telegram
 
 import (
+	"context"
 	"fmt"
 	"log"
+	"math"
+	"net/http"
+	"net/url"
 	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
 
 	tgbot "github.com/go-telegram-bot-api/telegram-bot-api"
+	"github.com/incu6us/vote-bot/cache"
 	"github.com/incu6us/vote-bot/domain"
 	"github.com/incu6us/vote-bot/repository"
 	"github.com/incu6us/vote-bot/telegram/models"
@@ -13,21 +21,15 @@ import (
 	"github.com/pkg/errors"
 )
 
-type store interface {
-	GetPolls() ([]*domain.Poll, error)
-	GetPoll(pollName string) (*domain.Poll, error)
-	GetPollBeginsWith(pollName string) (*domain.Poll, error)
-	CreatePoll(pollName, owner string, items []string) error
-	DeletePoll(pollName, owner string) error
-	UpdatePollIsPublished(pollName, owner string, isPublished bool) error
-	UpdatePollItems(pollName, owner string, items []string) error
-	UpdateVote(createdAt int64, item, user string) (*domain.Poll, error)
-}
-
-type rawCacheInterface interface {
-	Load(key string) interface{}
-	Store(key string, value interface{})
-	Delete(key string)
+// BotAPI is the part of the Telegram Bot API the client uses, *tgbot.BotAPI implements it
+type BotAPI interface {
+	Send(c tgbot.Chattable) (tgbot.Message, error)
+	AnswerInlineQuery(config tgbot.InlineConfig) (tgbot.APIResponse, error)
+	AnswerCallbackQuery(config tgbot.CallbackConfig) (tgbot.APIResponse, error)
+	MakeRequest(endpoint string, params url.Values) (tgbot.APIResponse, error)
+	UploadFile(endpoint string, params map[string]string, fieldname string, file interface{}) (tgbot.APIResponse, error)
+	GetUpdatesChan(config tgbot.UpdateConfig) (tgbot.UpdatesChannel, error)
+	StopReceivingUpdates()
 }
 
 type pollCacheInterface interface {
@@ -36,6 +38,11 @@ type pollCacheInterface interface {
 	Delete(key models.UserID)
 }
 
+// expiredDraftsInterface removes the drafts kept in the storage, the cache removes its drafts by itself
+type expiredDraftsInterface interface {
+	DeleteExpired(now time.Time) map[models.UserID]*models.Poll
+}
+
 type parseModeType string
 
 const (
@@ -45,171 +52,553 @@ const (
 )
 
 const (
-	isDebug        = true
-	parseMode      = markdownParseMode
-	maximumAnswers = 3
+	isDebug   = true
+	parseMode = markdownParseMode
+
+	defaultMaximumAnswers = 10
+	// maximumAnswersLimit is the number of buttons Telegram allows in one inline keyboard
+	maximumAnswersLimit = 100
+
+	// closePollsInterval is how often the polls are checked for the passed close time, the expired drafts are removed as well
+	closePollsInterval = time.Minute
+
+	defaultDraftsTTL = 24 * time.Hour
+
+	defaultShutdownTimeout = 10 * time.Second
+
+	// deletedPollVersion is the version of the edits of a deleted poll, no later edit of the poll replaces them
+	deletedPollVersion = math.MaxInt64
 )
 
+// ErrShutdownTimeout is returned by Run when the updates and the edits aren't finished in time,
+// the storage could still be in use by them
+var ErrShutdownTimeout = errors.New("shutdown timeout has passed")
+
 type inlineMessageID string
 
+// Config holds the bot settings
+type Config struct {
+	Token   string
+	BotName string
+	UserIDs []int
+	// CallbackSecret signs the callback data of the poll buttons, the data isn't signed when it's empty
+	CallbackSecret string
+	// MaximumAnswers limits the number of items in a poll, 10 by default
+	MaximumAnswers int
+	// AnonymousSecret is mixed into the voter hashes of anonymous polls, the anonymous polls can't be created without it:
+	// the hashes could be matched to user IDs by anyone who reads the storage
+	AnonymousSecret string
+	// DraftsInMemory keeps the unfinished polls in the passed cache instead of the storage, they are lost on restart
+	DraftsInMemory bool
+	// DraftsTTL is how long an unchanged unfinished poll is kept, 24 hours by default
+	DraftsTTL time.Duration
+	// Mode is either PollingMode (default) or WebhookMode
+	Mode string
+	// PollingTimeout is the timeout of a long polling request, 60 seconds by default
+	PollingTimeout time.Duration
+	// Webhook is used in WebhookMode
+	Webhook WebhookConfig
+	// Workers is the number of the updates handled concurrently, 8 by default.
+	// The updates of a user are handled one by one in the order they come
+	Workers int
+	// EditDelay is how long the edits of a poll message are collected before the message is edited, a second by default.
+	// A burst of votes makes a single edit
+	EditDelay time.Duration
+	// ShutdownTimeout limits the handling of the received updates and the sending of the pending edits
+	// after Run is stopped, 10 seconds by default
+	ShutdownTimeout time.Duration
+	// APIEndpoint is the base URL of the Bot API server, e.g. "http://localhost:8081", Telegram is used when it's empty
+	APIEndpoint string
+}
+
 type Client struct {
 	botName         string
 	secureUserIDs   []int
-	bot             *tgbot.BotAPI
+	callbackSecret  []byte
+	anonymousSecret []byte
+	maximumAnswers  int
+	bot             BotAPI
 	pollsStore      pollCacheInterface
-	store           store
+	store           repository.Storage
 	updatePollCh    chan map[inlineMessageID]*models.UpdatedPoll
 	updateMessageCh tgbot.UpdatesChannel
-	shutdownCh      chan struct{}
+	// drafts is set when the unfinished polls are kept in the storage
+	drafts         expiredDraftsInterface
+	mode           string
+	pollingTimeout time.Duration
+	webhook        WebhookConfig
+	// webhookServer is set when the bot runs in WebhookMode
+	webhookServer   *http.Server
+	workers         int
+	edits           *editQueue
+	shutdownTimeout time.Duration
+	// running is set by Run, Close waits for it to return only then
+	running   int32
+	runDoneCh chan struct{}
+	closeCh   chan struct{}
+	closeOnce *sync.Once
 }
 
-func New(cache rawCacheInterface, store store, token, botName string, userIDs ...int) (*Client, error) {
-	client := &Client{
-		botName: botName,
+// New logs into Telegram with the token of the config
+func New(shared *cache.Shared, store repository.Storage, cfg Config) (*Client, error) {
+	client, err := newClient(shared, store, cfg)
+	if err != nil {
+		return nil, err
+	}
 
-		secureUserIDs: userIDs,
-		pollsStore:    polls_cache.NewPollsStore(cache),
-		store:         store,
-		updatePollCh:  make(chan map[inlineMessageID]*models.UpdatedPoll),
-		shutdownCh:    make(chan struct{}, 1),
+	if client.bot, err = login(cfg.Token, cfg.APIEndpoint); err != nil {
+		return nil, err
 	}
-	if err := client.login(token); err != nil {
+
+	return client, nil
+}
+
+// NewWithBot uses the passed bot instead of logging into Telegram, the token of the config is ignored
+func NewWithBot(bot BotAPI, shared *cache.Shared, store repository.Storage, cfg Config) (*Client, error) {
+	client, err := newClient(shared, store, cfg)
+	if err != nil {
 		return nil, err
 	}
 
+	client.bot = bot
+
 	return client, nil
 }
 
-func (c *Client) Run() error {
-	updateConfig := tgbot.NewUpdate(0)
-	updateConfig.Timeout = 60
+func newClient(shared *cache.Shared, store repository.Storage, cfg Config) (*Client, error) {
+	if cfg.MaximumAnswers == 0 {
+		cfg.MaximumAnswers = defaultMaximumAnswers
+	}
+	if cfg.MaximumAnswers < 0 || cfg.MaximumAnswers > maximumAnswersLimit {
+		return nil, errors.Errorf("maximum answers should be between 1 and %d", maximumAnswersLimit)
+	}
+	if cfg.DraftsTTL == 0 {
+		cfg.DraftsTTL = defaultDraftsTTL
+	}
+	if cfg.DraftsTTL < 0 {
+		return nil, errors.New("drafts ttl should be positive")
+	}
+	if cfg.Workers == 0 {
+		cfg.Workers = defaultWorkers
+	}
+	if cfg.Workers < 0 {
+		return nil, errors.New("workers number should be positive")
+	}
+	if cfg.EditDelay == 0 {
+		cfg.EditDelay = defaultEditDelay
+	}
+	if cfg.EditDelay < 0 {
+		return nil, errors.New("edit delay should be positive")
+	}
+	if cfg.ShutdownTimeout == 0 {
+		cfg.ShutdownTimeout = defaultShutdownTimeout
+	}
+	if cfg.ShutdownTimeout < 0 {
+		return nil, errors.New("shutdown timeout should be positive")
+	}
+	if cfg.PollingTimeout == 0 {
+		cfg.PollingTimeout = defaultPollingTimeout
+	}
+	if cfg.PollingTimeout < time.Second {
+		return nil, errors.New("polling timeout should be at least a second")
+	}
+	switch cfg.Mode {
+	case "":
+		cfg.Mode = PollingMode
+	case PollingMode:
+	case WebhookMode:
+		if err := cfg.Webhook.validate(); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, errors.Errorf("unknown mode '%s'", cfg.Mode)
+	}
+
+	client := &Client{
+		botName: cfg.BotName,
+
+		secureUserIDs:   cfg.UserIDs,
+		callbackSecret:  []byte(cfg.CallbackSecret),
+		anonymousSecret: []byte(cfg.AnonymousSecret),
+		maximumAnswers:  cfg.MaximumAnswers,
+		store:           store,
+		updatePollCh:    make(chan map[inlineMessageID]*models.UpdatedPoll, updatePollBuffer),
+		mode:            cfg.Mode,
+		pollingTimeout:  cfg.PollingTimeout,
+		webhook:         cfg.Webhook,
+		workers:         cfg.Workers,
+		shutdownTimeout: cfg.ShutdownTimeout,
+		runDoneCh:       make(chan struct{}),
+		closeCh:         make(chan struct{}),
+		closeOnce:       new(sync.Once),
+	}
+	// the bot is set after the client is created
+	client.edits = newEditQueue(func(msg tgbot.Chattable) (tgbot.Message, error) {
+		return client.bot.Send(msg)
+	}, cfg.EditDelay)
+	if cfg.DraftsInMemory {
+		drafts := polls_cache.NewPollsStore(shared, cfg.DraftsTTL)
+		drafts.OnEvict(client.draftEvicted)
+		client.pollsStore = drafts
+	} else {
+		drafts := polls_cache.NewDraftsStore(store, cfg.DraftsTTL)
+		client.drafts = drafts
+		client.pollsStore = drafts
+	}
+
+	return client, nil
+}
+
+// Run receives and handles the updates until the context is canceled or the client is closed.
+// Then the updates received already are handled and the pending edits are sent, Run returns
+// when they are finished. When the shutdown timeout passes first, the rest is dropped and ErrShutdownTimeout
+// is returned without waiting for the updates being handled. The client can't be run twice
+func (c *Client) Run(ctx context.Context) error {
+	if !atomic.CompareAndSwapInt32(&c.running, 0, 1) {
+		return errors.New("client is already running")
+	}
+	defer close(c.runDoneCh)
 
 	var err error
-	c.updateMessageCh, err = c.bot.GetUpdatesChan(updateConfig)
+	if c.mode == WebhookMode {
+		c.updateMessageCh, err = c.listenWebhook()
+	} else {
+		c.updateMessageCh, err = c.pollUpdates()
+	}
 	if err != nil {
+		c.closeEdits()
 		return errors.Wrap(err, "get updates failed")
 	}
 
-	go c.updatePollAnswers()
-	c.messageListen()
+	// drain is canceled when the shutdown timeout passes, the updates and the edits left are dropped then
+	drain, cancelDrain := context.WithCancel(context.Background())
+	defer cancelDrain()
 
-	return nil
+	stop := make(chan struct{})
+	go func() {
+		select {
+		case <-ctx.Done():
+		case <-c.closeCh:
+		}
+		close(stop)
+	}()
+
+	editsDone := make(chan struct{})
+	go func() {
+		c.updatePollAnswers()
+		close(editsDone)
+	}()
+	schedulerDone := make(chan struct{})
+	go func() {
+		c.closeExpiredPolls(stop)
+		close(schedulerDone)
+	}()
+	c.edits.start()
+
+	finished := make(chan struct{})
+	go func() {
+		c.messageListen(drain, stop)
+		// the workers are the only senders of the poll updates, so the channel is closed after them
+		close(c.updatePollCh)
+		<-editsDone
+		<-schedulerDone
+		c.edits.close(drain)
+		close(finished)
+	}()
+
+	<-stop
+	log.Printf("Shutting down, waiting up to %s for the updates and the edits...", c.shutdownTimeout)
+
+	timer := time.NewTimer(c.shutdownTimeout)
+	defer timer.Stop()
+
+	select {
+	case <-finished:
+		return nil
+	case <-timer.C:
+		return errors.Wrapf(ErrShutdownTimeout, "the remaining updates and edits are dropped after %s", c.shutdownTimeout)
+	}
 }
 
-func (c *Client) login(token string) error {
-	var err error
-	c.bot, err = tgbot.NewBotAPI(token)
+// closeEdits sends the pending edits when the client doesn't run
+func (c *Client) closeEdits() {
+	ctx, cancel := context.WithTimeout(context.Background(), c.shutdownTimeout)
+	defer cancel()
+
+	c.edits.close(ctx)
+}
+
+// stopReceiving stops the long polling or the webhook, the updates received already stay in the channel
+func (c *Client) stopReceiving() {
+	if c.webhookServer != nil {
+		c.stopWebhook()
+		return
+	}
+
+	c.bot.StopReceivingUpdates()
+}
+
+func login(token, endpoint string) (*tgbot.BotAPI, error) {
+	httpClient := &http.Client{}
+	if endpoint != "" {
+		var err error
+		if httpClient, err = newEndpointClient(endpoint); err != nil {
+			return nil, err
+		}
+	}
+
+	bot, err := tgbot.NewBotAPIWithClient(token, httpClient)
 	if err != nil {
-		return errors.Wrap(err, "telegram bot initialization failed")
+		return nil, errors.Wrap(err, "telegram bot initialization failed")
 	}
 
-	c.bot.Debug = isDebug
-	log.Printf("Authorized on account %s", c.bot.Self.UserName)
+	bot.Debug = isDebug
+	log.Printf("Authorized on account %s", bot.Self.UserName)
 
-	return nil
+	return bot, nil
 }
 
 func (c *Client) updatePollAnswers() {
 	for update := range c.updatePollCh {
 		for inlineMessageID, updatedPoll := range update {
-			var votes string
-			for k, values := range updatedPoll.Poll.Votes {
-				votes += "\n- " + k + ":\n"
-				for _, v := range values {
-					votes += "\t\t\t\t" + v + "\n"
-				}
+			if updatedPoll.Deleted {
+				c.editMessages(deletedPollVersion, tgbot.EditMessageTextConfig{
+					BaseEdit: tgbot.BaseEdit{
+						InlineMessageID: string(inlineMessageID),
+					},
+					Text:      deletedPollText(updatedPoll.Poll),
+					ParseMode: string(parseMode),
+				})
+				continue
 			}
 
-			editMsg := tgbot.EditMessageTextConfig{
+			c.editMessages(updatedPoll.Poll.Version, tgbot.EditMessageTextConfig{
 				BaseEdit: tgbot.BaseEdit{
 					InlineMessageID: string(inlineMessageID),
-					ReplyMarkup:     preparePollKeyboardMarkup(updatedPoll.Poll),
+					ReplyMarkup:     preparePollKeyboardMarkup(updatedPoll.Poll, c.callbackSecret),
 				},
-				Text:      fmt.Sprintf("%s\n---\nLast Vote: %s\nVotes: \n```%s```", escapeURLMarkdownSymbols(updatedPoll.Poll.Subject), updatedPoll.Voter, votes),
+				Text:      pollResultsText(updatedPoll.Poll, updatedPoll.Voter),
 				ParseMode: string(parseMode),
-			}
-
-			if _, err := c.bot.Send(editMsg); err != nil {
-				log.Printf("update message error: %s", err)
-			}
+			})
 		}
 	}
 }
 
-func (c *Client) messageListen() {
+// closeExpiredPolls closes the polls with the passed close time and posts their final results
+func (c *Client) closeExpiredPolls(stop <-chan struct{}) {
+	ticker := time.NewTicker(closePollsInterval)
+	defer ticker.Stop()
+
 	for {
 		select {
-		case <-c.shutdownCh:
+		case <-stop:
 			return
-		case update := <-c.updateMessageCh:
-			if update.CallbackQuery != nil {
-				if err := c.processPollAnswer(update.CallbackQuery); err != nil {
-					log.Printf("prccess callback error: %s", err)
-					continue
-				}
-			}
+		case now := <-ticker.C:
+			c.deleteExpiredDrafts(now)
 
-			if update.InlineQuery != nil {
-				if !c.userHasAccess(update.InlineQuery.From.ID) {
-					if _, err := c.bot.Send(tgbot.NewMessage(int64(update.InlineQuery.From.ID), msgYouHaveNoAccess(int64(update.InlineQuery.From.ID)))); err != nil {
-						log.Printf("failed to send ID to blocked user: %s", err)
-					}
-					continue
-				}
+			polls, err := c.store.GetExpiredPolls(now)
+			if err != nil {
+				log.Printf("get expired polls error: %s", err)
+				continue
+			}
 
-				if err := c.postPoll(update.InlineQuery); err != nil {
-					log.Printf("proccess inline query failed: %s", err)
+			for _, poll := range polls {
+				if err := c.closePoll(poll.ID); err != nil {
+					log.Printf("close poll '%s' error: %s", poll.ID, err)
 				}
 			}
+		}
+	}
+}
 
-			if update.Message == nil {
-				continue
-			}
+func (c *Client) deleteExpiredDrafts(now time.Time) {
+	if c.drafts == nil {
+		return
+	}
 
-			if update.Message.NewChatMembers != nil || update.Message.LeftChatMember != nil || strings.TrimSpace(update.Message.Text) == "" {
-				continue
+	for userID, draft := range c.drafts.DeleteExpired(now) {
+		c.notifyDraftLost(userID, draft, draftExpiredText)
+	}
+}
+
+// draftEvicted is called by the cache when a draft kept in memory expires or is evicted
+func (c *Client) draftEvicted(userID models.UserID, draft *models.Poll, reason cache.EvictionReason) {
+	log.Printf("draft of %d is %s", userID, reason)
+
+	text := draftExpiredText
+	if reason == cache.Evicted {
+		text = draftEvictedText
+	}
+	c.notifyDraftLost(userID, draft, text)
+}
+
+// notifyDraftLost tells the user that the unfinished poll is lost in the chat it was made in.
+// The drafts saved before the chat was kept are made in the private chats, so the user ID is the chat ID
+func (c *Client) notifyDraftLost(userID models.UserID, draft *models.Poll, text string) {
+	chatID := int64(userID)
+	if draft != nil && draft.ChatID != 0 {
+		chatID = draft.ChatID
+	}
+
+	if _, err := c.bot.Send(tgbot.NewMessage(chatID, text)); err != nil {
+		log.Printf("notify about lost draft of %d error: %s", userID, err)
+	}
+}
+
+func (c *Client) closePoll(pollID string) error {
+	poll, err := c.store.ClosePoll(pollID)
+	if err != nil {
+		if errors.Cause(err) == repository.ErrPollIsClosed {
+			// closed by another instance of the bot
+			return nil
+		}
+
+		return errors.Wrap(err, "close poll failed")
+	}
+
+	edits := make([]tgbot.EditMessageTextConfig, len(poll.InlineMessageIDs))
+	for i, id := range poll.InlineMessageIDs {
+		edits[i] = tgbot.EditMessageTextConfig{
+			BaseEdit: tgbot.BaseEdit{
+				InlineMessageID: id,
+			},
+			Text:      pollResultsText(poll, ""),
+			ParseMode: string(parseMode),
+		}
+	}
+	c.editMessages(poll.Version, edits...)
+
+	return nil
+}
+
+// HandleUpdate dispatches an update received either by the long polling or by the webhook,
+// it could be used to inject the updates from another source. It must be called only while Run is running:
+// the poll updates are passed to the Run loop, so a vote blocks until Run takes it and hangs forever without Run
+func (c *Client) HandleUpdate(update tgbot.Update) {
+	if update.CallbackQuery != nil {
+		if isActionData(update.CallbackQuery.Data) {
+			if err := c.processAction(update.CallbackQuery); err != nil {
+				log.Printf("process action error: %s", err)
 			}
+			return
+		}
 
-			if update.Message.Chat != nil && !c.userHasAccess(update.Message.From.ID) {
-				if _, err := c.bot.Send(tgbot.NewMessage(update.Message.Chat.ID, msgYouHaveNoAccess(update.Message.Chat.ID))); err != nil {
-					log.Printf("send message failed for blocked user: %s", err)
-				}
-				continue
+		if err := c.processPollAnswer(update.CallbackQuery); err != nil {
+			log.Printf("prccess callback error: %s", err)
+			return
+		}
+	}
+
+	if update.ChosenInlineResult != nil {
+		if err := c.rememberInlineMessage(update.ChosenInlineResult); err != nil {
+			log.Printf("process chosen inline result error: %s", err)
+		}
+		return
+	}
+
+	if update.InlineQuery != nil {
+		if !c.userHasAccess(update.InlineQuery.From.ID) {
+			if _, err := c.bot.Send(tgbot.NewMessage(int64(update.InlineQuery.From.ID), msgYouHaveNoAccess(int64(update.InlineQuery.From.ID)))); err != nil {
+				log.Printf("failed to send ID to blocked user: %s", err)
 			}
+			return
+		}
 
-			if update.Message.IsCommand() {
-				switch strings.ToLower(update.Message.Command()) {
-				case "help":
-					if err := c.cmdHelp(update.Message.Chat.ID); err != nil {
-						log.Printf("command help: %s\n", err)
-					}
-				case "cancel":
-					if err := c.cmdCancel(update.Message.Chat.ID, update.Message.From.ID); err != nil {
-						log.Printf("command cancel: %s\n", err)
-					}
-				case "done":
-					if err := c.cmdDone(update.Message.Chat.ID, update.Message.From.ID); err != nil {
-						log.Printf("command done: %s\n", err)
-					}
-				case "newpoll":
-					if err := c.cmdNewPoll(update.Message.Chat.ID, update.Message.From.ID, update.Message.From.String()); err != nil {
-						log.Printf("command newpoll: %s\n", err)
-					}
-				default:
-					msg := tgbot.NewMessage(update.Message.Chat.ID, "Bad command")
-					if _, err := c.bot.Send(msg); err != nil {
-						log.Println("send message error")
-					}
-				}
-				continue
+		if err := c.postPoll(update.InlineQuery); err != nil {
+			log.Printf("proccess inline query failed: %s", err)
+		}
+	}
+
+	if update.Message == nil {
+		return
+	}
+
+	if update.Message.NewChatMembers != nil || update.Message.LeftChatMember != nil || strings.TrimSpace(update.Message.Text) == "" {
+		return
+	}
+
+	if update.Message.Chat != nil && !c.userHasAccess(update.Message.From.ID) {
+		if _, err := c.bot.Send(tgbot.NewMessage(update.Message.Chat.ID, msgYouHaveNoAccess(update.Message.Chat.ID))); err != nil {
+			log.Printf("send message failed for blocked user: %s", err)
+		}
+		return
+	}
+
+	if update.Message.IsCommand() {
+		switch strings.ToLower(update.Message.Command()) {
+		case "help":
+			if err := c.cmdHelp(update.Message.Chat.ID); err != nil {
+				log.Printf("command help: %s\n", err)
+			}
+		case "cancel":
+			if err := c.cmdCancel(update.Message.Chat.ID, update.Message.From.ID); err != nil {
+				log.Printf("command cancel: %s\n", err)
+			}
+		case "done":
+			if err := c.cmdDone(update.Message.Chat.ID, update.Message.From.ID); err != nil {
+				log.Printf("command done: %s\n", err)
+			}
+		case "multiple":
+			if err := c.cmdMultiple(update.Message.Chat.ID, update.Message.From.ID); err != nil {
+				log.Printf("command multiple: %s\n", err)
+			}
+		case "deadline":
+			if err := c.cmdDeadline(update.Message.Chat.ID, update.Message.From.ID, update.Message.CommandArguments()); err != nil {
+				log.Printf("command deadline: %s\n", err)
+			}
+		case "close":
+			if err := c.cmdClose(update.Message.Chat.ID, update.Message.From.ID, update.Message.From.String(), update.Message.CommandArguments(), true); err != nil {
+				log.Printf("command close: %s\n", err)
+			}
+		case "reopen":
+			if err := c.cmdClose(update.Message.Chat.ID, update.Message.From.ID, update.Message.From.String(), update.Message.CommandArguments(), false); err != nil {
+				log.Printf("command reopen: %s\n", err)
+			}
+		case "deletepoll":
+			if err := c.cmdDeletePoll(update.Message.Chat, update.Message.From.ID, update.Message.From.String(), update.Message.CommandArguments()); err != nil {
+				log.Printf("command deletepoll: %s\n", err)
+			}
+		case "editpoll":
+			if err := c.cmdEditPoll(update.Message.Chat.ID, update.Message.From.ID, update.Message.From.String(), update.Message.CommandArguments()); err != nil {
+				log.Printf("command editpoll: %s\n", err)
+			}
+		case editAdd, editRemove, editRename:
+			if err := c.cmdEditItems(update.Message.Chat.ID, update.Message.From.ID, strings.ToLower(update.Message.Command()), update.Message.CommandArguments()); err != nil {
+				log.Printf("command %s: %s\n", update.Message.Command(), err)
 			}
+		case "mypolls":
+			if err := c.cmdMyPolls(update.Message.Chat, update.Message.From.ID, update.Message.From.String()); err != nil {
+				log.Printf("command mypolls: %s\n", err)
+			}
+		case "anonymous":
+			if err := c.cmdAnonymous(update.Message.Chat.ID, update.Message.From.ID); err != nil {
+				log.Printf("command anonymous: %s\n", err)
+			}
+		case "newpoll":
+			if err := c.cmdNewPoll(update.Message.Chat.ID, update.Message.From.ID, update.Message.From.String()); err != nil {
+				log.Printf("command newpoll: %s\n", err)
+			}
+		default:
+			msg := tgbot.NewMessage(update.Message.Chat.ID, "Bad command")
+			if _, err := c.bot.Send(msg); err != nil {
+				log.Println("send message error")
+			}
+		}
+		return
+	}
 
-			log.Printf("MESSAGE %+v", update.Message)
-			if preStoredPoll := c.pollsStore.Load(models.UserID(update.Message.From.ID)); preStoredPoll != nil {
-				if err := c.createOrCompletePoll(update, preStoredPoll); err != nil {
-					log.Printf("create or comple a poll error: %s", err)
-				}
+	log.Printf("MESSAGE %+v", update.Message)
+	if preStoredPoll := c.pollsStore.Load(models.UserID(update.Message.From.ID)); preStoredPoll != nil {
+		// a plain message adds an item to the poll which is being edited
+		if preStoredPoll.EditPollID != "" {
+			if err := c.cmdEditItems(update.Message.Chat.ID, update.Message.From.ID, editAdd, update.Message.Text); err != nil {
+				log.Printf("edit a poll error: %s", err)
 			}
+			return
+		}
+
+		if err := c.createOrCompletePoll(update, preStoredPoll); err != nil {
+			log.Printf("create or comple a poll error: %s", err)
 		}
 	}
 }
@@ -239,7 +628,7 @@ func (c Client) postPoll(inline *tgbot.InlineQuery) error {
 	}
 
 	resultArticlesMarkdown := []interface{}{
-		preparePollArticle(poll),
+		preparePollArticle(poll, c.callbackSecret),
 	}
 
 	inlineConfig := tgbot.InlineConfig{
@@ -258,21 +647,119 @@ func (c Client) postPoll(inline *tgbot.InlineQuery) error {
 }
 
 func (c Client) processPollAnswer(callback *tgbot.CallbackQuery) error {
-	callbackData, err := serializeCallbackData(callback.Data)
+	callbackData, err := serializeCallbackData(callback.Data, c.callbackSecret)
 	if err != nil {
 		return errors.Wrap(err, "get callback data error")
 	}
 
-	poll, err := c.store.UpdateVote(callbackData.CreatedAt, callbackData.Vote, callback.From.String())
+	poll, vote, err := c.resolveVote(callbackData)
+	if errors.Cause(err) == repository.ErrPollIsNotFound {
+		return c.rejectDeletedPollVote(callback)
+	}
+	if err == errStaleItems {
+		return c.rejectStaleVote(callback, poll)
+	}
+	if err != nil {
+		return errors.Wrap(err, "resolve poll failed")
+	}
+
+	isNewMessage := callback.InlineMessageID != "" && !poll.HasInlineMessage(callback.InlineMessageID)
+	if isNewMessage {
+		if err := c.store.AddInlineMessage(poll.ID, callback.InlineMessageID); err != nil {
+			log.Printf("add inline message error: %s", err)
+		}
+	}
+
+	closedPoll := poll
+	voter := voterKey(poll, callback.From, c.anonymousSecret)
+	poll, err = c.store.UpdateVote(poll.ID, vote, voter)
+	if errors.Cause(err) == repository.ErrPollIsClosed {
+		if err := c.answerCallback(callback.ID, "The poll is closed"); err != nil {
+			return err
+		}
+
+		// the final results were posted before the message became known, the keyboard is removed here
+		if isNewMessage && closedPoll.ClosedAt != 0 {
+			c.updatePollCh <- pollUpdate(closedPoll, "", callback.InlineMessageID)
+		}
+
+		return nil
+	}
+	if errors.Cause(err) == repository.ErrPollIsNotFound {
+		return c.rejectDeletedPollVote(callback)
+	}
 	if err != nil {
+		// the callback is answered, so the client doesn't wait for it and the voter could click again
+		if err := c.answerCallback(callback.ID, "The vote isn't saved, please try again"); err != nil {
+			log.Printf("answer failed vote error: %s", err)
+		}
+
 		return errors.Wrap(err, "update vote failed")
 	}
 
 	log.Printf("POLL: %+v", poll)
 
+	if err := c.answerCallback(callback.ID, voteAnswerText(poll, vote, voter)); err != nil {
+		return err
+	}
+
+	var lastVoter string
+	if !poll.Anonymous {
+		lastVoter = callback.From.String()
+	}
+	c.updatePollCh <- pollUpdate(poll, lastVoter, callback.InlineMessageID)
+
+	return nil
+}
+
+// rejectDeletedPollVote answers a click on a poll which doesn't exist anymore and removes the buttons of the message
+func (c Client) rejectDeletedPollVote(callback *tgbot.CallbackQuery) error {
+	if err := c.answerCallback(callback.ID, "The poll was deleted"); err != nil {
+		return err
+	}
+
+	if callback.InlineMessageID != "" {
+		c.updatePollCh <- map[inlineMessageID]*models.UpdatedPoll{
+			inlineMessageID(callback.InlineMessageID): {Poll: &domain.Poll{}, Deleted: true},
+		}
+	}
+
+	return nil
+}
+
+// pollUpdate re-renders every copy of the poll, the extra message IDs could be not stored in the poll yet
+func pollUpdate(poll *domain.Poll, lastVoter string, extraInlineMessageIDs ...string) map[inlineMessageID]*models.UpdatedPoll {
+	updatedPoll := &models.UpdatedPoll{Voter: lastVoter, Poll: poll}
+	update := make(map[inlineMessageID]*models.UpdatedPoll)
+	for _, ids := range [][]string{poll.InlineMessageIDs, extraInlineMessageIDs} {
+		for _, id := range ids {
+			if id != "" {
+				update[inlineMessageID(id)] = updatedPoll
+			}
+		}
+	}
+
+	return update
+}
+
+// rememberInlineMessage records the message the poll has been posted into by a user with access, the result ID is the poll ID.
+// Telegram sends the chosen results only if the inline feedback is enabled with @BotFather
+func (c Client) rememberInlineMessage(result *tgbot.ChosenInlineResult) error {
+	if result.InlineMessageID == "" || result.From == nil || !c.userHasAccess(result.From.ID) {
+		return nil
+	}
+
+	if err := c.store.AddInlineMessage(result.ResultID, result.InlineMessageID); err != nil {
+		return errors.Wrap(err, "add inline message failed")
+	}
+
+	return nil
+}
+
+func (c Client) answerCallback(callbackQueryID, text string) error {
 	callbackConfig := tgbot.CallbackConfig{
-		CallbackQueryID: callback.ID,
-		Text:            fmt.Sprintf("Vote '%s' accepted", callbackData.Vote),
+		CallbackQueryID: callbackQueryID,
+		Text:            text,
 		ShowAlert:       false,
 		URL:             "",
 		CacheTime:       0,
@@ -282,28 +769,74 @@ func (c Client) processPollAnswer(callback *tgbot.CallbackQuery) error {
 		return errors.Wrap(err, "answer callback error")
 	}
 
-	c.updatePollCh <- map[inlineMessageID]*models.UpdatedPoll{
-		inlineMessageID(callback.InlineMessageID): {
-			Voter: callback.From.String(),
-			Poll:  poll,
-		},
+	return nil
+}
+
+// rejectStaleVote answers a click on a button posted before the items were edited and shows the current items
+func (c Client) rejectStaleVote(callback *tgbot.CallbackQuery, poll *domain.Poll) error {
+	if err := c.answerCallback(callback.ID, "The poll items were changed, please vote again"); err != nil {
+		return err
+	}
+
+	if callback.InlineMessageID != "" {
+		c.updatePollCh <- pollUpdate(poll, "", callback.InlineMessageID)
 	}
 
 	return nil
 }
 
+// resolveVote returns the poll and the answer text, it supports buttons of the messages posted by the previous versions.
+// The index of a button posted before the items were edited could point to another item, errStaleItems is returned with the poll then
+func (c Client) resolveVote(callbackData *models.CallbackData) (*domain.Poll, string, error) {
+	if callbackData.CreatedAt != 0 {
+		poll, err := c.store.GetPollByCreatedAt(callbackData.CreatedAt)
+		if err != nil {
+			return nil, "", err
+		}
+
+		// the legacy data isn't signed, so the vote could be anything
+		for _, item := range poll.Items {
+			if item == callbackData.Vote {
+				return poll, item, nil
+			}
+		}
+
+		return nil, "", errors.Errorf("poll '%s' has no item '%s'", poll.ID, callbackData.Vote)
+	}
+
+	poll, err := c.store.GetPollByID(callbackData.ID)
+	if err != nil {
+		return nil, "", err
+	}
+
+	if callbackData.ItemsRevision != poll.ItemsRevision {
+		return poll, "", errStaleItems
+	}
+
+	if callbackData.Item >= len(poll.Items) {
+		return nil, "", errors.Errorf("poll '%s' has no item %d", poll.ID, callbackData.Item)
+	}
+
+	return poll, poll.Items[callbackData.Item], nil
+}
+
 func (c Client) createOrCompletePoll(update tgbot.Update, preStoredPoll *models.Poll) error {
 	if preStoredPoll.PollName == "" {
-		c.pollsStore.Store(models.UserID(update.Message.From.ID), &models.Poll{PollName: update.Message.Text, Items: []string{}, Owner: getOwner(update.Message.From.ID, update.Message.From.String())})
-		if _, err := c.bot.Send(tgbot.NewMessage(update.Message.Chat.ID, "put items")); err != nil {
+		preStoredPoll.PollName = update.Message.Text
+		preStoredPoll.Items = []string{}
+		preStoredPoll.Owner = getOwner(update.Message.From.ID, update.Message.From.String())
+		c.pollsStore.Store(models.UserID(update.Message.From.ID), preStoredPoll)
+		msg := tgbot.NewMessage(update.Message.Chat.ID, "- put items;\n- `/multiple` - to allow choosing several items;\n- `/anonymous` - to hide the voters;\n- `/deadline in 2h` or `/deadline 2020-01-02 15:04` - to close the poll at the time (UTC)")
+		msg.ParseMode = string(parseMode)
+		if _, err := c.bot.Send(msg); err != nil {
 			return errors.Wrap(err, sendMessageErrorString)
 		}
 
 		return nil
 	}
 
-	if len(preStoredPoll.Items) == maximumAnswers {
-		msg := tgbot.NewMessage(update.Message.Chat.ID, "Maximum 3 items could be placed! Use:\n- `/done` - to complete the poll creation;\n- `/cancel` - to cancel the poll creation")
+	if len(preStoredPoll.Items) >= c.maximumAnswers {
+		msg := tgbot.NewMessage(update.Message.Chat.ID, fmt.Sprintf("Maximum %d items could be placed! Use:\n- `/done` - to complete the poll creation;\n- `/cancel` - to cancel the poll creation", c.maximumAnswers))
 		msg.ParseMode = string(parseMode)
 		if _, err := c.bot.Send(msg); err != nil {
 			return errors.Wrap(err, sendMessageErrorString)
@@ -313,7 +846,8 @@ func (c Client) createOrCompletePoll(update tgbot.Update, preStoredPoll *models.
 	}
 
 	preStoredPoll.Items = append(preStoredPoll.Items, update.Message.Text)
-	c.pollsStore.Store(models.UserID(update.Message.From.ID), &models.Poll{PollName: preStoredPoll.PollName, Items: preStoredPoll.Items, Owner: getOwner(update.Message.From.ID, update.Message.From.String())})
+	preStoredPoll.Owner = getOwner(update.Message.From.ID, update.Message.From.String())
+	c.pollsStore.Store(models.UserID(update.Message.From.ID), preStoredPoll)
 	msg := tgbot.NewMessage(update.Message.Chat.ID, "- put items;\n- `/done` - to complete the poll creation;\n- `/cancel` - to cancel the poll creation")
 	msg.ParseMode = string(parseMode)
 	if _, err := c.bot.Send(msg); err != nil {
@@ -323,11 +857,16 @@ func (c Client) createOrCompletePoll(update tgbot.Update, preStoredPoll *models.
 	return nil
 }
 
+// Close stops Run and waits until it returns
 func (c *Client) Close() error {
-	c.shutdownCh <- struct{}{}
-	c.bot.StopReceivingUpdates()
-	close(c.updatePollCh)
-	close(c.shutdownCh)
+	c.closeOnce.Do(func() { close(c.closeCh) })
+
+	if atomic.LoadInt32(&c.running) == 1 {
+		<-c.runDoneCh
+		return nil
+	}
+
+	c.closeEdits()
 
 	return nil
 }
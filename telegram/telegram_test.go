@@ -0,0 +1,633 @@
+package telegram
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	tgbot "github.com/go-telegram-bot-api/telegram-bot-api"
+	"github.com/incu6us/vote-bot/cache"
+	"github.com/incu6us/vote-bot/domain"
+	"github.com/incu6us/vote-bot/repository"
+	"github.com/incu6us/vote-bot/telegram/models"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const (
+	testUserID     = 1001
+	testStranger   = 2002
+	testSecret     = "secret"
+	testAnonSecret = "anonymous-secret"
+	testPollName   = "Lunch place"
+	testOwnerName  = "Test User"
+	testInlineID   = "inline-1"
+	testInlineID2  = "inline-2"
+	testBotName    = "vote_bot"
+	testPollItem   = "Pizza"
+	testPollItem2  = "Sushi"
+	testMissingID  = "missing"
+	testNoKeyboard = " [no keyboard]"
+)
+
+func newTestClient(t *testing.T) (*Client, *fakeBot, repository.Storage) {
+	bot := new(fakeBot)
+	store := repository.NewMemory()
+	client, err := NewWithBot(bot, cache.NewShared(), store, Config{
+		BotName:         testBotName,
+		UserIDs:         []int{testUserID},
+		CallbackSecret:  testSecret,
+		AnonymousSecret: testAnonSecret,
+	})
+	require.NoError(t, err)
+
+	return client, bot, store
+}
+
+// handleUpdates passes the updates to the client and waits until the poll messages are edited,
+// the edits are sent without the delay
+func handleUpdates(c *Client, updates ...tgbot.Update) {
+	done := make(chan struct{})
+	go func() {
+		c.updatePollAnswers()
+		close(done)
+	}()
+
+	for _, update := range updates {
+		c.HandleUpdate(update)
+	}
+
+	close(c.updatePollCh)
+	<-done
+	c.edits.flush(context.Background())
+}
+
+func createTestPoll(t *testing.T, store repository.Storage, inlineMessageIDs ...string) *domain.Poll {
+	require.NoError(t, store.CreatePoll(&domain.Poll{
+		Subject:   testPollName,
+		CreatedBy: getOwner(testUserID, testOwnerName),
+		Items:     []string{testPollItem, testPollItem2},
+	}))
+
+	poll, err := store.GetPoll(testPollName)
+	require.NoError(t, err)
+	for _, id := range inlineMessageIDs {
+		require.NoError(t, store.AddInlineMessage(poll.ID, id))
+	}
+
+	poll, err = store.GetPoll(testPollName)
+	require.NoError(t, err)
+
+	return poll
+}
+
+func TestClient_HandleUpdate(t *testing.T) {
+	tests := []struct {
+		name string
+		// updates returns the updates to handle, the poll is nil unless withPoll is set
+		withPoll            bool
+		updates             func(poll *domain.Poll) []tgbot.Update
+		wantMessages        []string
+		wantCallbackAnswers []string
+		wantEdits           map[string]string
+		check               func(t *testing.T, bot *fakeBot, store repository.Storage)
+	}{
+		{
+			name: "help",
+			updates: func(*domain.Poll) []tgbot.Update {
+				return []tgbot.Update{textUpdate(testUserID, "/help")}
+			},
+			wantMessages: []string{"use this command for help"},
+		},
+		{
+			name: "unknown command",
+			updates: func(*domain.Poll) []tgbot.Update {
+				return []tgbot.Update{textUpdate(testUserID, "/unknown")}
+			},
+			wantMessages: []string{"Bad command"},
+		},
+		{
+			name: "user without access",
+			updates: func(*domain.Poll) []tgbot.Update {
+				return []tgbot.Update{textUpdate(testStranger, "/newpoll")}
+			},
+			wantMessages: []string{msgYouHaveNoAccess(testStranger)},
+		},
+		{
+			name: "create poll",
+			updates: func(*domain.Poll) []tgbot.Update {
+				return []tgbot.Update{
+					textUpdate(testUserID, "/newpoll"),
+					textUpdate(testUserID, testPollName),
+					textUpdate(testUserID, testPollItem),
+					textUpdate(testUserID, testPollItem2),
+					textUpdate(testUserID, "/multiple"),
+					textUpdate(testUserID, "/done"),
+				}
+			},
+			wantMessages: []string{
+				"Enter a poll name",
+				"- put items;\n- `/multiple` - to allow choosing several items;\n- `/anonymous` - to hide the voters;\n- `/deadline in 2h` or `/deadline 2020-01-02 15:04` - to close the poll at the time (UTC)",
+				"- put items;\n- `/done` - to complete the poll creation;\n- `/cancel` - to cancel the poll creation",
+				"- put items;\n- `/done` - to complete the poll creation;\n- `/cancel` - to cancel the poll creation",
+				"Voters could choose several items",
+				"Use `share button` or put the next lines into your group: `@" + testBotName + " " + testPollName + "`",
+			},
+			check: func(t *testing.T, _ *fakeBot, store repository.Storage) {
+				poll, err := store.GetPoll(testPollName)
+				require.NoError(t, err)
+				assert.Equal(t, []string{testPollItem, testPollItem2}, poll.Items)
+				assert.Equal(t, getOwner(testUserID, testOwnerName), poll.CreatedBy)
+				assert.True(t, poll.MultipleChoice)
+			},
+		},
+		{
+			name: "cancel poll creation",
+			updates: func(*domain.Poll) []tgbot.Update {
+				return []tgbot.Update{
+					textUpdate(testUserID, "/newpoll"),
+					textUpdate(testUserID, testPollName),
+					textUpdate(testUserID, "/cancel"),
+					textUpdate(testUserID, "/done"),
+				}
+			},
+			wantMessages: []string{
+				"Enter a poll name",
+				"- put items;\n- `/multiple` - to allow choosing several items;\n- `/anonymous` - to hide the voters;\n- `/deadline in 2h` or `/deadline 2020-01-02 15:04` - to close the poll at the time (UTC)",
+				"Canceled",
+				"No such poll",
+			},
+			check: func(t *testing.T, _ *fakeBot, store repository.Storage) {
+				_, err := store.GetPoll(testPollName)
+				assert.Equal(t, repository.ErrPollIsNotFound, err)
+			},
+		},
+		{
+			name:     "inline query",
+			withPoll: true,
+			updates: func(*domain.Poll) []tgbot.Update {
+				return []tgbot.Update{inlineQueryUpdate(testUserID, "lunch")}
+			},
+			check: func(t *testing.T, bot *fakeBot, store repository.Storage) {
+				poll, err := store.GetPoll(testPollName)
+				require.NoError(t, err)
+				require.Len(t, bot.inlineAnswers, 1)
+				assert.Equal(t, "query-lunch", bot.inlineAnswers[0].InlineQueryID)
+				assert.Equal(t, []interface{}{preparePollArticle(poll, []byte(testSecret))}, bot.inlineAnswers[0].Results)
+			},
+		},
+		{
+			name:     "short inline query",
+			withPoll: true,
+			updates: func(*domain.Poll) []tgbot.Update {
+				return []tgbot.Update{inlineQueryUpdate(testUserID, "lun")}
+			},
+			check: func(t *testing.T, bot *fakeBot, _ repository.Storage) {
+				assert.Empty(t, bot.inlineAnswers)
+			},
+		},
+		{
+			name:     "chosen inline result",
+			withPoll: true,
+			updates: func(poll *domain.Poll) []tgbot.Update {
+				return []tgbot.Update{chosenResultUpdate(testUserID, poll.ID, testInlineID2)}
+			},
+			check: func(t *testing.T, _ *fakeBot, store repository.Storage) {
+				poll, err := store.GetPoll(testPollName)
+				require.NoError(t, err)
+				assert.Equal(t, []string{testInlineID, testInlineID2}, poll.InlineMessageIDs)
+			},
+		},
+		{
+			name:     "chosen inline result of user without access",
+			withPoll: true,
+			updates: func(poll *domain.Poll) []tgbot.Update {
+				return []tgbot.Update{chosenResultUpdate(testStranger, poll.ID, testInlineID2)}
+			},
+			check: func(t *testing.T, _ *fakeBot, store repository.Storage) {
+				poll, err := store.GetPoll(testPollName)
+				require.NoError(t, err)
+				assert.Equal(t, []string{testInlineID}, poll.InlineMessageIDs)
+			},
+		},
+		{
+			name:     "vote",
+			withPoll: true,
+			updates: func(poll *domain.Poll) []tgbot.Update {
+				return []tgbot.Update{callbackUpdate(testStranger, testInlineID2, prepareCallbackData(poll.ID, 1, 0, []byte(testSecret)))}
+			},
+			wantCallbackAnswers: []string{"Vote 'Sushi' accepted"},
+			wantEdits: map[string]string{
+				testInlineID:  "Lunch place\n---\nLast Vote: Test User\nVotes: \n```\n- Sushi:\n\t\t\t\tTest User\n```",
+				testInlineID2: "Lunch place\n---\nLast Vote: Test User\nVotes: \n```\n- Sushi:\n\t\t\t\tTest User\n```",
+			},
+			check: func(t *testing.T, _ *fakeBot, store repository.Storage) {
+				poll, err := store.GetPoll(testPollName)
+				require.NoError(t, err)
+				assert.Equal(t, map[string][]string{testPollItem2: {"Test User"}}, poll.Votes)
+				assert.Equal(t, []string{testInlineID, testInlineID2}, poll.InlineMessageIDs)
+			},
+		},
+		{
+			name:     "vote with bad signature",
+			withPoll: true,
+			updates: func(poll *domain.Poll) []tgbot.Update {
+				return []tgbot.Update{callbackUpdate(testStranger, testInlineID, prepareCallbackData(poll.ID, 1, 0, []byte("other")))}
+			},
+			check: func(t *testing.T, bot *fakeBot, store repository.Storage) {
+				poll, err := store.GetPoll(testPollName)
+				require.NoError(t, err)
+				assert.Empty(t, poll.Votes)
+				assert.Empty(t, bot.edits)
+			},
+		},
+		{
+			name:     "legacy vote",
+			withPoll: true,
+			updates: func(poll *domain.Poll) []tgbot.Update {
+				data := fmt.Sprintf(`{"created_at":%d,"vote":%q}`, poll.CreatedAt, testPollItem)
+				return []tgbot.Update{callbackUpdate(testStranger, testInlineID, data)}
+			},
+			wantCallbackAnswers: []string{"Vote 'Pizza' accepted"},
+		},
+		{
+			name:     "legacy vote for unknown item",
+			withPoll: true,
+			updates: func(poll *domain.Poll) []tgbot.Update {
+				data := fmt.Sprintf(`{"created_at":%d,"vote":"anything"}`, poll.CreatedAt)
+				return []tgbot.Update{callbackUpdate(testStranger, testInlineID, data)}
+			},
+			check: func(t *testing.T, bot *fakeBot, store repository.Storage) {
+				poll, err := store.GetPoll(testPollName)
+				require.NoError(t, err)
+				assert.Empty(t, poll.Votes)
+			},
+		},
+		{
+			name: "vote for deleted poll",
+			updates: func(*domain.Poll) []tgbot.Update {
+				return []tgbot.Update{callbackUpdate(testStranger, testInlineID, prepareCallbackData(testMissingID, 0, 0, []byte(testSecret)))}
+			},
+			wantCallbackAnswers: []string{"The poll was deleted"},
+			wantEdits:           map[string]string{testInlineID: "The poll was deleted" + testNoKeyboard},
+		},
+		{
+			name:     "close poll",
+			withPoll: true,
+			updates: func(*domain.Poll) []tgbot.Update {
+				return []tgbot.Update{
+					textUpdate(testUserID, "/close "+testPollName),
+					textUpdate(testUserID, "/close "+testPollName),
+				}
+			},
+			wantMessages: []string{"The poll is closed", "The poll is closed already"},
+			wantEdits: map[string]string{
+				testInlineID: "Lunch place\n---\nClosed, final results\nVotes: \n``````" + testNoKeyboard,
+			},
+		},
+		{
+			name:     "my polls",
+			withPoll: true,
+			updates: func(*domain.Poll) []tgbot.Update {
+				return []tgbot.Update{textUpdate(testUserID, "/mypolls")}
+			},
+			wantMessages: []string{"Your polls, page 1 of 1:\n\n1. Lunch place - open, voters: 0"},
+		},
+		{
+			name:     "my polls in a group",
+			withPoll: true,
+			updates: func(*domain.Poll) []tgbot.Update {
+				update := textUpdate(testUserID, "/mypolls")
+				update.Message.Chat = &tgbot.Chat{ID: -100, Type: "group"}
+				return []tgbot.Update{update}
+			},
+			wantMessages: []string{privateChatOnlyText},
+		},
+		{
+			name:     "delete poll in a group",
+			withPoll: true,
+			updates: func(*domain.Poll) []tgbot.Update {
+				update := textUpdate(testUserID, "/deletepoll "+testPollName)
+				update.Message.Chat = &tgbot.Chat{ID: -100, Type: "group"}
+				return []tgbot.Update{update}
+			},
+			wantMessages: []string{privateChatOnlyText},
+			check: func(t *testing.T, _ *fakeBot, store repository.Storage) {
+				_, err := store.GetPoll(testPollName)
+				assert.NoError(t, err)
+			},
+		},
+		{
+			name:     "poll action in a group",
+			withPoll: true,
+			updates: func(poll *domain.Poll) []tgbot.Update {
+				update := callbackUpdate(testUserID, "", prepareActionData(models.Action{Name: actionClose, PollID: poll.ID}, []byte(testSecret)))
+				update.CallbackQuery.Message = &tgbot.Message{MessageID: 1, Chat: &tgbot.Chat{ID: -100, Type: "group"}}
+				return []tgbot.Update{update}
+			},
+			wantCallbackAnswers: []string{privateChatOnlyText},
+			check: func(t *testing.T, _ *fakeBot, store repository.Storage) {
+				poll, err := store.GetPoll(testPollName)
+				require.NoError(t, err)
+				assert.Zero(t, poll.ClosedAt)
+			},
+		},
+		{
+			name:     "close poll of another user",
+			withPoll: true,
+			updates: func(*domain.Poll) []tgbot.Update {
+				update := textUpdate(testUserID, "/close "+testPollName)
+				update.Message.From.FirstName = "Another"
+				return []tgbot.Update{update}
+			},
+			wantMessages: []string{"No such poll"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client, bot, store := newTestClient(t)
+
+			var poll *domain.Poll
+			if tt.withPoll {
+				poll = createTestPoll(t, store, testInlineID)
+			}
+
+			handleUpdates(client, tt.updates(poll)...)
+
+			assert.Equal(t, tt.wantMessages, nilIfEmpty(bot.messageTexts()))
+			assert.Equal(t, tt.wantCallbackAnswers, nilIfEmpty(bot.callbackTexts()))
+			if tt.wantEdits != nil {
+				assert.Equal(t, tt.wantEdits, bot.editTexts())
+			}
+			if tt.check != nil {
+				tt.check(t, bot, store)
+			}
+		})
+	}
+}
+
+func TestClient_processPollAnswer_staleItems(t *testing.T) {
+	client, bot, store := newTestClient(t)
+	poll := createTestPoll(t, store, testInlineID)
+	data := prepareCallbackData(poll.ID, 1, poll.ItemsRevision, []byte(testSecret))
+
+	// the second button points to another item after the items are swapped
+	_, err := store.UpdatePollItems(testPollName, poll.CreatedBy, []string{testPollItem2, testPollItem},
+		map[string]string{testPollItem: testPollItem, testPollItem2: testPollItem2})
+	require.NoError(t, err)
+
+	handleUpdates(client, callbackUpdate(testStranger, testInlineID, data))
+
+	assert.Equal(t, []string{"The poll items were changed, please vote again"}, bot.callbackTexts())
+	poll, err = store.GetPoll(testPollName)
+	require.NoError(t, err)
+	assert.Empty(t, poll.Votes)
+
+	require.Len(t, bot.edits, 1)
+	markup := bot.edits[0].ReplyMarkup
+	require.NotNil(t, markup)
+	assert.Equal(t, testPollItem2, markup.InlineKeyboard[0][0].Text)
+	assert.Equal(t, prepareCallbackData(poll.ID, 0, poll.ItemsRevision, []byte(testSecret)), *markup.InlineKeyboard[0][0].CallbackData)
+}
+
+// failingVotes is a storage which can't write the votes
+type failingVotes struct {
+	repository.Storage
+}
+
+func (failingVotes) UpdateVote(string, string, string) (*domain.Poll, error) {
+	return nil, errors.New("version conflict")
+}
+
+func TestClient_processPollAnswer_failedVote(t *testing.T) {
+	client, bot, store := newTestClient(t)
+	poll := createTestPoll(t, store, testInlineID)
+	client.store = failingVotes{Storage: store}
+
+	handleUpdates(client, callbackUpdate(testStranger, testInlineID, prepareCallbackData(poll.ID, 1, 0, []byte(testSecret))))
+
+	assert.Equal(t, []string{"The vote isn't saved, please try again"}, bot.callbackTexts())
+	assert.Empty(t, bot.edits)
+}
+
+func nilIfEmpty(texts []string) []string {
+	if len(texts) == 0 {
+		return nil
+	}
+
+	return texts
+}
+
+func newRunningTestClient(t *testing.T, bot *fakeBot, cfg Config) (*Client, repository.Storage, context.CancelFunc, <-chan error) {
+	bot.updates = make(chan tgbot.Update)
+	store := repository.NewMemory()
+	cfg.BotName = testBotName
+	cfg.UserIDs = []int{testUserID}
+	cfg.CallbackSecret = testSecret
+	cfg.AnonymousSecret = testAnonSecret
+	client, err := NewWithBot(bot, cache.NewShared(), store, cfg)
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	runErr := make(chan error, 1)
+	go func() { runErr <- client.Run(ctx) }()
+
+	return client, store, cancel, runErr
+}
+
+func waitRun(t *testing.T, runErr <-chan error) error {
+	select {
+	case err := <-runErr:
+		return err
+	case <-time.After(5 * time.Second):
+		t.Fatal("run doesn't return")
+		return nil
+	}
+}
+
+// TestClient_Run_drain checks that a vote received before the cancellation is handled
+// and its edit is sent without waiting for the edit delay
+func TestClient_Run_drain(t *testing.T) {
+	bot := new(fakeBot)
+	_, store, cancel, runErr := newRunningTestClient(t, bot, Config{EditDelay: time.Hour})
+	defer cancel()
+
+	poll := createTestPoll(t, store, testInlineID)
+	bot.updates <- callbackUpdate(testStranger, testInlineID, prepareCallbackData(poll.ID, 1, 0, []byte(testSecret)))
+	cancel()
+
+	require.NoError(t, waitRun(t, runErr))
+	assert.Equal(t, []string{"Vote 'Sushi' accepted"}, bot.callbackTexts())
+	assert.Equal(t, map[string]string{
+		testInlineID: "Lunch place\n---\nLast Vote: Test User\nVotes: \n```\n- Sushi:\n\t\t\t\tTest User\n```",
+	}, bot.editTexts())
+}
+
+func TestClient_Run_shutdownTimeout(t *testing.T) {
+	release := make(chan struct{})
+	defer close(release)
+
+	sending := make(chan struct{}, 1)
+	bot := &fakeBot{beforeSend: func(tgbot.Chattable) {
+		sending <- struct{}{}
+		<-release
+	}}
+	_, _, cancel, runErr := newRunningTestClient(t, bot, Config{ShutdownTimeout: 50 * time.Millisecond})
+	defer cancel()
+
+	bot.updates <- textUpdate(testUserID, "/help")
+	<-sending
+	cancel()
+
+	assert.Equal(t, ErrShutdownTimeout, errors.Cause(waitRun(t, runErr)))
+}
+
+func TestClient_Close(t *testing.T) {
+	t.Run("running", func(t *testing.T) {
+		client, _, cancel, runErr := newRunningTestClient(t, new(fakeBot), Config{})
+		defer cancel()
+
+		require.NoError(t, client.Close())
+		assert.NoError(t, waitRun(t, runErr))
+		assert.NoError(t, client.Close())
+	})
+
+	t.Run("not running", func(t *testing.T) {
+		client, _, _ := newTestClient(t)
+
+		assert.NoError(t, client.Close())
+		assert.NoError(t, client.Close())
+		assert.NoError(t, client.Run(context.Background()))
+	})
+}
+
+func TestNewWithBot_config(t *testing.T) {
+	tests := []struct {
+		name    string
+		cfg     Config
+		wantErr bool
+	}{
+		{name: "defaults", cfg: Config{AnonymousSecret: testAnonSecret}},
+		{name: "no anonymous secret", cfg: Config{}},
+		{name: "too many answers", cfg: Config{AnonymousSecret: testAnonSecret, MaximumAnswers: maximumAnswersLimit + 1}, wantErr: true},
+		{name: "unknown mode", cfg: Config{AnonymousSecret: testAnonSecret, Mode: "push"}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client, err := NewWithBot(new(fakeBot), cache.NewShared(), repository.NewMemory(), tt.cfg)
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+
+			require.NoError(t, err)
+			assert.NoError(t, client.Close())
+		})
+	}
+}
+
+func TestClient_draftLost(t *testing.T) {
+	const testGroupID = -3003
+	tests := []struct {
+		name       string
+		lose       func(c *Client)
+		wantChatID int64
+		wantText   string
+	}{
+		{
+			name: "expired draft in the storage",
+			lose: func(c *Client) {
+				c.pollsStore.Store(testUserID, &models.Poll{Owner: testOwnerName, ChatID: testGroupID})
+				c.deleteExpiredDrafts(time.Now().Add(2 * defaultDraftsTTL))
+			},
+			wantChatID: testGroupID,
+			wantText:   draftExpiredText,
+		},
+		{
+			name: "expired draft in the cache",
+			lose: func(c *Client) {
+				c.draftEvicted(testUserID, &models.Poll{ChatID: testGroupID}, cache.Expired)
+			},
+			wantChatID: testGroupID,
+			wantText:   draftExpiredText,
+		},
+		{
+			name: "evicted draft",
+			lose: func(c *Client) {
+				c.draftEvicted(testUserID, &models.Poll{ChatID: testGroupID}, cache.Evicted)
+			},
+			wantChatID: testGroupID,
+			wantText:   draftEvictedText,
+		},
+		{
+			name: "draft without chat",
+			lose: func(c *Client) {
+				c.draftEvicted(testUserID, &models.Poll{}, cache.Expired)
+			},
+			wantChatID: testUserID,
+			wantText:   draftExpiredText,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client, bot, _ := newTestClient(t)
+			tt.lose(client)
+
+			require.Len(t, bot.messages, 1)
+			assert.Equal(t, tt.wantChatID, bot.messages[0].ChatID)
+			assert.Equal(t, tt.wantText, bot.messages[0].Text)
+		})
+	}
+}
+
+func TestClient_withoutAnonymousSecret(t *testing.T) {
+	tests := []struct {
+		name         string
+		draft        *models.Poll
+		updates      []tgbot.Update
+		wantMessages []string
+		wantPoll     bool
+	}{
+		{
+			name:         "anonymous command",
+			draft:        &models.Poll{Owner: getOwner(testUserID, testOwnerName)},
+			updates:      []tgbot.Update{textUpdate(testUserID, "/anonymous")},
+			wantMessages: []string{anonymousDisabledText},
+		},
+		{
+			name:         "anonymous draft",
+			draft:        &models.Poll{PollName: testPollName, Owner: getOwner(testUserID, testOwnerName), Items: []string{testPollItem}, Anonymous: true},
+			updates:      []tgbot.Update{textUpdate(testUserID, "/done")},
+			wantMessages: []string{anonymousDisabledText + ". Try again to create a new poll"},
+		},
+		{
+			name:         "public poll",
+			draft:        &models.Poll{PollName: testPollName, Owner: getOwner(testUserID, testOwnerName), Items: []string{testPollItem}},
+			updates:      []tgbot.Update{textUpdate(testUserID, "/done")},
+			wantMessages: []string{"Use `share button` or put the next lines into your group: `@" + testBotName + " " + testPollName + "`"},
+			wantPoll:     true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			bot := new(fakeBot)
+			store := repository.NewMemory()
+			client, err := NewWithBot(bot, cache.NewShared(), store, Config{BotName: testBotName, UserIDs: []int{testUserID}})
+			require.NoError(t, err)
+			defer client.Close()
+
+			client.pollsStore.Store(testUserID, tt.draft)
+			handleUpdates(client, tt.updates...)
+
+			assert.Equal(t, tt.wantMessages, bot.messageTexts())
+			_, err = store.GetPoll(testPollName)
+			if tt.wantPoll {
+				assert.NoError(t, err)
+				return
+			}
+			assert.Equal(t, repository.ErrPollIsNotFound, errors.Cause(err))
+		})
+	}
+}
@@ -0,0 +1,480 @@
+// Package telegramtest provides a fake Telegram Bot API server for the end-to-end tests of the bot.
+// The bot is pointed at it with telegram.Config.APIEndpoint, so the requests pass through the real HTTP path of tgbot
+package telegramtest
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	tgbot "github.com/go-telegram-bot-api/telegram-bot-api"
+)
+
+const (
+	// maxPollWait limits a long polling request, so the bot notices its shutdown quickly
+	maxPollWait = time.Second
+	// waitInterval is how often Wait checks its condition
+	waitInterval = 10 * time.Millisecond
+)
+
+// Message is a message sent by the bot into a chat
+type Message struct {
+	ChatID      int64
+	MessageID   int
+	Text        string
+	ReplyMarkup *tgbot.InlineKeyboardMarkup
+}
+
+// Edit is an editMessageText request, either InlineMessageID or ChatID and MessageID are set
+type Edit struct {
+	InlineMessageID string
+	ChatID          int64
+	MessageID       int
+	Text            string
+	ReplyMarkup     *tgbot.InlineKeyboardMarkup
+}
+
+// InlineAnswer is the list of results the bot answered an inline query with
+type InlineAnswer struct {
+	InlineQueryID string
+	Results       []tgbot.InlineQueryResultArticle
+}
+
+// CallbackAnswer is the notification the bot showed for a button click
+type CallbackAnswer struct {
+	CallbackQueryID string
+	Text            string
+}
+
+// InlineMessage is a message posted into a chat from the inline results, it's changed by the edits
+type InlineMessage struct {
+	Text        string
+	ReplyMarkup *tgbot.InlineKeyboardMarkup
+}
+
+// Server serves the methods of the Bot API the bot uses and records what the bot has sent.
+// The updates are queued with the Send* methods and returned by getUpdates
+type Server struct {
+	Token string
+	Self  tgbot.User
+
+	srv *httptest.Server
+
+	mu              sync.Mutex
+	updates         []tgbot.Update
+	lastUpdateID    int
+	lastMessageID   int
+	lastQueryID     int
+	newUpdate       chan struct{}
+	closed          chan struct{}
+	closeOnce       sync.Once
+	messages        []Message
+	edits           []Edit
+	inlineAnswers   []InlineAnswer
+	callbackAnswers []CallbackAnswer
+	inlineMessages  map[string]*InlineMessage
+}
+
+type response struct {
+	Ok          bool        `json:"ok"`
+	Result      interface{} `json:"result,omitempty"`
+	ErrorCode   int         `json:"error_code,omitempty"`
+	Description string      `json:"description,omitempty"`
+}
+
+// NewServer starts a server which accepts only the token, Close stops it
+func NewServer(token string) *Server {
+	s := &Server{
+		Token:          token,
+		Self:           tgbot.User{ID: 1, FirstName: "Vote Bot", UserName: "vote_bot", IsBot: true},
+		newUpdate:      make(chan struct{}),
+		closed:         make(chan struct{}),
+		inlineMessages: make(map[string]*InlineMessage),
+	}
+	s.srv = httptest.NewServer(http.HandlerFunc(s.serveHTTP))
+
+	return s
+}
+
+// URL is the API endpoint of the server
+func (s *Server) URL() string {
+	return s.srv.URL
+}
+
+// Close wakes up the waiting long polling requests and stops the server
+func (s *Server) Close() {
+	s.closeOnce.Do(func() { close(s.closed) })
+	s.srv.Close()
+}
+
+// SendMessage queues a private message of the user to the bot
+func (s *Server) SendMessage(from tgbot.User, text string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.lastMessageID++
+	message := &tgbot.Message{
+		MessageID: s.lastMessageID,
+		From:      &from,
+		Date:      int(time.Now().Unix()),
+		Chat:      &tgbot.Chat{ID: int64(from.ID), Type: "private", UserName: from.UserName},
+		Text:      text,
+	}
+	if strings.HasPrefix(text, "/") {
+		length := strings.IndexAny(text, " \n")
+		if length < 0 {
+			length = len(text)
+		}
+		message.Entities = &[]tgbot.MessageEntity{{Type: "bot_command", Offset: 0, Length: length}}
+	}
+
+	s.addUpdate(tgbot.Update{Message: message})
+}
+
+// SendInlineQuery queues an inline query of the user and returns its ID
+func (s *Server) SendInlineQuery(from tgbot.User, query string) string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.lastQueryID++
+	id := strconv.Itoa(s.lastQueryID)
+	s.addUpdate(tgbot.Update{InlineQuery: &tgbot.InlineQuery{ID: id, From: &from, Query: query}})
+
+	return id
+}
+
+// ChooseInlineResult posts the result the bot answered the inline query with, as Telegram does when the user picks it,
+// and queues the chosen result. It returns the ID of the posted message, false if there's no such result
+func (s *Server) ChooseInlineResult(from tgbot.User, inlineQueryID, resultID string) (string, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, answer := range s.inlineAnswers {
+		if answer.InlineQueryID != inlineQueryID {
+			continue
+		}
+
+		for _, result := range answer.Results {
+			if result.ID != resultID {
+				continue
+			}
+
+			s.lastMessageID++
+			id := "inline-" + strconv.Itoa(s.lastMessageID)
+			s.inlineMessages[id] = &InlineMessage{Text: articleText(result), ReplyMarkup: result.ReplyMarkup}
+			s.addUpdate(tgbot.Update{ChosenInlineResult: &tgbot.ChosenInlineResult{
+				ResultID:        resultID,
+				From:            &from,
+				InlineMessageID: id,
+			}})
+
+			return id, true
+		}
+	}
+
+	return "", false
+}
+
+// PressButton queues a click of the user on the button of the inline message, false if there's no such button
+func (s *Server) PressButton(from tgbot.User, inlineMessageID, buttonText string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	message, ok := s.inlineMessages[inlineMessageID]
+	if !ok || message.ReplyMarkup == nil {
+		return false
+	}
+
+	for _, row := range message.ReplyMarkup.InlineKeyboard {
+		for _, button := range row {
+			if button.Text != buttonText || button.CallbackData == nil {
+				continue
+			}
+
+			s.lastQueryID++
+			s.addUpdate(tgbot.Update{CallbackQuery: &tgbot.CallbackQuery{
+				ID:              strconv.Itoa(s.lastQueryID),
+				From:            &from,
+				InlineMessageID: inlineMessageID,
+				ChatInstance:    inlineMessageID,
+				Data:            *button.CallbackData,
+			}})
+
+			return true
+		}
+	}
+
+	return false
+}
+
+func (s *Server) Messages() []Message {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return append([]Message(nil), s.messages...)
+}
+
+func (s *Server) Edits() []Edit {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return append([]Edit(nil), s.edits...)
+}
+
+func (s *Server) InlineAnswers() []InlineAnswer {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return append([]InlineAnswer(nil), s.inlineAnswers...)
+}
+
+func (s *Server) CallbackAnswers() []CallbackAnswer {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return append([]CallbackAnswer(nil), s.callbackAnswers...)
+}
+
+// InlineMessage returns the current state of the posted message
+func (s *Server) InlineMessage(id string) (InlineMessage, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	message, ok := s.inlineMessages[id]
+	if !ok {
+		return InlineMessage{}, false
+	}
+
+	return *message, true
+}
+
+// Wait checks the condition until it's true or the timeout passes, the bot handles the updates asynchronously
+func (s *Server) Wait(timeout time.Duration, cond func() bool) bool {
+	deadline := time.Now().Add(timeout)
+	for {
+		if cond() {
+			return true
+		}
+		if time.Now().After(deadline) {
+			return false
+		}
+
+		time.Sleep(waitInterval)
+	}
+}
+
+// addUpdate should be called under the lock, it wakes up the waiting long polling requests
+func (s *Server) addUpdate(update tgbot.Update) {
+	s.lastUpdateID++
+	update.UpdateID = s.lastUpdateID
+	s.updates = append(s.updates, update)
+
+	close(s.newUpdate)
+	s.newUpdate = make(chan struct{})
+}
+
+func (s *Server) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	parts := strings.Split(strings.TrimPrefix(r.URL.Path, "/"), "/")
+	if len(parts) != 2 || parts[0] != "bot"+s.Token {
+		writeResponse(w, http.StatusUnauthorized, response{ErrorCode: http.StatusUnauthorized, Description: "Unauthorized"})
+		return
+	}
+
+	if err := r.ParseForm(); err != nil {
+		writeResponse(w, http.StatusBadRequest, response{ErrorCode: http.StatusBadRequest, Description: "Bad Request: " + err.Error()})
+		return
+	}
+
+	var (
+		result interface{}
+		err    error
+	)
+	switch parts[1] {
+	case "getMe":
+		result = s.Self
+	case "getUpdates":
+		result = s.getUpdates(r)
+	case "sendMessage":
+		result, err = s.sendMessage(r)
+	case "editMessageText":
+		result, err = s.editMessageText(r)
+	case "answerInlineQuery":
+		result, err = s.answerInlineQuery(r)
+	case "answerCallbackQuery":
+		result = s.answerCallbackQuery(r)
+	case "setWebhook", "deleteWebhook":
+		result = true
+	default:
+		writeResponse(w, http.StatusNotFound, response{ErrorCode: http.StatusNotFound, Description: "Not Found: method not found"})
+		return
+	}
+	if err != nil {
+		writeResponse(w, http.StatusBadRequest, response{ErrorCode: http.StatusBadRequest, Description: "Bad Request: " + err.Error()})
+		return
+	}
+
+	writeResponse(w, http.StatusOK, response{Ok: true, Result: result})
+}
+
+// getUpdates confirms the updates before the offset and waits for a new one until the timeout passes
+func (s *Server) getUpdates(r *http.Request) []tgbot.Update {
+	offset, _ := strconv.Atoi(r.FormValue("offset"))
+	timeout, _ := strconv.Atoi(r.FormValue("timeout"))
+	wait := time.Duration(timeout) * time.Second
+	if wait > maxPollWait {
+		wait = maxPollWait
+	}
+
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+
+	for {
+		s.mu.Lock()
+		pending := s.updates[:0]
+		for _, update := range s.updates {
+			if update.UpdateID >= offset {
+				pending = append(pending, update)
+			}
+		}
+		s.updates = pending
+		updates := append([]tgbot.Update{}, pending...)
+		newUpdate := s.newUpdate
+		s.mu.Unlock()
+
+		if len(updates) != 0 {
+			return updates
+		}
+
+		select {
+		case <-newUpdate:
+		case <-timer.C:
+			return updates
+		case <-s.closed:
+			return updates
+		case <-r.Context().Done():
+			return updates
+		}
+	}
+}
+
+func (s *Server) sendMessage(r *http.Request) (tgbot.Message, error) {
+	chatID, err := strconv.ParseInt(r.FormValue("chat_id"), 10, 64)
+	if err != nil {
+		return tgbot.Message{}, err
+	}
+
+	markup, err := inlineKeyboard(r.FormValue("reply_markup"))
+	if err != nil {
+		return tgbot.Message{}, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.lastMessageID++
+	s.messages = append(s.messages, Message{ChatID: chatID, MessageID: s.lastMessageID, Text: r.FormValue("text"), ReplyMarkup: markup})
+
+	return tgbot.Message{
+		MessageID: s.lastMessageID,
+		From:      &s.Self,
+		Date:      int(time.Now().Unix()),
+		Chat:      &tgbot.Chat{ID: chatID},
+		Text:      r.FormValue("text"),
+	}, nil
+}
+
+// editMessageText returns true for an inline message as Telegram does, the messages in the chats aren't tracked
+func (s *Server) editMessageText(r *http.Request) (interface{}, error) {
+	markup, err := inlineKeyboard(r.FormValue("reply_markup"))
+	if err != nil {
+		return nil, err
+	}
+
+	edit := Edit{InlineMessageID: r.FormValue("inline_message_id"), Text: r.FormValue("text"), ReplyMarkup: markup}
+	if edit.InlineMessageID == "" {
+		if edit.ChatID, err = strconv.ParseInt(r.FormValue("chat_id"), 10, 64); err != nil {
+			return nil, err
+		}
+		if edit.MessageID, err = strconv.Atoi(r.FormValue("message_id")); err != nil {
+			return nil, err
+		}
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.edits = append(s.edits, edit)
+
+	if edit.InlineMessageID == "" {
+		return tgbot.Message{MessageID: edit.MessageID, Chat: &tgbot.Chat{ID: edit.ChatID}, Text: edit.Text}, nil
+	}
+
+	if message, ok := s.inlineMessages[edit.InlineMessageID]; ok {
+		message.Text = edit.Text
+		message.ReplyMarkup = markup
+	}
+
+	return true, nil
+}
+
+func (s *Server) answerInlineQuery(r *http.Request) (bool, error) {
+	var results []tgbot.InlineQueryResultArticle
+	if err := json.Unmarshal([]byte(r.FormValue("results")), &results); err != nil {
+		return false, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.inlineAnswers = append(s.inlineAnswers, InlineAnswer{InlineQueryID: r.FormValue("inline_query_id"), Results: results})
+
+	return true, nil
+}
+
+func (s *Server) answerCallbackQuery(r *http.Request) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.callbackAnswers = append(s.callbackAnswers, CallbackAnswer{CallbackQueryID: r.FormValue("callback_query_id"), Text: r.FormValue("text")})
+
+	return true
+}
+
+// inlineKeyboard decodes the reply markup, the other keyboards are ignored
+func inlineKeyboard(data string) (*tgbot.InlineKeyboardMarkup, error) {
+	if data == "" {
+		return nil, nil
+	}
+
+	var markup tgbot.InlineKeyboardMarkup
+	if err := json.Unmarshal([]byte(data), &markup); err != nil {
+		return nil, err
+	}
+	if markup.InlineKeyboard == nil {
+		return nil, nil
+	}
+
+	return &markup, nil
+}
+
+// articleText returns the text of the message an article is posted as
+func articleText(article tgbot.InlineQueryResultArticle) string {
+	content, ok := article.InputMessageContent.(map[string]interface{})
+	if !ok {
+		return ""
+	}
+
+	text, _ := content["message_text"].(string)
+
+	return text
+}
+
+func writeResponse(w http.ResponseWriter, status int, resp response) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(resp)
+}
@@ -0,0 +1,233 @@
+package telegram
+
+import (
+	"context"
+	"crypto/subtle"
+	"crypto/tls"
+	"encoding/json"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strconv"
+	"time"
+
+	tgbot "github.com/go-telegram-bot-api/telegram-bot-api"
+	"github.com/pkg/errors"
+)
+
+const (
+	// PollingMode receives the updates with the getUpdates long polling
+	PollingMode = "polling"
+	// WebhookMode receives the updates with the HTTP requests sent by Telegram
+	WebhookMode = "webhook"
+
+	defaultPollingTimeout = 60 * time.Second
+	defaultWebhookListen  = ":8443"
+
+	// secretTokenHeader is the header Telegram puts the secret token of the webhook into
+	secretTokenHeader = "X-Telegram-Bot-Api-Secret-Token"
+	// maxUpdateSize limits the body of a webhook request
+	maxUpdateSize = 1 << 20
+
+	webhookShutdownTimeout = 5 * time.Second
+	// webhookReadHeaderTimeout drops the connections which don't send the request headers
+	webhookReadHeaderTimeout = 10 * time.Second
+	// webhookBuffer is the size of the updates buffer tgbot uses for the long polling
+	webhookBuffer = 100
+)
+
+var secretTokenRe = regexp.MustCompile(`^[A-Za-z0-9_-]{1,256}$`)
+
+// WebhookConfig describes the server the updates are sent to in the webhook mode
+type WebhookConfig struct {
+	// URL is the public HTTPS address Telegram sends the updates to, its path is served by the bot
+	URL string
+	// Listen is the address of the embedded server, ":8443" by default
+	Listen string
+	// CertFile and KeyFile switch the server to HTTPS, without them the server expects a TLS terminating proxy
+	CertFile string
+	KeyFile  string
+	// SelfSigned uploads CertFile to Telegram, it's needed for a self-signed certificate
+	SelfSigned bool
+	// SecretToken is sent by Telegram with every update, the requests without it are rejected.
+	// It's required: the updates are trusted, so a forged one could act as an allowed user
+	SecretToken    string
+	MaxConnections int
+	// KeepOnStop leaves the webhook registered on stop, e.g. for a rolling deploy where the new instance has set it already.
+	// By default it's removed, so the long polling works after a stop and Telegram doesn't deliver to a stopped server
+	KeepOnStop bool
+}
+
+func (cfg WebhookConfig) validate() error {
+	link, err := url.Parse(cfg.URL)
+	if err != nil || link.Scheme != "https" || link.Host == "" {
+		return errors.Errorf("webhook url '%s' should be an absolute https url", cfg.URL)
+	}
+
+	if (cfg.CertFile == "") != (cfg.KeyFile == "") {
+		return errors.New("both webhook certificate and key files should be set")
+	}
+
+	if cfg.SelfSigned && cfg.CertFile == "" {
+		return errors.New("self-signed webhook requires the certificate file")
+	}
+
+	if cfg.SecretToken == "" {
+		return errors.New("webhook secret token is required")
+	}
+
+	if !secretTokenRe.MatchString(cfg.SecretToken) {
+		return errors.New("webhook secret token should have 1-256 symbols A-Z, a-z, 0-9, _ and -")
+	}
+
+	return nil
+}
+
+// pollUpdates drops a webhook left by the webhook mode, Telegram doesn't return the updates while it's set
+func (c *Client) pollUpdates() (tgbot.UpdatesChannel, error) {
+	if err := c.deleteWebhook(); err != nil {
+		return nil, err
+	}
+
+	updateConfig := tgbot.NewUpdate(0)
+	updateConfig.Timeout = int(c.pollingTimeout / time.Second)
+
+	return c.bot.GetUpdatesChan(updateConfig)
+}
+
+// listenWebhook starts the embedded server and registers it in Telegram. The address is bound and the certificate
+// is loaded before the registration, so their errors are returned instead of leaving Telegram without a server
+func (c *Client) listenWebhook() (tgbot.UpdatesChannel, error) {
+	link, err := url.Parse(c.webhook.URL)
+	if err != nil {
+		return nil, errors.Wrap(err, "bad webhook url")
+	}
+
+	path := link.Path
+	if path == "" {
+		path = "/"
+	}
+
+	updates := make(chan tgbot.Update, webhookBuffer)
+	mux := http.NewServeMux()
+	mux.Handle(path, webhookHandler(c.webhook.SecretToken, updates))
+
+	listen := c.webhook.Listen
+	if listen == "" {
+		listen = defaultWebhookListen
+	}
+	server := &http.Server{Addr: listen, Handler: mux, ReadHeaderTimeout: webhookReadHeaderTimeout}
+	if c.webhook.CertFile != "" {
+		cert, err := tls.LoadX509KeyPair(c.webhook.CertFile, c.webhook.KeyFile)
+		if err != nil {
+			return nil, errors.Wrap(err, "load webhook certificate failed")
+		}
+		server.TLSConfig = &tls.Config{Certificates: []tls.Certificate{cert}}
+	}
+
+	listener, err := net.Listen("tcp", listen)
+	if err != nil {
+		return nil, errors.Wrap(err, "webhook listen failed")
+	}
+	c.webhookServer = server
+
+	go func() {
+		var err error
+		if server.TLSConfig != nil {
+			// the certificate is taken from TLSConfig
+			err = server.ServeTLS(listener, "", "")
+		} else {
+			err = server.Serve(listener)
+		}
+		if err != nil && err != http.ErrServerClosed {
+			log.Printf("webhook server error: %s", err)
+		}
+	}()
+
+	if err := c.setWebhook(); err != nil {
+		c.webhookServer.Close()
+		return nil, err
+	}
+
+	log.Printf("listening for webhook on %s%s", listen, path)
+
+	return updates, nil
+}
+
+// setWebhook is made without the library helper, it can't pass the secret token
+func (c *Client) setWebhook() error {
+	params := map[string]string{"url": c.webhook.URL}
+	if c.webhook.MaxConnections != 0 {
+		params["max_connections"] = strconv.Itoa(c.webhook.MaxConnections)
+	}
+	if c.webhook.SecretToken != "" {
+		params["secret_token"] = c.webhook.SecretToken
+	}
+
+	var err error
+	if c.webhook.SelfSigned {
+		_, err = c.bot.UploadFile("setWebhook", params, "certificate", c.webhook.CertFile)
+	} else {
+		values := url.Values{}
+		for key, value := range params {
+			values.Set(key, value)
+		}
+		_, err = c.bot.MakeRequest("setWebhook", values)
+	}
+
+	return errors.Wrap(err, "set webhook failed")
+}
+
+func (c *Client) deleteWebhook() error {
+	_, err := c.bot.MakeRequest("deleteWebhook", url.Values{})
+
+	return errors.Wrap(err, "delete webhook failed")
+}
+
+// stopWebhook unregisters the webhook unless KeepOnStop is set, so Telegram keeps the updates until the bot is started again,
+// and stops the server
+func (c *Client) stopWebhook() {
+	if !c.webhook.KeepOnStop {
+		if err := c.deleteWebhook(); err != nil {
+			log.Printf("stop webhook: %s", err)
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), webhookShutdownTimeout)
+	defer cancel()
+
+	if err := c.webhookServer.Shutdown(ctx); err != nil {
+		log.Printf("webhook server shutdown error: %s", err)
+	}
+}
+
+// webhookHandler passes the updates sent by Telegram to the channel,
+// the requests without the secret token are rejected, all of them are rejected without the token
+func webhookHandler(secretToken string, updates chan<- tgbot.Update) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+			return
+		}
+
+		if secretToken == "" || subtle.ConstantTimeCompare([]byte(r.Header.Get(secretTokenHeader)), []byte(secretToken)) != 1 {
+			http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+			return
+		}
+
+		var update tgbot.Update
+		if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxUpdateSize)).Decode(&update); err != nil {
+			http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+			return
+		}
+
+		select {
+		case updates <- update:
+		case <-r.Context().Done():
+			// Telegram retries the update which isn't confirmed
+			http.Error(w, http.StatusText(http.StatusServiceUnavailable), http.StatusServiceUnavailable)
+		}
+	})
+}
@@ -0,0 +1,183 @@
+package telegram
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	tgbot "github.com/go-telegram-bot-api/telegram-bot-api"
+	"github.com/incu6us/vote-bot/cache"
+	"github.com/incu6us/vote-bot/repository"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_webhookHandler(t *testing.T) {
+	tests := []struct {
+		name        string
+		secretToken string
+		method      string
+		header      string
+		body        string
+		wantStatus  int
+		wantUpdate  *tgbot.Update
+	}{
+		{
+			name:       "handler without secret",
+			method:     http.MethodPost,
+			body:       `{"update_id": 42}`,
+			wantStatus: http.StatusUnauthorized,
+		},
+		{
+			name:        "update with secret",
+			secretToken: "secret",
+			method:      http.MethodPost,
+			header:      "secret",
+			body:        `{"update_id": 42, "callback_query": {"id": "1", "data": "vote"}}`,
+			wantStatus:  http.StatusOK,
+			wantUpdate:  &tgbot.Update{UpdateID: 42, CallbackQuery: &tgbot.CallbackQuery{ID: "1", Data: "vote"}},
+		},
+		{
+			name:        "missing secret",
+			secretToken: "secret",
+			method:      http.MethodPost,
+			body:        `{"update_id": 42}`,
+			wantStatus:  http.StatusUnauthorized,
+		},
+		{
+			name:        "wrong secret",
+			secretToken: "secret",
+			method:      http.MethodPost,
+			header:      "secrets",
+			body:        `{"update_id": 42}`,
+			wantStatus:  http.StatusUnauthorized,
+		},
+		{
+			name:        "wrong method",
+			secretToken: "secret",
+			method:      http.MethodGet,
+			header:      "secret",
+			wantStatus:  http.StatusMethodNotAllowed,
+		},
+		{
+			name:        "bad body",
+			secretToken: "secret",
+			method:      http.MethodPost,
+			header:      "secret",
+			body:        `{"update_id":`,
+			wantStatus:  http.StatusBadRequest,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			updates := make(chan tgbot.Update, 1)
+			req := httptest.NewRequest(tt.method, "/telegram", strings.NewReader(tt.body))
+			if tt.header != "" {
+				req.Header.Set(secretTokenHeader, tt.header)
+			}
+			rec := httptest.NewRecorder()
+
+			webhookHandler(tt.secretToken, updates).ServeHTTP(rec, req)
+
+			assert.Equal(t, tt.wantStatus, rec.Code)
+			if tt.wantUpdate == nil {
+				assert.Empty(t, updates)
+				return
+			}
+			if assert.Len(t, updates, 1) {
+				assert.Equal(t, *tt.wantUpdate, <-updates)
+			}
+		})
+	}
+}
+
+func TestWebhookConfig_validate(t *testing.T) {
+	tests := []struct {
+		name    string
+		cfg     WebhookConfig
+		wantErr bool
+	}{
+		{name: "behind proxy", cfg: WebhookConfig{URL: "https://bot.example.com/telegram", SecretToken: "abc-DEF_123"}},
+		{name: "https server", cfg: WebhookConfig{URL: "https://bot.example.com:8443/", CertFile: "cert.pem", KeyFile: "key.pem", SelfSigned: true, SecretToken: "secret"}},
+		{name: "plain http url", cfg: WebhookConfig{URL: "http://bot.example.com/telegram", SecretToken: "secret"}, wantErr: true},
+		{name: "relative url", cfg: WebhookConfig{URL: "/telegram", SecretToken: "secret"}, wantErr: true},
+		{name: "certificate without key", cfg: WebhookConfig{URL: "https://bot.example.com", CertFile: "cert.pem", SecretToken: "secret"}, wantErr: true},
+		{name: "self-signed without certificate", cfg: WebhookConfig{URL: "https://bot.example.com", SelfSigned: true, SecretToken: "secret"}, wantErr: true},
+		{name: "no secret token", cfg: WebhookConfig{URL: "https://bot.example.com"}, wantErr: true},
+		{name: "bad secret token", cfg: WebhookConfig{URL: "https://bot.example.com", SecretToken: "not allowed!"}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.cfg.validate()
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+		})
+	}
+}
+
+func TestClient_listenWebhook(t *testing.T) {
+	busy, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer busy.Close()
+
+	tests := []struct {
+		name    string
+		cfg     WebhookConfig
+		wantErr bool
+		// wantRequests are the methods called by the start and the stop
+		wantRequests []string
+	}{
+		{
+			name:         "listening",
+			cfg:          WebhookConfig{Listen: "127.0.0.1:0"},
+			wantRequests: []string{"setWebhook", "deleteWebhook"},
+		},
+		{
+			name:         "kept on stop",
+			cfg:          WebhookConfig{Listen: "127.0.0.1:0", KeepOnStop: true},
+			wantRequests: []string{"setWebhook"},
+		},
+		{
+			name:    "address in use",
+			cfg:     WebhookConfig{Listen: busy.Addr().String()},
+			wantErr: true,
+		},
+		{
+			name:    "missing certificate",
+			cfg:     WebhookConfig{Listen: "127.0.0.1:0", CertFile: "missing.pem", KeyFile: "missing.key"},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			bot := new(fakeBot)
+			tt.cfg.URL = "https://bot.example.com/telegram"
+			tt.cfg.SecretToken = "secret"
+			client, err := NewWithBot(bot, cache.NewShared(), repository.NewMemory(), Config{
+				AnonymousSecret: testAnonSecret,
+				Mode:            WebhookMode,
+				Webhook:         tt.cfg,
+			})
+			require.NoError(t, err)
+
+			_, err = client.listenWebhook()
+			if tt.wantErr {
+				assert.Error(t, err)
+				assert.Empty(t, bot.requests, "webhook is registered without a server")
+				return
+			}
+
+			require.NoError(t, err)
+			client.stopWebhook()
+			require.Len(t, bot.requests, len(tt.wantRequests))
+			for i, method := range tt.wantRequests {
+				assert.True(t, strings.HasPrefix(bot.requests[i], method+" "), bot.requests[i])
+			}
+		})
+	}
+}
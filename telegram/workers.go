@@ -0,0 +1,134 @@
+package telegram
+
+import (
+	"context"
+	"sync"
+
+	tgbot "github.com/go-telegram-bot-api/telegram-bot-api"
+)
+
+const (
+	defaultWorkers = 8
+	// workerQueueSize is the number of updates waiting for a busy worker before the dispatching blocks
+	workerQueueSize = 100
+	// updatePollBuffer lets the workers go on while the poll messages are being edited
+	updatePollBuffer = 100
+)
+
+// messageListen dispatches the updates to the workers until stop is closed, then stops receiving the updates
+// and lets the workers finish the ones received already, the updates not started before ctx is done are dropped.
+// The updates of a user always go to the same worker, so they are handled in order
+func (c *Client) messageListen(ctx context.Context, stop <-chan struct{}) {
+	var wg sync.WaitGroup
+	queues := make([]chan tgbot.Update, c.workers)
+	for i := range queues {
+		queues[i] = make(chan tgbot.Update, workerQueueSize)
+		wg.Add(1)
+		go func(updates <-chan tgbot.Update) {
+			defer wg.Done()
+
+			for update := range updates {
+				if ctx.Err() != nil {
+					continue
+				}
+
+				c.HandleUpdate(update)
+			}
+		}(queues[i])
+	}
+
+	defer func() {
+		for _, queue := range queues {
+			close(queue)
+		}
+		wg.Wait()
+	}()
+
+	dispatch := func(update tgbot.Update) {
+		queues[workerIndex(update, len(queues))] <- update
+	}
+
+	for {
+		select {
+		case <-stop:
+			c.drainUpdates(dispatch)
+			return
+		case update, ok := <-c.updateMessageCh:
+			if !ok {
+				return
+			}
+
+			dispatch(update)
+		}
+	}
+}
+
+// drainUpdates stops receiving the updates and dispatches the ones left in the channel.
+// The channel is read while the receiving stops, so the webhook requests waiting for it could finish
+func (c *Client) drainUpdates(dispatch func(update tgbot.Update)) {
+	stopped := make(chan struct{})
+	go func() {
+		c.stopReceiving()
+		close(stopped)
+	}()
+
+	for {
+		select {
+		case update, ok := <-c.updateMessageCh:
+			if !ok {
+				<-stopped
+				return
+			}
+
+			dispatch(update)
+		case <-stopped:
+			for {
+				select {
+				case update, ok := <-c.updateMessageCh:
+					if !ok {
+						return
+					}
+
+					dispatch(update)
+				default:
+					return
+				}
+			}
+		}
+	}
+}
+
+func workerIndex(update tgbot.Update, workers int) int {
+	key := updateKey(update)
+	if key < 0 {
+		key = -key
+	}
+
+	return int(key % int64(workers))
+}
+
+// updateKey returns the user the update comes from: the poll wizard keeps its state per user.
+// The updates without a user (e.g. the channel posts) are grouped by the chat
+func updateKey(update tgbot.Update) int64 {
+	switch {
+	case update.Message != nil:
+		if update.Message.From != nil {
+			return int64(update.Message.From.ID)
+		}
+		if update.Message.Chat != nil {
+			return update.Message.Chat.ID
+		}
+	case update.EditedMessage != nil && update.EditedMessage.Chat != nil:
+		return update.EditedMessage.Chat.ID
+	case update.ChannelPost != nil && update.ChannelPost.Chat != nil:
+		return update.ChannelPost.Chat.ID
+	case update.CallbackQuery != nil && update.CallbackQuery.From != nil:
+		return int64(update.CallbackQuery.From.ID)
+	case update.InlineQuery != nil && update.InlineQuery.From != nil:
+		return int64(update.InlineQuery.From.ID)
+	case update.ChosenInlineResult != nil && update.ChosenInlineResult.From != nil:
+		return int64(update.ChosenInlineResult.From.ID)
+	}
+
+	return 0
+}
@@ -0,0 +1,112 @@
+package telegram
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	tgbot "github.com/go-telegram-bot-api/telegram-bot-api"
+	"github.com/incu6us/vote-bot/cache"
+	"github.com/incu6us/vote-bot/repository"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestUpdateKey(t *testing.T) {
+	user := &tgbot.User{ID: testUserID}
+	chat := &tgbot.Chat{ID: -500}
+
+	tests := []struct {
+		name   string
+		update tgbot.Update
+		want   int64
+	}{
+		{name: "message", update: tgbot.Update{Message: &tgbot.Message{From: user, Chat: chat}}, want: testUserID},
+		{name: "message without user", update: tgbot.Update{Message: &tgbot.Message{Chat: chat}}, want: -500},
+		{name: "channel post", update: tgbot.Update{ChannelPost: &tgbot.Message{Chat: chat}}, want: -500},
+		{name: "callback", update: tgbot.Update{CallbackQuery: &tgbot.CallbackQuery{From: user}}, want: testUserID},
+		{name: "inline query", update: tgbot.Update{InlineQuery: &tgbot.InlineQuery{From: user}}, want: testUserID},
+		{name: "chosen inline result", update: tgbot.Update{ChosenInlineResult: &tgbot.ChosenInlineResult{From: user}}, want: testUserID},
+		{name: "empty", update: tgbot.Update{}, want: 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, updateKey(tt.update))
+			assert.Equal(t, workerIndex(tt.update, 3), workerIndex(tt.update, 3))
+		})
+	}
+}
+
+// TestClient_messageListen checks that a user waiting for a slow request doesn't stall the others
+// and the queued updates of the user are handled in order before the listener returns
+func TestClient_messageListen(t *testing.T) {
+	const (
+		slowUser = 1001
+		fastUser = 1002
+	)
+
+	release := make(chan struct{})
+	bot := &fakeBot{beforeSend: func(c tgbot.Chattable) {
+		if msg, ok := c.(tgbot.MessageConfig); ok && msg.ChatID == slowUser {
+			<-release
+		}
+	}}
+	store := repository.NewMemory()
+	client, err := NewWithBot(bot, cache.NewShared(), store, Config{
+		BotName:         testBotName,
+		UserIDs:         []int{slowUser, fastUser},
+		DraftsInMemory:  true,
+		Workers:         2,
+		AnonymousSecret: testAnonSecret,
+	})
+	require.NoError(t, err)
+	require.NotEqual(t, workerIndex(textUpdate(slowUser, ""), 2), workerIndex(textUpdate(fastUser, ""), 2))
+
+	updates := make(chan tgbot.Update)
+	client.updateMessageCh = updates
+	stop := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		client.messageListen(context.Background(), stop)
+		close(done)
+	}()
+
+	for _, text := range []string{"/newpoll", testPollName, testPollItem, testPollItem2, "/done"} {
+		updates <- textUpdate(slowUser, text)
+	}
+	updates <- textUpdate(fastUser, "/help")
+
+	require.True(t, waitFor(func() bool {
+		bot.mu.Lock()
+		defer bot.mu.Unlock()
+
+		for _, msg := range bot.messages {
+			if msg.ChatID == fastUser {
+				return true
+			}
+		}
+		return false
+	}), "fast user is stalled")
+
+	close(release)
+	close(stop)
+	<-done
+
+	poll, err := store.GetPoll(testPollName)
+	require.NoError(t, err)
+	assert.Equal(t, []string{testPollItem, testPollItem2}, poll.Items)
+}
+
+// waitFor checks the condition until it's true or a few seconds pass
+func waitFor(cond func() bool) bool {
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		if cond() {
+			return true
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	return cond()
+}